@@ -130,6 +130,15 @@ type CreateDBRequest struct {
 // CreateDBResponse is the response object for creating a database
 type CreateDBResponse struct {
 	URI string `json:"uri"`
+
+	Host     string `json:"host"`
+	Port     uint32 `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+
+	AppliedMigrations []string `json:"applied_migrations,omitempty"`
+	AppliedFixtures   []string `json:"applied_fixtures,omitempty"`
 }
 
 // RemoveDBRequest is the request object for removing a database
@@ -19,7 +19,7 @@ func GetDescribePgCmd() *cobra.Command {
 		RunE:    describePostgres,
 	}
 
-	cmd.Flags().StringP("version", "v", "", "Database version, default 14.3.2")
+	cmd.Flags().StringP("version", "v", "", "Database version, a major version like 16 or a postgres.postgis tuple like 16.3.4, default 13-3.1")
 	cmd.Flags().StringP("migrations", "m", "", "Path to migration files")
 	cmd.Flags().StringP("schema", "s", "public", "Schema name to describe, default public")
 	cmd.Flags().StringP("table", "t", "*", "Table name to describe, default * (all tables)")
@@ -54,7 +54,8 @@ func describePostgres(cmd *cobra.Command, _ []string) error {
 	db, err := pg.New(
 		pg.WithHost("postgres", "postgres", "postgres", uint32(portNumber)),
 		pg.WithVersion(version),
-		pg.WithLogger(os.Stdout),
+		// keep the table description alone on stdout
+		pg.WithLogger(os.Stderr),
 		pg.WithMigrations(migrations),
 	)
 	if err != nil {
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+	"github.com/mirzakhany/dbctl/internal/table"
+	"github.com/mirzakhany/dbctl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// GetDoctorCmd represents the doctor command
+func GetDoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "check the environment dbctl needs to run a postgres database",
+		Long: `checks docker connectivity, the availability of the port, the postgres image and the free disk space
+		of the data directory, and how to fix the failed checks. Checking the image may pull it.`,
+		RunE: runDoctor,
+	}
+
+	cmd.Flags().Uint32P("port", "p", pg.DefaultPort, "Postgres port to check")
+	cmd.Flags().StringP("version", "v", "", "Postgres version to check the image of, like start postgres --version, default 13-3.1")
+	cmd.Flags().String("data-dir", "", "Host data directory to check the free disk space of")
+	cmd.Flags().String("pull-policy", "", "Pull policy of the image: Always (default), IfNotPresent or Never")
+	return cmd
+}
+
+func runDoctor(cmd *cobra.Command, _ []string) error {
+	port, err := cmd.Flags().GetUint32("port")
+	if err != nil {
+		return fmt.Errorf("invalid port args, %w", err)
+	}
+
+	version, err := cmd.Flags().GetString("version")
+	if err != nil {
+		return fmt.Errorf("invalid version args, %w", err)
+	}
+
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		return fmt.Errorf("invalid data-dir args, %w", err)
+	}
+
+	pullPolicy, err := cmd.Flags().GetString("pull-policy")
+	if err != nil {
+		return fmt.Errorf("invalid pull-policy args, %w", err)
+	}
+
+	db, err := pg.New(
+		pg.WithHost(pg.DefaultUser, pg.DefaultPass, pg.DefaultName, port),
+		pg.WithVersion(version),
+		pg.WithDataDir(dataDir),
+		pg.WithPullPolicy(pullPolicy),
+	)
+	if err != nil {
+		return err
+	}
+
+	t := table.New(os.Stdout)
+	t.AddRow("Check", "Status", "Message", "Hint")
+	failed := false
+	for _, r := range db.Doctor(utils.ContextWithOsSignal()) {
+		status := "ok"
+		if !r.OK {
+			status, failed = "failed", true
+		}
+		t.AddRow(r.Name, status, r.Message, r.Hint)
+	}
+	t.Print()
+
+	if failed {
+		// the table already tells what failed and how to fix it
+		cmd.SilenceUsage = true
+		return errors.New("some checks failed")
+	}
+	return nil
+}
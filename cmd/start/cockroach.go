@@ -0,0 +1,77 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/mirzakhany/dbctl/internal/database/cockroach"
+	"github.com/mirzakhany/dbctl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// GetCockroachCmd represents the cockroach command
+func GetCockroachCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Aliases: []string{"crdb"},
+		Use:     "cockroach",
+		Short:   "Run a single node cockroach instance",
+		RunE:    runCockroach,
+	}
+
+	cmd.Flags().Uint32P("port", "p", cockroach.DefaultPort, "cockroach default port")
+	cmd.Flags().StringP("name", "n", cockroach.DefaultName, "Database name")
+	cmd.Flags().StringP("version", "v", "", "Database version, default 23.1.11")
+	cmd.Flags().StringP("migrations", "m", "", "Path to migration files, will be applied if provided")
+	cmd.Flags().StringP("fixtures", "f", "", "Path to fixture files (.sql, .csv, .yaml or .json), its can be a file or directory.files in directory will be sorted by name before applying.")
+
+	return cmd
+}
+
+func runCockroach(cmd *cobra.Command, _ []string) error {
+	port, err := cmd.Flags().GetUint32("port")
+	if err != nil {
+		return fmt.Errorf("invalid port args, %w", err)
+	}
+
+	label, err := cmd.Flags().GetString("label")
+	if err != nil {
+		return fmt.Errorf("invalid label args, %w", err)
+	}
+
+	detach, err := cmd.Flags().GetBool("detach")
+	if err != nil {
+		return fmt.Errorf("invalid detach args, %w", err)
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return fmt.Errorf("invalid name args, %w", err)
+	}
+
+	version, err := cmd.Flags().GetString("version")
+	if err != nil {
+		return fmt.Errorf("invalid version args, %w", err)
+	}
+
+	migrationsPath, err := cmd.Flags().GetString("migrations")
+	if err != nil {
+		return fmt.Errorf("invalid migrations args, %w", err)
+	}
+
+	fixturesPath, err := cmd.Flags().GetString("fixtures")
+	if err != nil {
+		return fmt.Errorf("invalid fixtures args, %w", err)
+	}
+
+	db, err := cockroach.New(
+		cockroach.WithHost(cockroach.DefaultUser, name, port),
+		cockroach.WithVersion(version),
+		cockroach.WithMigrations(migrationsPath),
+		cockroach.WithFixtures(fixturesPath),
+		cockroach.WithLabel(label),
+	)
+	if err != nil {
+		return err
+	}
+
+	return db.Start(utils.ContextWithOsSignal(), detach)
+}
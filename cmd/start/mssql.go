@@ -0,0 +1,95 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/mirzakhany/dbctl/internal/database/mssql"
+	"github.com/mirzakhany/dbctl/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// GetMSSQLCmd represents the mssql command
+func GetMSSQLCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Aliases: []string{"sqlserver"},
+		Use:     "mssql",
+		Short:   "Run a SQL Server instance",
+		RunE:    runMSSQL,
+	}
+
+	cmd.Flags().Uint32P("port", "p", mssql.DefaultPort, "mssql default port")
+	cmd.Flags().StringP("pass", "s", mssql.DefaultPass, "Password of the sa login")
+	cmd.Flags().StringP("name", "n", mssql.DefaultName, "Database name")
+	cmd.Flags().StringP("version", "v", "", "Database version, default 2022")
+	cmd.Flags().StringP("migrations", "m", "", "Path to migration files, will be applied if provided")
+	cmd.Flags().StringP("fixtures", "f", "", "Path to fixture files, its can be a file or directory.files in directory will be sorted by name before applying.")
+	cmd.Flags().Bool("accept-eula", false, "Accept the SQL Server end user license agreement, like ACCEPT_EULA=Y")
+
+	return cmd
+}
+
+func runMSSQL(cmd *cobra.Command, _ []string) error {
+	port, err := cmd.Flags().GetUint32("port")
+	if err != nil {
+		return fmt.Errorf("invalid port args, %w", err)
+	}
+
+	pass, err := cmd.Flags().GetString("pass")
+	if err != nil {
+		return fmt.Errorf("invalid pass args, %w", err)
+	}
+
+	label, err := cmd.Flags().GetString("label")
+	if err != nil {
+		return fmt.Errorf("invalid label args, %w", err)
+	}
+
+	detach, err := cmd.Flags().GetBool("detach")
+	if err != nil {
+		return fmt.Errorf("invalid detach args, %w", err)
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return fmt.Errorf("invalid name args, %w", err)
+	}
+
+	version, err := cmd.Flags().GetString("version")
+	if err != nil {
+		return fmt.Errorf("invalid version args, %w", err)
+	}
+
+	migrationsPath, err := cmd.Flags().GetString("migrations")
+	if err != nil {
+		return fmt.Errorf("invalid migrations args, %w", err)
+	}
+
+	fixturesPath, err := cmd.Flags().GetString("fixtures")
+	if err != nil {
+		return fmt.Errorf("invalid fixtures args, %w", err)
+	}
+
+	opts := []mssql.Option{
+		mssql.WithHost(pass, name, port),
+		mssql.WithVersion(version),
+		mssql.WithMigrations(migrationsPath),
+		mssql.WithFixtures(fixturesPath),
+		mssql.WithLabel(label),
+	}
+
+	acceptEULA, err := cmd.Flags().GetBool("accept-eula")
+	if err != nil {
+		return fmt.Errorf("invalid accept-eula args, %w", err)
+	}
+	// without the flag the ACCEPT_EULA environment variable decides
+	if acceptEULA {
+		opts = append(opts, mssql.WithAcceptEULA(true))
+	}
+
+	db, err := mssql.New(opts...)
+	if err != nil {
+		return err
+	}
+
+	return db.Start(utils.ContextWithOsSignal(), detach)
+}
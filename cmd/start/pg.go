@@ -1,11 +1,13 @@
 package start
 
 import (
+	"context"
 	"fmt"
-	"io"
+	"os"
+	"time"
 
 	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
-	"github.com/mirzakhany/dbctl/internal/utils"
+	"github.com/mirzakhany/dbctl/internal/logger"
 	"github.com/spf13/cobra"
 )
 
@@ -22,9 +24,29 @@ func GetPgCmd() *cobra.Command {
 	cmd.Flags().StringP("user", "u", pg.DefaultUser, "Database username")
 	cmd.Flags().String("pass", pg.DefaultPass, "Database password")
 	cmd.Flags().StringP("name", "n", pg.DefaultName, "Database name")
-	cmd.Flags().StringP("version", "v", "", "Database version, default 14.3.2")
-	cmd.Flags().StringP("migrations", "m", "", "Path to migration files, will be applied if provided")
-	cmd.Flags().StringP("fixtures", "f", "", "Path to fixture files, its can be a file or directory.files in directory will be sorted by name before applying.")
+	cmd.Flags().StringP("version", "v", "", "Database version, a major version like 16 or a postgres.postgis tuple like 16.3.4, default 13-3.1")
+	cmd.Flags().StringArrayP("migrations", "m", nil, "Path or http url of migration files, will be applied if provided. Repeat it for several paths, their files are ordered by numeric prefix")
+	cmd.Flags().StringP("fixtures", "f", "", "Path to fixture files (.sql, .csv, .yaml or .json), its can be a file or directory.files in directory will be sorted by name before applying.")
+	cmd.Flags().String("ui-backend", string(pg.UIPgweb), "Web ui started with --ui, pgweb or adminer")
+	cmd.Flags().String("log-format", string(pg.LogText), "Log format, text or json")
+	cmd.Flags().String("fixture-profile", "", "Apply only the common and this subdirectory of the fixtures directory, like ci for fixtures/common and fixtures/ci")
+	cmd.Flags().Bool("reset-sequences", false, "Advance sequences to the largest ids once fixtures are applied, for fixtures inserting explicit ids")
+	cmd.Flags().Duration("idle-timeout", 0, "Stop the database after having no client connections for this duration, e.g. 2h")
+	cmd.Flags().Bool("dry-run", false, "Print the resolved container configuration and sql files without starting anything")
+	cmd.Flags().Bool("keep-on-failure", false, "Keep the container running for inspection if migrations or fixtures fail")
+	cmd.Flags().String("restart-policy", "", "Docker restart policy of the container, like unless-stopped to keep a detached database across reboots")
+	cmd.Flags().String("instance", "", "Instance name, to run several databases side by side and stop one with dbctl stop <name>")
+	cmd.Flags().String("network", "", "User defined docker network to join, so other containers reach the database by its name")
+	cmd.Flags().StringSlice("network-alias", nil, "Extra host names of the database in --network, like db")
+	cmd.Flags().String("pull-policy", "", "When to pull the postgres image: Always (default), IfNotPresent or Never")
+	cmd.Flags().Duration("shutdown-timeout", 10*time.Second, "How long postgres may take to shut down before its container is killed")
+	cmd.Flags().Duration("pull-timeout", 10*time.Minute, "How long pulling the image and creating the container may take")
+	cmd.Flags().String("env-file", "", "Write the connection details as .env lines to this file once the database is ready")
+	cmd.Flags().String("env-prefix", "PG", "Prefix of the variables written to --env-file, like PG_HOST")
+	cmd.Flags().Bool("reuse", false, "Attach to a running dbctl container with the same version, port and label instead of starting a new one")
+	cmd.Flags().Bool("skip-migrations", false, "Do not apply migrations, fixtures are still applied, also to an existing data dir")
+	cmd.Flags().Bool("skip-fixtures", false, "Apply migrations without fixtures")
+	cmd.Flags().String("data-dir", "", "Host directory to persist database data across runs, migrations and fixtures are skipped if it already contains a database")
 
 	return cmd
 }
@@ -50,6 +72,14 @@ func runPostgres(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("invalid ui args, %w", err)
 	}
 
+	uiBackend, err := cmd.Flags().GetString("ui-backend")
+	if err != nil {
+		return fmt.Errorf("invalid ui-backend args, %w", err)
+	}
+	if !withUI {
+		uiBackend = string(pg.UINone)
+	}
+
 	name, err := cmd.Flags().GetString("name")
 	if err != nil {
 		return fmt.Errorf("invalid name args, %w", err)
@@ -70,7 +100,7 @@ func runPostgres(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("invalid version args, %w", err)
 	}
 
-	migrationsPath, err := cmd.Flags().GetString("migrations")
+	migrationsPaths, err := cmd.Flags().GetStringArray("migrations")
 	if err != nil {
 		return fmt.Errorf("invalid migrations args, %w", err)
 	}
@@ -80,18 +110,149 @@ func runPostgres(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("invalid fixtures args, %w", err)
 	}
 
-	db, err := pg.New(
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		return fmt.Errorf("invalid data-dir args, %w", err)
+	}
+
+	logFormat, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		return fmt.Errorf("invalid log-format args, %w", err)
+	}
+
+	idleTimeout, err := cmd.Flags().GetDuration("idle-timeout")
+	if err != nil {
+		return fmt.Errorf("invalid idle-timeout args, %w", err)
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("invalid dry-run args, %w", err)
+	}
+
+	keepOnFailure, err := cmd.Flags().GetBool("keep-on-failure")
+	if err != nil {
+		return fmt.Errorf("invalid keep-on-failure args, %w", err)
+	}
+
+	reuse, err := cmd.Flags().GetBool("reuse")
+	if err != nil {
+		return fmt.Errorf("invalid reuse args, %w", err)
+	}
+
+	restartPolicy, err := cmd.Flags().GetString("restart-policy")
+	if err != nil {
+		return fmt.Errorf("invalid restart-policy args, %w", err)
+	}
+
+	envFile, err := cmd.Flags().GetString("env-file")
+	if err != nil {
+		return fmt.Errorf("invalid env-file args, %w", err)
+	}
+
+	envPrefix, err := cmd.Flags().GetString("env-prefix")
+	if err != nil {
+		return fmt.Errorf("invalid env-prefix args, %w", err)
+	}
+
+	network, err := cmd.Flags().GetString("network")
+	if err != nil {
+		return fmt.Errorf("invalid network args, %w", err)
+	}
+
+	networkAliases, err := cmd.Flags().GetStringSlice("network-alias")
+	if err != nil {
+		return fmt.Errorf("invalid network-alias args, %w", err)
+	}
+
+	pullPolicy, err := cmd.Flags().GetString("pull-policy")
+	if err != nil {
+		return fmt.Errorf("invalid pull-policy args, %w", err)
+	}
+
+	fixtureProfile, err := cmd.Flags().GetString("fixture-profile")
+	if err != nil {
+		return fmt.Errorf("invalid fixture-profile args, %w", err)
+	}
+
+	resetSequences, err := cmd.Flags().GetBool("reset-sequences")
+	if err != nil {
+		return fmt.Errorf("invalid reset-sequences args, %w", err)
+	}
+
+	shutdownTimeout, err := cmd.Flags().GetDuration("shutdown-timeout")
+	if err != nil {
+		return fmt.Errorf("invalid shutdown-timeout args, %w", err)
+	}
+
+	pullTimeout, err := cmd.Flags().GetDuration("pull-timeout")
+	if err != nil {
+		return fmt.Errorf("invalid pull-timeout args, %w", err)
+	}
+
+	instance, err := cmd.Flags().GetString("instance")
+	if err != nil {
+		return fmt.Errorf("invalid instance args, %w", err)
+	}
+
+	skipMigrations, err := cmd.Flags().GetBool("skip-migrations")
+	if err != nil {
+		return fmt.Errorf("invalid skip-migrations args, %w", err)
+	}
+
+	skipFixtures, err := cmd.Flags().GetBool("skip-fixtures")
+	if err != nil {
+		return fmt.Errorf("invalid skip-fixtures args, %w", err)
+	}
+
+	var db *pg.Postgres
+	db, err = pg.New(
 		pg.WithHost(user, pass, name, port),
 		pg.WithVersion(pgVersion),
-		pg.WithLogger(io.Discard),
-		pg.WithMigrations(migrationsPath),
+		// json logs and the container logs of a failed start are written to the logger
+		pg.WithLogger(os.Stderr),
+		pg.WithLogFormat(pg.LogFormat(logFormat)),
+		pg.WithMigrations(migrationsPaths...),
 		pg.WithFixtures(fixturesPath),
-		pg.WithUI(withUI),
+		pg.WithFixtureProfile(fixtureProfile),
+		pg.WithResetSequences(resetSequences),
+		pg.WithUI(pg.UIBackend(uiBackend)),
 		pg.WithLabel(label),
+		pg.WithDataDir(dataDir),
+		pg.WithIdleTimeout(idleTimeout),
+		pg.WithDryRun(dryRun),
+		pg.WithSignalHandling(true),
+		pg.WithKeepOnFailure(keepOnFailure),
+		pg.WithReuse(reuse),
+		pg.WithInstanceName(instance),
+		pg.WithNetwork(network),
+		pg.WithNetworkAlias(networkAliases...),
+		pg.WithPullPolicy(pullPolicy),
+		pg.WithPullTimeout(pullTimeout),
+		pg.WithShutdownTimeout(shutdownTimeout),
+		pg.WithRestartPolicy(restartPolicy),
+		pg.WithSkipMigrations(skipMigrations),
+		pg.WithSkipFixtures(skipFixtures),
+		pg.WithReadyCallback(func(string) {
+			// json logs already have the uri, plain lines would break parsing them
+			if pg.LogFormat(logFormat) != pg.LogJSON {
+				logger.Info("Connect using psql:", db.PsqlCommand())
+				logger.Info("JDBC url:", db.JDBCURL())
+				if uri := db.NetworkURI(); uri != "" {
+					logger.Info("Network uri:", uri)
+				}
+			}
+			if envFile != "" {
+				// the file holds the password, keep it private
+				if err := os.WriteFile(envFile, []byte(db.EnvFile(envPrefix)), 0o600); err != nil {
+					logger.Error("Write env file failed:", err)
+				}
+			}
+		}),
 	)
 	if err != nil {
 		return err
 	}
 
-	return db.Start(utils.ContextWithOsSignal(), detach)
+	return db.Start(context.Background(), detach)
 }
@@ -16,5 +16,7 @@ func GetStartCmd() *cobra.Command {
 
 	cmd.AddCommand(GetPgCmd())
 	cmd.AddCommand(GetRedisCmd())
+	cmd.AddCommand(GetCockroachCmd())
+	cmd.AddCommand(GetMSSQLCmd())
 	return cmd
 }
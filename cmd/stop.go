@@ -6,6 +6,8 @@ import (
 
 	"github.com/mirzakhany/dbctl/internal/container"
 	"github.com/mirzakhany/dbctl/internal/database"
+	"github.com/mirzakhany/dbctl/internal/database/cockroach"
+	"github.com/mirzakhany/dbctl/internal/database/mssql"
 	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
 	"github.com/mirzakhany/dbctl/internal/database/redis"
 	"github.com/mirzakhany/dbctl/internal/utils"
@@ -15,9 +17,9 @@ import (
 // GetStopCmd represents the stop command
 func GetStopCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stop {rs pg id all <label>}",
+		Use:   "stop {rs pg crdb mssql id all <label> <instance>}",
 		Short: "stop one or more detached databases",
-		Long: `using this command you can stop one or more detached databases by their type, id or label
+		Long: `using this command you can stop one or more detached databases by their type, id, label or instance name
 		for example: dbctl stop pg rs or dbctl stop 969ec9747052`,
 		RunE: runStop,
 	}
@@ -26,7 +28,7 @@ func GetStopCmd() *cobra.Command {
 
 func runStop(_ *cobra.Command, args []string) error {
 	if len(args) == 0 {
-		return errors.New("invalid args, can be postgres(pg) and/or redis(rs) or instance id")
+		return errors.New("invalid args, can be postgres(pg), redis(rs), cockroach(crdb) and/or mssql or instance id")
 	}
 
 	ctx := utils.ContextWithOsSignal()
@@ -53,6 +55,28 @@ func runStop(_ *cobra.Command, args []string) error {
 		}
 	}
 
+	if utils.Contain(args, "crdb", "cockroach") {
+		items, err := cockroach.Instances(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := removeByInfo(ctx, items); err != nil {
+			return err
+		}
+	}
+
+	if utils.Contain(args, "mssql", "sqlserver") {
+		items, err := mssql.Instances(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := removeByInfo(ctx, items); err != nil {
+			return err
+		}
+	}
+
 	// it could be the case that user sent instance id instead of type
 	// so we try to remove it
 	// TODO check if database is in detached mode and warn user
@@ -82,6 +106,16 @@ func runStop(_ *cobra.Command, args []string) error {
 			return nil
 		}
 
+		// check if its an instance name then remove by name
+		effectd, err = removeByLabels(ctx, map[string]string{container.LabelName: args[0]})
+		if err != nil {
+			return err
+		}
+
+		if effectd > 0 {
+			return nil
+		}
+
 		// remove by id
 		if err := container.TerminateByID(ctx, args[0]); err != nil {
 			return err
@@ -101,8 +135,11 @@ func removeByInfo(ctx context.Context, dbs []database.Info) error {
 }
 
 func removeByLabel(ctx context.Context, label string) (int, error) {
-	// remove by label
-	items, err := container.List(ctx, map[string]string{container.LabelCustom: label})
+	return removeByLabels(ctx, map[string]string{container.LabelCustom: label})
+}
+
+func removeByLabels(ctx context.Context, labels map[string]string) (int, error) {
+	items, err := container.List(ctx, labels)
 	if err != nil {
 		return 0, err
 	}
@@ -121,5 +158,5 @@ func removeByLabel(ctx context.Context, label string) (int, error) {
 }
 
 func itsDBType(a string) bool {
-	return utils.OneOf(a, "pg", "postgres", "rs", "redis")
+	return utils.OneOf(a, "pg", "postgres", "rs", "redis", "crdb", "cockroach", "mssql", "sqlserver")
 }
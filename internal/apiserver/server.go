@@ -84,6 +84,15 @@ type CreateDBRequest struct {
 // CreateDBResponse is the response body for creating a database
 type CreateDBResponse struct {
 	URI string `json:"uri"`
+
+	Host     string `json:"host"`
+	Port     uint32 `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+
+	AppliedMigrations []string `json:"applied_migrations,omitempty"`
+	AppliedFixtures   []string `json:"applied_fixtures,omitempty"`
 }
 
 // CreateDB creates a new database
@@ -139,14 +148,14 @@ func (s *Server) CreateDB(w http.ResponseWriter, r *http.Request) {
 	}
 	req.Fixtures = fixturesDir
 
-	var uri string
+	var res *database.CreateDBResponse
 	var createErr error
 
 	switch req.Type {
 	case "postgres":
-		uri, createErr = createPostgresDB(r.Context(), req)
+		res, createErr = createPostgresDB(r.Context(), req)
 	case "redis":
-		uri, createErr = createRedisDB(r.Context(), req)
+		res, createErr = createRedisDB(r.Context(), req)
 	}
 
 	if createErr != nil {
@@ -154,7 +163,17 @@ func (s *Server) CreateDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	JSON(w, http.StatusOK, CreateDBResponse{URI: uri})
+	JSON(w, http.StatusOK, CreateDBResponse{
+		URI:      res.URI,
+		Host:     res.Host,
+		Port:     res.Port,
+		User:     res.User,
+		Password: res.Password,
+		Database: res.Database,
+
+		AppliedMigrations: res.AppliedMigrations,
+		AppliedFixtures:   res.AppliedFixtures,
+	})
 }
 
 func readMulipartFiles(r *http.Request, key, dst string) error {
@@ -217,7 +236,7 @@ func (s *Server) RemoveDB(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusNoContent, nil)
 }
 
-func createPostgresDB(ctx context.Context, r *CreateDBRequest) (string, error) {
+func createPostgresDB(ctx context.Context, r *CreateDBRequest) (*database.CreateDBResponse, error) {
 	if r.InstancePort == 0 {
 		r.InstancePort = pg.DefaultPort
 	}
@@ -241,13 +260,13 @@ func createPostgresDB(ctx context.Context, r *CreateDBRequest) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return res.URI, nil
+	return res, nil
 }
 
-func createRedisDB(ctx context.Context, r *CreateDBRequest) (string, error) {
+func createRedisDB(ctx context.Context, r *CreateDBRequest) (*database.CreateDBResponse, error) {
 	if r.InstancePort == 0 {
 		r.InstancePort = rs.DefaultPort
 	}
@@ -265,10 +284,10 @@ func createRedisDB(ctx context.Context, r *CreateDBRequest) (string, error) {
 	res, err := rsDB.CreateDB(ctx, &database.CreateDBRequest{})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return res.URI, nil
+	return res, nil
 }
 
 func removePostgresDB(ctx context.Context, r *RemoveDBRequest) error {
@@ -1,8 +1,10 @@
 package container
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +14,7 @@ import (
 	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,20 +29,36 @@ const (
 	LabelDBctl = "dbctl"
 )
 
+// DBctlVersion is the version of dbctl recorded on created containers, it's set by the dbctl binary
+var DBctlVersion = "snapshot"
+
+// ErrDaemonUnreachable is returned when the docker daemon can not be reached
+var ErrDaemonUnreachable = errors.New("docker daemon not reachable, is docker running?")
+
+// ErrImageNotPresent is returned by Run when the image is not present locally and the pull policy is PullNever
+var ErrImageNotPresent = errors.New("image is not present")
+
+// Ping checks if the docker daemon is reachable
+func Ping(ctx context.Context) error {
+	res, err := callDockerAPI(ctx, http.MethodGet, "/_ping", nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDaemonUnreachable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: ping returned status %d", ErrDaemonUnreachable, res.StatusCode)
+	}
+	return nil
+}
+
 // Run creates and starts a container
 func Run(ctx context.Context, req CreateRequest) (*Container, error) {
-	if err := PullImage(ctx, req.Image); err != nil {
+	if err := EnsureImage(ctx, req.Image, req.PullPolicy); err != nil {
 		return nil, err
 	}
 
-	id, err := CreateContainer(ctx, CreateRequest{
-		Name:         req.Name,
-		Image:        req.Image,
-		Cmd:          req.Cmd,
-		Env:          req.Env,
-		ExposedPorts: req.ExposedPorts,
-		Labels:       req.Labels,
-	})
+	id, err := CreateContainer(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +81,33 @@ func TerminateByID(ctx context.Context, id string) error {
 	return RemoveContainer(ctx, id)
 }
 
+// StopContainer stops a container gracefully, docker sends the stop signal of the image and kills the
+// container if it is still running after timeout. The stop signal of postgres images is SIGINT, a fast
+// shutdown which writes a checkpoint. A container which is already stopped or removed is not an error.
+func StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/%s/containers/%s/stop?t=%d", apiVersion, id, stopSeconds(timeout))
+	res, err := callDockerAPI(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusNotModified || res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return mapError(res)
+}
+
+// stopSeconds returns the whole seconds of a stop timeout docker waits for, rounded up
+func stopSeconds(timeout time.Duration) int {
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
 // StartContainer starts a container by id
 func StartContainer(ctx context.Context, id string) error {
 	apiVersion, err := getAPIVersion(ctx)
@@ -85,7 +131,7 @@ func CreateContainer(ctx context.Context, params CreateRequest) (string, error)
 		return "", err
 	}
 
-	labels := map[string]string{LabelManagedBy: LabelDBctl}
+	labels := map[string]string{LabelManagedBy: LabelDBctl, LabelVersion: DBctlVersion}
 	for k, v := range params.Labels {
 		labels[k] = v
 	}
@@ -100,13 +146,40 @@ func CreateContainer(ctx context.Context, params CreateRequest) (string, error)
 		return "", err
 	}
 
+	restartPolicy, err := ParseRestartPolicy(params.RestartPolicy)
+	if err != nil {
+		return "", err
+	}
+
+	if params.Memory > 0 || params.NanoCPUs > 0 {
+		info, err := GetInfo(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err := checkResourceLimits(info, params); err != nil {
+			return "", err
+		}
+	}
+
 	req := DockerCreateConfig{
 		Image:        params.Image,
 		Cmd:          params.Cmd,
+		Entrypoint:   params.Entrypoint,
 		Labels:       labels,
 		Env:          envs,
+		User:         params.User,
 		ExposedPorts: exposedPortSet,
-		HostConfig:   HostConfig{PortBindings: exposedPortMap},
+		HostConfig: HostConfig{
+			PortBindings:  exposedPortMap,
+			Binds:         params.Binds,
+			ExtraHosts:    params.ExtraHosts,
+			Memory:        params.Memory,
+			NanoCpus:      params.NanoCPUs,
+			RestartPolicy: restartPolicy,
+			NetworkMode:   params.Network,
+			AutoRemove:    params.AutoRemove,
+		},
+		NetworkingConfig: networkingConfig(params.Network, params.NetworkAliases),
 	}
 
 	for _, pm := range exposedPortMap {
@@ -146,6 +219,138 @@ func CreateContainer(ctx context.Context, params CreateRequest) (string, error)
 	return re.ID, nil
 }
 
+// networkingConfig returns the endpoint settings joining network with aliases, nil without a network
+func networkingConfig(network string, aliases []string) *NetworkingConfig {
+	if network == "" {
+		return nil
+	}
+	return &NetworkingConfig{EndpointsConfig: map[string]EndpointSettings{network: {Aliases: aliases}}}
+}
+
+// GetInfo returns the features of the docker daemon
+func GetInfo(ctx context.Context) (*DockerInfo, error) {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/%s/info", apiVersion)
+	res, err := callDockerAPI(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, mapError(res)
+	}
+
+	var info DockerInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode docker info failed: %w", err)
+	}
+	return &info, nil
+}
+
+// checkResourceLimits reports the limits of the request the docker daemon can't enforce, docker would
+// otherwise create the container and silently ignore them
+func checkResourceLimits(info *DockerInfo, params CreateRequest) error {
+	if params.Memory > 0 && !info.MemoryLimit {
+		return errors.New("memory limit is not supported by the docker daemon, is the memory cgroup enabled?")
+	}
+	if params.NanoCPUs > 0 && !info.CPUCfsQuota {
+		return errors.New("cpu limit is not supported by the docker daemon, is the cpu cgroup enabled?")
+	}
+	return nil
+}
+
+// ParseRestartPolicy parses a docker restart policy like unless-stopped or on-failure:3,
+// an empty policy returns nil to keep the docker default
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+	if policy == "" {
+		return nil, nil
+	}
+
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	switch name {
+	case "no", "always", "unless-stopped":
+		if hasRetries {
+			return nil, fmt.Errorf("restart policy %q does not take a retry count", name)
+		}
+		return &RestartPolicy{Name: name}, nil
+	case "on-failure":
+		rp := &RestartPolicy{Name: name}
+		if hasRetries {
+			n, err := strconv.Atoi(retries)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid retry count %q of restart policy %q", retries, policy)
+			}
+			rp.MaximumRetryCount = n
+		}
+		return rp, nil
+	default:
+		return nil, fmt.Errorf("unsupported restart policy %q, use no, always, unless-stopped or on-failure[:max-retries]", policy)
+	}
+}
+
+// ParsePullPolicy parses a pull policy, Always, IfNotPresent or Never in any case. An empty policy is PullAlways
+func ParsePullPolicy(policy string) (PullPolicy, error) {
+	for _, p := range []PullPolicy{PullAlways, PullIfNotPresent, PullNever} {
+		if strings.EqualFold(policy, string(p)) {
+			return p, nil
+		}
+	}
+	if policy == "" {
+		return PullAlways, nil
+	}
+	return "", fmt.Errorf("unsupported pull policy %q, use Always, IfNotPresent or Never", policy)
+}
+
+// EnsureImage makes sure image is present locally according to policy, pulling it if needed
+func EnsureImage(ctx context.Context, image string, policy PullPolicy) error {
+	return ensureImage(ctx, image, policy, ImageExists, PullImage)
+}
+
+// ensureImage makes sure image is present according to policy, using exists and pull to check and pull it
+func ensureImage(ctx context.Context, image string, policy PullPolicy, exists func(ctx context.Context, image string) (bool, error),
+	pull func(ctx context.Context, image string) error) error {
+	if policy == PullAlways || policy == "" {
+		return pull(ctx, image)
+	}
+
+	present, err := exists(ctx, image)
+	if err != nil {
+		return fmt.Errorf("inspect image %q failed: %w", image, err)
+	}
+	switch {
+	case present:
+		return nil
+	case policy == PullNever:
+		return fmt.Errorf("%w: %q with pull policy Never, pull it first with docker pull %s", ErrImageNotPresent, image, image)
+	default:
+		return pull(ctx, image)
+	}
+}
+
+// ImageExists reports if a docker image is present locally
+func ImageExists(ctx context.Context, image string) (bool, error) {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := callDockerAPI(ctx, http.MethodGet, fmt.Sprintf("/%s/images/%s/json", apiVersion, image), nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return res.StatusCode == http.StatusOK, mapError(res)
+}
+
 // PullImage pulls a docker image
 func PullImage(ctx context.Context, image string) error {
 	apiVersion, err := getAPIVersion(ctx)
@@ -172,8 +377,17 @@ func PullImage(ctx context.Context, image string) error {
 	return mapError(res)
 }
 
-// List lists all containers with the given labels managed by dbctl
+// List lists the running containers with the given labels managed by dbctl
 func List(ctx context.Context, labels map[string]string) ([]*Container, error) {
+	return list(ctx, labels, false)
+}
+
+// ListAll lists all containers with the given labels managed by dbctl, including stopped ones
+func ListAll(ctx context.Context, labels map[string]string) ([]*Container, error) {
+	return list(ctx, labels, true)
+}
+
+func list(ctx context.Context, labels map[string]string, all bool) ([]*Container, error) {
 	apiVersion, err := getAPIVersion(ctx)
 	if err != nil {
 		return nil, err
@@ -189,7 +403,7 @@ func List(ctx context.Context, labels map[string]string) ([]*Container, error) {
 		return nil, err
 	}
 
-	path := fmt.Sprintf("/%s/containers/json?limit=0&filters=%s", apiVersion, f)
+	path := fmt.Sprintf("/%s/containers/json?limit=0&all=%t&filters=%s", apiVersion, all, f)
 	res, err := callDockerAPI(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
@@ -216,6 +430,8 @@ func List(ctx context.Context, labels map[string]string) ([]*Container, error) {
 			ID:     c.ID,
 			Name:   c.Names[0],
 			Labels: c.Labels,
+			State:  c.State,
+			Ports:  c.Ports,
 		})
 	}
 
@@ -240,15 +456,16 @@ func encodeFilters(args map[string]string) (string, error) {
 	return string(d), nil
 }
 
-// RemoveContainer removes a container by id
+// RemoveContainer kills and removes a container by id along with its anonymous volumes, a removed
+// container is not brought back by its restart policy
 func RemoveContainer(ctx context.Context, id string) error {
 	apiVersion, err := getAPIVersion(ctx)
 	if err != nil {
 		return err
 	}
 
-	path := fmt.Sprintf("/%s/containers/%s/kill?v=true&force=true&link=false", apiVersion, id)
-	res, err := callDockerAPI(ctx, http.MethodPost, path, nil)
+	path := fmt.Sprintf("/%s/containers/%s?v=true&force=true", apiVersion, id)
+	res, err := callDockerAPI(ctx, http.MethodDelete, path, nil)
 	if err != nil {
 		return err
 	}
@@ -260,11 +477,129 @@ func RemoveContainer(ctx context.Context, id string) error {
 	return mapError(res)
 }
 
+// HostPort returns the host port a container port (e.g. 5432/tcp) is published on,
+// it resolves the port docker picked when the port was published without a host port
+func HostPort(ctx context.Context, id, port string) (string, error) {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	path := fmt.Sprintf("/%s/containers/%s/json", apiVersion, id)
+	res, err := callDockerAPI(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", mapError(res)
+	}
+
+	var out InspectContainerResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode container inspect failed: %w", err)
+	}
+
+	for _, binding := range out.NetworkSettings.Ports[nat.Port(port)] {
+		if binding.HostPort != "" {
+			return binding.HostPort, nil
+		}
+	}
+	return "", fmt.Errorf("port %s of container %s is not published", port, id)
+}
+
+// Logs returns stdout and stderr logs of a container
+func Logs(ctx context.Context, id string) ([]byte, error) {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/%s/containers/%s/logs?stdout=true&stderr=true", apiVersion, id)
+	res, err := callDockerAPI(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, mapError(res)
+	}
+
+	var out bytes.Buffer
+	if err := demuxStream(res.Body, &out, &out); err != nil {
+		return nil, fmt.Errorf("read container logs failed: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// FollowLogs streams the stdout and stderr logs a container writes from now on, until ctx is cancelled
+// or the container stops. Cancelling ctx is the normal end of streaming and returns nil.
+func FollowLogs(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/%s/containers/%s/logs?stdout=true&stderr=true&follow=true&tail=0", apiVersion, id)
+	res, err := callDockerAPI(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return mapError(res)
+	}
+
+	if err := demuxStream(res.Body, stdout, stderr); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("follow container logs failed: %w", err)
+	}
+	return nil
+}
+
+// demuxStream splits a docker multiplexed stream into stdout and stderr,
+// each frame starts with a 8 bytes header: stream type, 3 zero bytes and the big endian frame size
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
 type DockerExecResponse struct {
 	ID string `json:"Id"`
 }
 
 func CreateExec(ctx context.Context, containerID string, cmd []string) (string, error) {
+	return createExec(ctx, containerID, cmd, false)
+}
+
+func createExec(ctx context.Context, containerID string, cmd []string, attachStdin bool) (string, error) {
 	apiVersion, err := getAPIVersion(ctx)
 	if err != nil {
 		return "", err
@@ -276,7 +611,7 @@ func CreateExec(ctx context.Context, containerID string, cmd []string) (string,
 		"Detach":       false,
 		"Tty":          false,
 		"AttachStdout": true,
-		"AttachStdin":  false,
+		"AttachStdin":  attachStdin,
 		"AttachStderr": true,
 	}
 
@@ -351,6 +686,131 @@ func RunExec(ctx context.Context, containerID string, cmd []string) (string, err
 	return StartExec(ctx, execID)
 }
 
+// Exec runs a command inside a container and returns its stdout, stderr and exit code
+func Exec(ctx context.Context, id string, cmd []string) (stdout, stderr []byte, exitCode int, err error) {
+	var outBuf, errBuf bytes.Buffer
+	exitCode, err = ExecStream(ctx, id, cmd, nil, &outBuf, &errBuf)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), exitCode, nil
+}
+
+// ExecStream runs a command inside a container, stdin (if not nil) is piped to the command
+// and its output is streamed to stdout and stderr. It returns the exit code of the command.
+func ExecStream(ctx context.Context, containerID string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	execID, err := createExec(ctx, containerID, cmd, stdin != nil)
+	if err != nil {
+		return 0, err
+	}
+
+	apiVersion, err := getAPIVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// stdin can only be attached on a hijacked connection, so the request is written by hand
+	conn, err := dialDocker(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// unblock reads and writes on the connection when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	path := fmt.Sprintf("http://localhost/%s/exec/%s/start", apiVersion, execID)
+	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols && res.StatusCode != http.StatusOK {
+		return 0, mapError(res)
+	}
+
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(conn, stdin)
+			// signal end of input to the command
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			}
+		}()
+	}
+
+	if err := demuxStream(br, stdout, stderr); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("read exec output failed: %w", err)
+	}
+
+	return execExitCode(ctx, apiVersion, execID)
+}
+
+// execPollInterval is how often execExitCode checks whether an exec has finished
+const execPollInterval = 50 * time.Millisecond
+
+// execExitCode returns the exit code of an exec, waiting for it to finish as its output stream
+// may be drained before docker marks the exec as exited
+func execExitCode(ctx context.Context, apiVersion, execID string) (int, error) {
+	for {
+		running, code, err := inspectExec(ctx, apiVersion, execID)
+		if err != nil || !running {
+			return code, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(execPollInterval):
+		}
+	}
+}
+
+func inspectExec(ctx context.Context, apiVersion, execID string) (bool, int, error) {
+	res, err := callDockerAPI(ctx, http.MethodGet, fmt.Sprintf("/%s/exec/%s/json", apiVersion, execID), nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer res.Body.Close()
+
+	if err := mapError(res); err != nil {
+		return false, 0, err
+	}
+
+	var out struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return false, 0, fmt.Errorf("read docker response failed: %w", err)
+	}
+	return out.Running, out.ExitCode, nil
+}
+
 type errMessage struct {
 	Message string `json:"message"`
 }
@@ -407,8 +867,9 @@ func callDockerAPI(ctx context.Context, method, path string, body io.Reader) (*h
 
 	client := http.Client{
 		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial(addr.protocol, addr.addr)
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, addr.protocol, addr.addr)
 			},
 		},
 	}
@@ -425,6 +886,16 @@ func callDockerAPI(ctx context.Context, method, path string, body io.Reader) (*h
 	return client.Do(req.WithContext(ctx))
 }
 
+func dialDocker(ctx context.Context) (net.Conn, error) {
+	addr, err := getDockerAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, addr.protocol, addr.addr)
+}
+
 type dockerAddr struct {
 	addr     string
 	protocol string
@@ -1,8 +1,14 @@
 package container
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -67,3 +73,192 @@ func TestRestContainer(t *testing.T) {
 		t.Fatalf("RemoveContainer failed %s", err)
 	}
 }
+
+func TestDemuxStream(t *testing.T) {
+	frame := func(stream byte, payload string) []byte {
+		header := make([]byte, 8)
+		header[0] = stream
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		return append(header, payload...)
+	}
+
+	var stream bytes.Buffer
+	stream.Write(frame(1, "starting\n"))
+	stream.Write(frame(2, "FATAL: invalid value\n"))
+	stream.Write(frame(1, "done\n"))
+
+	var stdout, stderr bytes.Buffer
+	if err := demuxStream(&stream, &stdout, &stderr); err != nil {
+		t.Fatalf("demuxStream failed %s", err)
+	}
+
+	if stdout.String() != "starting\ndone\n" {
+		t.Fatalf("unexpected stdout %q", stdout.String())
+	}
+
+	if stderr.String() != "FATAL: invalid value\n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestCheckResourceLimits(t *testing.T) {
+	supported := &DockerInfo{MemoryLimit: true, CPUCfsQuota: true}
+	limits := CreateRequest{Memory: 512 << 20, NanoCPUs: 1e9}
+	if err := checkResourceLimits(supported, limits); err != nil {
+		t.Fatalf("expected limits to be supported, got %s", err)
+	}
+
+	if err := checkResourceLimits(&DockerInfo{CPUCfsQuota: true}, limits); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Fatalf("expected memory limit error, got %v", err)
+	}
+	if err := checkResourceLimits(&DockerInfo{MemoryLimit: true}, limits); err == nil || !strings.Contains(err.Error(), "cpu") {
+		t.Fatalf("expected cpu limit error, got %v", err)
+	}
+
+	// a request without limits works on any daemon
+	if err := checkResourceLimits(&DockerInfo{}, CreateRequest{}); err != nil {
+		t.Fatalf("expected no error without limits, got %s", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	if err := Ping(ctx); err != nil {
+		t.Skipf("docker is not available: %s", err)
+	}
+
+	c, err := Run(ctx, CreateRequest{
+		Name:  fmt.Sprintf("dbctl-exec-test-%d", time.Now().UnixNano()),
+		Image: "alpine:latest",
+		Cmd:   []string{"sh", "-c", "tail -f /dev/null"},
+	})
+	if err != nil {
+		t.Fatalf("Run failed %s", err)
+	}
+	defer func() { _ = c.Terminate(ctx) }()
+
+	stdout, stderr, code, err := Exec(ctx, c.ID, []string{"sh", "-c", "echo hello; echo oops >&2; exit 3"})
+	if err != nil {
+		t.Fatalf("Exec failed %s", err)
+	}
+
+	if string(stdout) != "hello\n" {
+		t.Fatalf("unexpected stdout %q", stdout)
+	}
+
+	if string(stderr) != "oops\n" {
+		t.Fatalf("unexpected stderr %q", stderr)
+	}
+
+	if code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	cases := map[string]*RestartPolicy{
+		"":               nil,
+		"no":             {Name: "no"},
+		"unless-stopped": {Name: "unless-stopped"},
+		"on-failure":     {Name: "on-failure"},
+		"on-failure:3":   {Name: "on-failure", MaximumRetryCount: 3},
+	}
+	for policy, expected := range cases {
+		got, err := ParseRestartPolicy(policy)
+		if err != nil {
+			t.Fatalf("%q: ParseRestartPolicy failed %s", policy, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q: expected %+v, got %+v", policy, expected, got)
+		}
+	}
+
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Fatalf("expected error for %q", policy)
+		}
+	}
+}
+
+func TestEnsureImage(t *testing.T) {
+	for _, tt := range []struct {
+		policy  PullPolicy
+		present bool
+		pull    bool
+		err     error
+	}{
+		{PullAlways, true, true, nil},
+		{"", true, true, nil},
+		{PullIfNotPresent, true, false, nil},
+		{PullIfNotPresent, false, true, nil},
+		{PullNever, true, false, nil},
+		{PullNever, false, false, ErrImageNotPresent},
+	} {
+		var pulled bool
+		exists := func(context.Context, string) (bool, error) { return tt.present, nil }
+		pull := func(context.Context, string) error {
+			pulled = true
+			return nil
+		}
+
+		err := ensureImage(context.Background(), "postgres:16", tt.policy, exists, pull)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%q present=%t: expected error %v, got %v", tt.policy, tt.present, tt.err, err)
+		}
+		if pulled != tt.pull {
+			t.Fatalf("%q present=%t: expected pull %t, got %t", tt.policy, tt.present, tt.pull, pulled)
+		}
+	}
+}
+
+func TestParsePullPolicy(t *testing.T) {
+	for policy, expected := range map[string]PullPolicy{
+		"":             PullAlways,
+		"always":       PullAlways,
+		"IfNotPresent": PullIfNotPresent,
+		"NEVER":        PullNever,
+	} {
+		got, err := ParsePullPolicy(policy)
+		if err != nil || got != expected {
+			t.Fatalf("%q: expected %q, got %q (%v)", policy, expected, got, err)
+		}
+	}
+
+	if _, err := ParsePullPolicy("if-missing"); err == nil {
+		t.Fatal("expected error for an unsupported pull policy")
+	}
+}
+
+func TestNetworkingConfig(t *testing.T) {
+	if cfg := networkingConfig("", []string{"db"}); cfg != nil {
+		t.Fatalf("expected no networking config without a network, got %+v", cfg)
+	}
+
+	req := DockerCreateConfig{
+		HostConfig:       HostConfig{NetworkMode: "app"},
+		NetworkingConfig: networkingConfig("app", []string{"db", "postgres"}),
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal failed %s", err)
+	}
+
+	for _, want := range []string{`"NetworkMode":"app"`, `"NetworkingConfig":{"EndpointsConfig":{"app":{"Aliases":["db","postgres"]}}}`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %s in create config %s", want, data)
+		}
+	}
+}
+
+func TestStopSeconds(t *testing.T) {
+	for timeout, expected := range map[time.Duration]int{
+		0:                       0,
+		500 * time.Millisecond:  1,
+		10 * time.Second:        10,
+		1500 * time.Millisecond: 2,
+	} {
+		if got := stopSeconds(timeout); got != expected {
+			t.Fatalf("%s: expected %d seconds, got %d", timeout, expected, got)
+		}
+	}
+}
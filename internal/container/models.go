@@ -7,12 +7,33 @@ const (
 	LabelType = "dbctl_type"
 	// LabelCustom is the label used to identify a database
 	LabelCustom = "dbctl_custom"
+	// LabelVersion is the label holding the version of dbctl which created the container
+	LabelVersion = "dbctl_version"
+	// LabelDBVersion is the label holding the version of the database
+	LabelDBVersion = "dbctl_db_version"
+	// LabelPort is the label holding the host port of the database
+	LabelPort = "dbctl_port"
+	// LabelName is the label holding the instance name of the database
+	LabelName = "dbctl_name"
+	// LabelConfig is the label holding a hash of the settings of the database, like its credentials
+	LabelConfig = "dbctl_config"
 )
 
 type Container struct {
 	ID     string
 	Name   string
 	Labels map[string]string
+	// State is the docker container state, one of created, running, paused, restarting, removing, exited or dead
+	State string
+	// Ports are the ports of the container, with the host port they are published on if any
+	Ports []Port
+}
+
+// Port is a port of a container, PublicPort is zero if it's not published on the host
+type Port struct {
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort"`
+	Type        string `json:"Type"`
 }
 
 type CreateRequest struct {
@@ -20,17 +41,49 @@ type CreateRequest struct {
 	Image        string
 	ExposedPorts []string // allow specifying protocol info
 	Cmd          []string
+	Entrypoint   []string // overrides the entrypoint of the image, the image default if empty
 	Env          map[string]string
 	Labels       map[string]string
+	Binds        []string // volume bindings in the form of host-path:container-path
+	ExtraHosts   []string // extra /etc/hosts entries in the form of host:ip, ip can be host-gateway
+	User         string   // user the container command runs as, the image default if empty
+	Memory       int64    // memory limit in bytes, unlimited if zero
+	NanoCPUs     int64    // cpu limit in units of 1e-9 cpus, unlimited if zero
+	// RestartPolicy is the docker restart policy: no, always, unless-stopped or on-failure[:max-retries]
+	RestartPolicy string
+	// PullPolicy tells when Run pulls the image, PullAlways if empty
+	PullPolicy PullPolicy
+	// AutoRemove makes docker remove the container once it exits, it can not be combined with a restart policy
+	AutoRemove bool
+	// Network is the user defined docker network the container joins instead of the default bridge,
+	// other containers of the network reach it by its name and NetworkAliases
+	Network        string
+	NetworkAliases []string
 }
 
+// PullPolicy tells when Run pulls the image of a container
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image on every run, picking up a newer image of the same tag
+	PullAlways PullPolicy = "Always"
+	// PullIfNotPresent pulls the image only if it is not present locally
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	// PullNever never pulls the image, Run fails with ErrImageNotPresent if it is not present locally
+	PullNever PullPolicy = "Never"
+)
+
 type DockerCreateConfig struct {
 	Image        string            `json:"Image"`
 	Cmd          []string          `json:"Cmd"`
+	Entrypoint   []string          `json:"Entrypoint,omitempty"`
 	Labels       map[string]string `json:"Labels"`
 	Env          []string          `json:"Env"`
+	User         string            `json:"User,omitempty"`
 	ExposedPorts nat.PortSet       `json:"ExposedPorts"`
 	HostConfig   HostConfig        `json:"HostConfig"`
+	// NetworkingConfig is nil to join the default bridge network
+	NetworkingConfig *NetworkingConfig `json:"NetworkingConfig,omitempty"`
 }
 
 type DockerCreateResponse struct {
@@ -39,10 +92,49 @@ type DockerCreateResponse struct {
 
 type HostConfig struct {
 	PortBindings nat.PortMap
+	Binds        []string `json:"Binds,omitempty"`
+	ExtraHosts   []string `json:"ExtraHosts,omitempty"`
+	Memory       int64    `json:"Memory,omitempty"`
+	NanoCpus     int64    `json:"NanoCpus,omitempty"`
+	// RestartPolicy is nil to keep the docker default, no restart
+	RestartPolicy *RestartPolicy `json:"RestartPolicy,omitempty"`
+	NetworkMode   string         `json:"NetworkMode,omitempty"`
+	AutoRemove    bool           `json:"AutoRemove,omitempty"`
+}
+
+// NetworkingConfig holds the endpoint settings of the networks a container joins on create
+type NetworkingConfig struct {
+	EndpointsConfig map[string]EndpointSettings `json:"EndpointsConfig"`
+}
+
+// EndpointSettings holds the settings of a container in a network
+type EndpointSettings struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+// RestartPolicy is the docker restart policy of a container
+type RestartPolicy struct {
+	Name              string `json:"Name"`
+	MaximumRetryCount int    `json:"MaximumRetryCount,omitempty"`
+}
+
+// DockerInfo holds the features of the docker daemon dbctl depends on
+type DockerInfo struct {
+	MemoryLimit bool `json:"MemoryLimit"`
+	CPUCfsQuota bool `json:"CpuCfsQuota"`
+}
+
+type InspectContainerResponse struct {
+	ID              string `json:"Id"`
+	NetworkSettings struct {
+		Ports nat.PortMap
+	}
 }
 
 type ListContainerResponse struct {
 	ID     string `json:"Id"`
 	Names  []string
 	Labels map[string]string
+	State  string
+	Ports  []Port
 }
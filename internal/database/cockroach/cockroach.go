@@ -0,0 +1,311 @@
+package cockroach
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+	"github.com/mirzakhany/dbctl/internal/logger"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+var (
+	_ database.Database = (*Cockroach)(nil)
+	_ database.Admin    = (*Cockroach)(nil)
+)
+
+const (
+	// DefaultPort is the default port for cockroach
+	DefaultPort = 26257
+	// DefaultUser is the default user for cockroach, insecure clusters only allow root
+	DefaultUser = "root"
+	// DefaultName is the default database name for cockroach
+	DefaultName = "defaultdb"
+)
+
+// Cockroach is a single node cockroach database instance, it speaks the postgres
+// wire protocol so postgres driver, migrations and fixtures are reused
+type Cockroach struct {
+	containerID string
+	cfg         config
+}
+
+// New creates a new cockroach database instance controller
+func New(options ...Option) (*Cockroach, error) {
+	// create cockroach with default values
+	c := &Cockroach{cfg: config{
+		user:    DefaultUser,
+		name:    DefaultName,
+		port:    DefaultPort,
+		version: defaultVersion,
+	}}
+
+	for _, o := range options {
+		if err := o(&c.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// CreateDB creates a new database with given migrations and fixtures. Cockroach does not
+// support creating databases from templates, so migrations are applied on every new database.
+func (c *Cockroach) CreateDB(ctx context.Context, req *database.CreateDBRequest) (*database.CreateDBResponse, error) {
+	conn, err := sql.Open("postgres", c.URI())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	name, err := randomDBName()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "create database "+pq.QuoteIdentifier(name)); err != nil {
+		return nil, fmt.Errorf("create database failed: %w", err)
+	}
+
+	newDB := c.withName(name)
+	if err := applyFiles(ctx, newDB.URI(), req.Migrations, req.Fixtures); err != nil {
+		// a half migrated database is of no use, drop it
+		_ = c.RemoveDB(context.Background(), newDB.URI())
+		return nil, err
+	}
+
+	uri, host := newDB.URI(), newDB.host()
+	// make sure we retrun localhost instead of host.docker.internal
+	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
+		uri = strings.ReplaceAll(uri, "host.docker.internal", "localhost")
+		host = "localhost"
+	}
+
+	return &database.CreateDBResponse{
+		URI:      uri,
+		Host:     host,
+		Port:     c.cfg.port,
+		User:     c.cfg.user,
+		Database: name,
+	}, nil
+}
+
+// applyFiles applies migrations and fixtures from the given paths, which can be empty
+func applyFiles(ctx context.Context, uri, migrations, fixtures string) error {
+	migrationFiles, err := pg.GetFiles(migrations)
+	if err != nil {
+		return fmt.Errorf("read migraions failed: %w", err)
+	}
+
+	fixtureFiles, err := pg.GetFiles(fixtures)
+	if err != nil {
+		return fmt.Errorf("read fixtures failed: %w", err)
+	}
+
+	if err := pg.RunMigrations(ctx, nil, migrationFiles, uri); err != nil {
+		return err
+	}
+	return pg.ApplyFixtures(ctx, nil, fixtureFiles, uri)
+}
+
+// RemoveDB removes a database by its uri
+func (c *Cockroach) RemoveDB(ctx context.Context, uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	conn, err := sql.Open("postgres", c.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	name := strings.TrimPrefix(u.Path, "/")
+	if _, err := conn.ExecContext(ctx, "drop database if exists "+pq.QuoteIdentifier(name)+" cascade"); err != nil {
+		return fmt.Errorf("remove database failed: %w", err)
+	}
+	return nil
+}
+
+// Start starts a single node cockroach cluster
+func (c *Cockroach) Start(ctx context.Context, detach bool) error {
+	logger.InfoTo(c.cfg.logger, fmt.Sprintf("Starting cockroach version %s on port %d ...", c.cfg.version, c.cfg.port))
+
+	// fail early with a clear error instead of a low level one from container creation
+	if err := container.Ping(ctx); err != nil {
+		return err
+	}
+
+	closeFunc, err := c.startUsingDocker(ctx, 30*time.Second)
+	if err != nil {
+		return err
+	}
+
+	logger.InfoTo(c.cfg.logger, "Cockroach is up and running")
+	if len(c.cfg.migrationsFiles) > 0 {
+		logger.InfoTo(c.cfg.logger, "Applying migrations ...")
+		if err := pg.RunMigrations(ctx, nil, c.cfg.migrationsFiles, c.URI()); err != nil {
+			_ = closeFunc(context.Background())
+			return err
+		}
+	}
+
+	if len(c.cfg.fixtureFiles) > 0 {
+		logger.InfoTo(c.cfg.logger, "Applying fixtures ...")
+		if err := pg.ApplyFixtures(ctx, nil, c.cfg.fixtureFiles, c.URI()); err != nil {
+			_ = closeFunc(context.Background())
+			return err
+		}
+	}
+
+	// print connection url
+	logger.InfoTo(c.cfg.logger, fmt.Sprintf("Database uri is: %q", c.URI()))
+
+	// detach and stop cli if asked
+	if detach {
+		return nil
+	}
+
+	<-ctx.Done()
+	logger.InfoTo(c.cfg.logger, "Shutdown signal received, stopping database")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() {
+		cancel()
+	}()
+
+	return closeFunc(shutdownCtx)
+}
+
+// Stop stops the database
+func (c *Cockroach) Stop(ctx context.Context) error {
+	return container.TerminateByID(ctx, c.containerID)
+}
+
+// WaitForStart waits for cockroach to start and accept queries
+func (c *Cockroach) WaitForStart(ctx context.Context, timeout time.Duration) error {
+	logger.InfoTo(c.cfg.logger, "Wait for database to boot up")
+	return utils.WaitFor(ctx, timeout, 100*time.Millisecond, 2*time.Second, func(ctx context.Context) error {
+		conn, err := sql.Open("postgres", c.URI())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		_, err = conn.ExecContext(ctx, "select 1")
+		return err
+	})
+}
+
+// Instances returns a list of running cockroach instances
+func Instances(ctx context.Context) ([]database.Info, error) {
+	l, err := container.List(ctx, map[string]string{container.LabelType: database.LabelCockroach})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]database.Info, 0, len(l))
+	for _, c := range l {
+		out = append(out, database.Info{
+			ID:     c.ID,
+			Type:   c.Name,
+			Status: database.StatusFromState(c.State),
+		})
+	}
+	return out, nil
+}
+
+func (c *Cockroach) startUsingDocker(ctx context.Context, timeout time.Duration) (database.CloseFunc, error) {
+	req, err := buildCreateRequest(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	crdb, err := container.Run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.containerID = crdb.ID
+
+	closeFunc := func(ctx context.Context) error {
+		return crdb.Terminate(ctx)
+	}
+
+	if err := c.WaitForStart(ctx, timeout); err != nil {
+		_ = closeFunc(ctx)
+		return nil, err
+	}
+
+	return closeFunc, nil
+}
+
+func buildCreateRequest(cfg config) (container.CreateRequest, error) {
+	var rnd, err = rand.Int(rand.Reader, big.NewInt(20))
+	if err != nil {
+		return container.CreateRequest{}, err
+	}
+
+	req := container.CreateRequest{
+		Image: getImage(cfg.version),
+		// data is kept in memory, like postgres with fsync disabled it is only meant for testing
+		Cmd:          []string{"start-single-node", "--insecure", "--store=type=mem,size=0.25"},
+		ExposedPorts: []string{fmt.Sprintf("%d:26257/tcp", cfg.port)},
+		Name:         fmt.Sprintf("dbctl_crdb_%d_%d", time.Now().Unix(), rnd.Uint64()),
+		Labels:       map[string]string{container.LabelType: database.LabelCockroach},
+	}
+
+	if cfg.label != "" {
+		req.Labels[container.LabelCustom] = cfg.label
+	}
+
+	return req, nil
+}
+
+// withName returns a cockroach controller for the database with the given name on the same instance
+func (c *Cockroach) withName(name string) *Cockroach {
+	cfg := c.cfg
+	cfg.name = name
+	return &Cockroach{containerID: c.containerID, cfg: cfg}
+}
+
+// randomDBName generates a database name in form of dbctl_<unix nano>_<random hex>
+func randomDBName() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate database name failed: %w", err)
+	}
+	return fmt.Sprintf("dbctl_%d_%x", time.Now().UnixNano(), b), nil
+}
+
+// URI returns the connection string for the database
+func (c *Cockroach) URI() string {
+	host := net.JoinHostPort(c.host(), strconv.Itoa(int(c.cfg.port)))
+	return (&url.URL{Scheme: "postgres", User: url.User(c.cfg.user), Host: host, Path: c.cfg.name, RawQuery: "sslmode=disable"}).String()
+}
+
+func (c *Cockroach) host() string {
+	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
+		return "host.docker.internal"
+	}
+	return "localhost"
+}
@@ -0,0 +1,111 @@
+package cockroach
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestBuildCreateRequest(t *testing.T) {
+	db, err := New(WithHost(DefaultUser, DefaultName, 36257), WithLabel("ci"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := buildCreateRequest(db.cfg)
+	if err != nil {
+		t.Fatalf("buildCreateRequest failed %s", err)
+	}
+
+	if req.Image != "cockroachdb/cockroach:v23.1.11" || req.Cmd[0] != "start-single-node" || req.Cmd[1] != "--insecure" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+
+	if len(req.ExposedPorts) != 1 || req.ExposedPorts[0] != "36257:26257/tcp" {
+		t.Fatalf("unexpected exposed ports %v", req.ExposedPorts)
+	}
+
+	if req.Labels[container.LabelType] != database.LabelCockroach || req.Labels[container.LabelCustom] != "ci" {
+		t.Fatalf("unexpected labels %v", req.Labels)
+	}
+
+	if db.URI() != "postgres://root@localhost:36257/defaultdb?sslmode=disable" {
+		t.Fatalf("unexpected uri %q", db.URI())
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var out bytes.Buffer
+	db, err := New(WithLogger(&out))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	// the canceled context fails start right after the first message
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = db.Start(ctx, true)
+
+	if !strings.Contains(out.String(), "INFO: Starting cockroach version") {
+		t.Fatalf("expected start message in the logger output, got %q", out.String())
+	}
+}
+
+func TestCreateDB(t *testing.T) {
+	ctx := context.Background()
+	if err := container.Ping(ctx); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	db, err := New(WithHost(DefaultUser, DefaultName, uint32(utils.GetAvailablePort())), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if err := db.Start(ctx, true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+
+	dir := t.TempDir()
+	migrations, fixtures := filepath.Join(dir, "0001.up.sql"), filepath.Join(dir, "users.sql")
+	if err := os.WriteFile(migrations, []byte("create table users(id int primary key, name string);"), 0o600); err != nil {
+		t.Fatalf("write migrations failed %s", err)
+	}
+	if err := os.WriteFile(fixtures, []byte("insert into users values (1, 'foo'), (2, 'bar');"), 0o600); err != nil {
+		t.Fatalf("write fixtures failed %s", err)
+	}
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	conn, err := sql.Open("postgres", res.URI)
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var n int
+	if err := conn.QueryRowContext(ctx, "select count(*) from users").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("expected 2 users, got %d: %v", n, err)
+	}
+
+	if err := db.RemoveDB(ctx, res.URI); err != nil {
+		t.Fatalf("RemoveDB failed %s", err)
+	}
+}
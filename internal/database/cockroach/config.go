@@ -0,0 +1,116 @@
+package cockroach
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+)
+
+type config struct {
+	user    string
+	name    string
+	port    uint32
+	version string
+
+	label string
+
+	logger io.Writer
+
+	migrationsFiles []string
+	fixtureFiles    []string
+}
+
+var (
+	supportedVersions = map[string]string{
+		"23.1.11": "cockroachdb/cockroach:v23.1.11",
+	}
+)
+
+const defaultVersion = "23.1.11"
+
+// Option is the type of the functional options for the cockroach
+type Option func(*config) error
+
+// WithHost applied user, database name and port to config,
+// the cluster runs in insecure mode so there is no password
+func WithHost(user, name string, port uint32) Option {
+	return func(c *config) error {
+		c.user = user
+		c.name = name
+		c.port = port
+		return nil
+	}
+}
+
+// WithLabel applied selected label to config
+func WithLabel(label string) Option {
+	return func(c *config) error {
+		c.label = label
+		return nil
+	}
+}
+
+// WithVersion applied selected cockroach version to config
+func WithVersion(version string) Option {
+	vv := strings.TrimSpace(version)
+	return func(c *config) error {
+		if vv == "" {
+			c.version = defaultVersion
+			return nil
+		}
+		if _, ok := supportedVersions[vv]; ok {
+			c.version = vv
+			return nil
+		}
+		return fmt.Errorf("seleced cockroach version (%s) is not supported, select one of: %s", vv, strings.Join(getVersions(), ","))
+	}
+}
+
+// WithLogger applied selected logger to config, the start up messages are written to it instead of the default logger
+func WithLogger(logger io.Writer) Option {
+	return func(c *config) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithMigrations applied selected migrations to config
+func WithMigrations(path string) Option {
+	return func(c *config) error {
+		files, err := pg.GetFiles(path)
+		if err != nil {
+			return err
+		}
+		c.migrationsFiles = files
+		return nil
+	}
+}
+
+// WithFixtures applied selected fixtures to config
+func WithFixtures(path string) Option {
+	return func(c *config) error {
+		files, err := pg.GetFiles(path)
+		if err != nil {
+			return err
+		}
+		c.fixtureFiles = files
+		return nil
+	}
+}
+
+func getVersions() []string {
+	out := make([]string, 0)
+	for k := range supportedVersions {
+		out = append(out, k)
+	}
+	return out
+}
+
+func getImage(version string) string {
+	if v, ok := supportedVersions[version]; ok {
+		return v
+	}
+	return supportedVersions[defaultVersion]
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
@@ -12,19 +13,48 @@ type CloseFunc func(ctx context.Context) error
 const (
 	Running Status = iota
 	Stoped
+	Paused
+	Restarting
+	Unknown
 )
 
+// StatusFromState maps a docker container state to a Status
+func StatusFromState(state string) Status {
+	switch state {
+	case "running":
+		return Running
+	case "paused":
+		return Paused
+	case "restarting":
+		return Restarting
+	case "created", "exited", "dead", "removing":
+		return Stoped
+	default:
+		return Unknown
+	}
+}
+
 const (
-	LabelPostgres = "postgres"
-	LabelPGWeb    = "pgweb"
-	LabelRedis    = "redis"
-	LabelTesting  = "testing"
+	LabelPostgres  = "postgres"
+	LabelPGWeb     = "pgweb"
+	LabelAdminer   = "adminer"
+	LabelRedis     = "redis"
+	LabelCockroach = "cockroach"
+	LabelMSSQL     = "mssql"
+	LabelTesting   = "testing"
 )
 
 type Info struct {
 	ID     string
 	Type   string
 	Status Status
+
+	// Port is the host port of the database, zero if unknown
+	Port uint32
+	// Version is the version of the database, empty if unknown
+	Version string
+	// Name is the instance name of the database, empty if it was started without one
+	Name string
 }
 
 type Database interface {
@@ -36,13 +66,49 @@ type Database interface {
 
 type CreateDBRequest struct {
 	Migrations string
-	Fixtures   string
+	// Fixtures are applied to every new database, also the ones cloned from a template
+	Fixtures string
 
 	WithDefaultMigrations bool
+
+	// Template is the name of the template database to clone, overrides the default template
+	Template string
+
+	// SkipTemplate forces creating a pristine database without cloning any template,
+	// migrations and fixtures are then applied from scratch
+	SkipTemplate bool
+
+	// Prefix of the generated database name, default is dbctl. It is sanitized to a valid
+	// identifier, so a test name can be used to correlate databases with the test creating them
+	Prefix string
+
+	// AfterCreate is called with a connection to the new database after migrations and fixtures are applied,
+	// to seed data from go code. An error fails the creation and removes the database
+	AfterCreate func(ctx context.Context, db *sql.DB) error
 }
 
 type CreateDBResponse struct {
 	URI string
+
+	// structured connection information, same values as encoded in URI
+	Host     string
+	Port     uint32
+	User     string
+	Password string
+	Database string
+
+	// AppliedMigrations are the versions of the migrations applied to the database, the ones of the template
+	// if it was cloned from one
+	AppliedMigrations []string
+	// AppliedFixtures are the fixture files of the request applied to the database
+	AppliedFixtures []string
+}
+
+// CreateDBResult is the outcome of creating a single database in a bulk creation,
+// either Response or Err is set
+type CreateDBResult struct {
+	Response *CreateDBResponse
+	Err      error
 }
 
 type Admin interface {
@@ -0,0 +1,104 @@
+package mssql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SplitBatches splits T-SQL into the batches separated by GO lines, like sqlcmd does. GO is only a separator
+// on a line of its own, optionally followed by a repeat count and a comment, and not inside strings, quoted
+// identifiers or block comments. A batch followed by GO n is returned n times, empty batches are dropped.
+func SplitBatches(sql string) []string {
+	var batches []string
+	add := func(batch string, n int) {
+		batch = strings.TrimSpace(batch)
+		if batch == "" {
+			return
+		}
+		for i := 0; i < n; i++ {
+			batches = append(batches, batch)
+		}
+	}
+
+	var b strings.Builder
+	var s scanState
+	for _, line := range strings.SplitAfter(sql, "\n") {
+		if s.normal() {
+			if n, ok := batchSeparator(line); ok {
+				add(b.String(), n)
+				b.Reset()
+				continue
+			}
+		}
+		s.scan(line)
+		b.WriteString(line)
+	}
+	add(b.String(), 1)
+	return batches
+}
+
+// batchSeparator reports whether line is a GO batch separator and how many times the batch is run
+func batchSeparator(line string) (int, bool) {
+	if i := strings.Index(line, "--"); i >= 0 {
+		line = line[:i]
+	}
+
+	f := strings.Fields(line)
+	if len(f) == 0 || len(f) > 2 || !strings.EqualFold(f[0], "go") {
+		return 0, false
+	}
+	if len(f) == 1 {
+		return 1, true
+	}
+
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// scanState tracks block comments, strings and quoted identifiers spanning lines
+type scanState struct {
+	// comments is the depth of nested block comments
+	comments int
+	// quote is the closing character of the open string or quoted identifier, zero if none
+	quote byte
+}
+
+func (s *scanState) normal() bool {
+	return s.comments == 0 && s.quote == 0
+}
+
+// scan advances the state over line
+func (s *scanState) scan(line string) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case s.quote != 0:
+			if c != s.quote {
+				continue
+			}
+			// a doubled closing character is part of the value
+			if i+1 < len(line) && line[i+1] == s.quote {
+				i++
+				continue
+			}
+			s.quote = 0
+		case strings.HasPrefix(line[i:], "/*"):
+			s.comments++
+			i++
+		case s.comments > 0:
+			if strings.HasPrefix(line[i:], "*/") {
+				s.comments--
+				i++
+			}
+		case strings.HasPrefix(line[i:], "--"):
+			return
+		case c == '\'' || c == '"':
+			s.quote = c
+		case c == '[':
+			s.quote = ']'
+		}
+	}
+}
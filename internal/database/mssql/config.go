@@ -0,0 +1,167 @@
+package mssql
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+)
+
+type config struct {
+	pass    string
+	name    string
+	port    uint32
+	version string
+
+	label string
+	// acceptEULA accepts the license of the SQL Server image, which does not start without it
+	acceptEULA bool
+
+	logger io.Writer
+
+	migrationsFiles []string
+	fixtureFiles    []string
+}
+
+var (
+	supportedVersions = map[string]string{
+		"2022": "mcr.microsoft.com/mssql/server:2022-latest",
+		"2019": "mcr.microsoft.com/mssql/server:2019-latest",
+	}
+)
+
+const defaultVersion = "2022"
+
+// eulaEnv is the environment variable accepting the SQL Server EULA, read from the environment of dbctl
+// and passed to the container
+const eulaEnv = "ACCEPT_EULA"
+
+// Option is the type of the functional options for the mssql
+type Option func(*config) error
+
+// WithHost applied password, database name and port to config, the login is always sa. SQL Server
+// rejects passwords shorter than 8 characters or with less than three of upper case, lower case,
+// digit and symbol characters.
+func WithHost(pass, name string, port uint32) Option {
+	return func(c *config) error {
+		if err := validatePassword(pass); err != nil {
+			return err
+		}
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("mssql database name can not be empty")
+		}
+		c.pass = pass
+		c.name = name
+		c.port = port
+		return nil
+	}
+}
+
+// WithAcceptEULA applied acceptance of the SQL Server end user license agreement to config, it defaults
+// to the ACCEPT_EULA environment variable being Y
+func WithAcceptEULA(accept bool) Option {
+	return func(c *config) error {
+		c.acceptEULA = accept
+		return nil
+	}
+}
+
+// WithLabel applied selected label to config
+func WithLabel(label string) Option {
+	return func(c *config) error {
+		c.label = label
+		return nil
+	}
+}
+
+// WithVersion applied selected SQL Server version to config
+func WithVersion(version string) Option {
+	vv := strings.TrimSpace(version)
+	return func(c *config) error {
+		if vv == "" {
+			c.version = defaultVersion
+			return nil
+		}
+		if _, ok := supportedVersions[vv]; ok {
+			c.version = vv
+			return nil
+		}
+		return fmt.Errorf("seleced mssql version (%s) is not supported, select one of: %s", vv, strings.Join(getVersions(), ","))
+	}
+}
+
+// WithLogger applied selected logger to config, the start up messages are written to it instead of the default logger
+func WithLogger(logger io.Writer) Option {
+	return func(c *config) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithMigrations applied selected migrations to config, down migrations are ignored
+func WithMigrations(paths ...string) Option {
+	return func(c *config) error {
+		files, err := pg.CollectMigrations(paths)
+		if err != nil {
+			return err
+		}
+		c.migrationsFiles = files
+		return nil
+	}
+}
+
+// WithFixtures applied selected fixtures to config
+func WithFixtures(path string) Option {
+	return func(c *config) error {
+		files, err := pg.GetFiles(path)
+		if err != nil {
+			return err
+		}
+		c.fixtureFiles = files
+		return nil
+	}
+}
+
+// eulaAccepted reports whether the environment accepts the EULA, like the SQL Server image reads it
+func eulaAccepted() bool {
+	return strings.EqualFold(os.Getenv(eulaEnv), "Y")
+}
+
+// validatePassword checks pass meets the SQL Server password policy, the container exits on a weak one
+func validatePassword(pass string) error {
+	var upper, lower, digit, symbol int
+	for _, r := range pass {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if len(pass) < 8 || upper+lower+digit+symbol < 3 {
+		return fmt.Errorf("mssql password must have at least 8 characters of three of upper case, lower case, digit and symbol characters")
+	}
+	return nil
+}
+
+func getVersions() []string {
+	out := make([]string, 0)
+	for k := range supportedVersions {
+		out = append(out, k)
+	}
+	return out
+}
+
+func getImage(version string) string {
+	if v, ok := supportedVersions[version]; ok {
+		return v
+	}
+	return supportedVersions[defaultVersion]
+}
@@ -0,0 +1,287 @@
+package mssql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// registers the sqlserver database/sql driver
+	_ "github.com/microsoft/go-mssqldb"
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+	"github.com/mirzakhany/dbctl/internal/logger"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+var _ database.Database = (*MSSQL)(nil)
+
+const (
+	// DefaultPort is the default port for mssql
+	DefaultPort = 11433
+	// DefaultUser is the login of mssql, the SQL Server image only creates the sa login
+	DefaultUser = "sa"
+	// DefaultPass is the default password of the sa login, it meets the SQL Server password policy
+	DefaultPass = "Dbctl_Passw0rd"
+	// DefaultName is the default database name for mssql, it is created on start
+	DefaultName = "dbctl"
+
+	// driverName is the database/sql driver registered by go-mssqldb
+	driverName = "sqlserver"
+	// masterDB is the system database, which exists before the database of the instance is created
+	masterDB = "master"
+)
+
+// MSSQL is a SQL Server database instance. Migrations and fixtures are read like the postgres ones
+// and run batch by batch, batches are separated by GO lines like for sqlcmd.
+type MSSQL struct {
+	containerID string
+	cfg         config
+}
+
+// New creates a new mssql database instance controller
+func New(options ...Option) (*MSSQL, error) {
+	// create mssql with default values
+	m := &MSSQL{cfg: config{
+		pass:       DefaultPass,
+		name:       DefaultName,
+		port:       DefaultPort,
+		version:    defaultVersion,
+		acceptEULA: eulaAccepted(),
+	}}
+
+	for _, o := range options {
+		if err := o(&m.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Start starts a SQL Server instance, creates the database and applies migrations and fixtures
+func (m *MSSQL) Start(ctx context.Context, detach bool) error {
+	if !m.cfg.acceptEULA {
+		return fmt.Errorf("the SQL Server image requires accepting its license, set %s=Y or use WithAcceptEULA", eulaEnv)
+	}
+
+	logger.InfoTo(m.cfg.logger, fmt.Sprintf("Starting mssql version %s on port %d ...", m.cfg.version, m.cfg.port))
+
+	// fail early with a clear error instead of a low level one from container creation
+	if err := container.Ping(ctx); err != nil {
+		return err
+	}
+
+	// SQL Server takes much longer to boot than postgres
+	closeFunc, err := m.startUsingDocker(ctx, 2*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	logger.InfoTo(m.cfg.logger, "MSSQL is up and running")
+	if err := m.setup(ctx); err != nil {
+		_ = closeFunc(context.Background())
+		return err
+	}
+
+	// print connection url
+	logger.InfoTo(m.cfg.logger, fmt.Sprintf("Database uri is: %q", m.URI()))
+
+	// detach and stop cli if asked
+	if detach {
+		return nil
+	}
+
+	<-ctx.Done()
+	logger.InfoTo(m.cfg.logger, "Shutdown signal received, stopping database")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() {
+		cancel()
+	}()
+
+	return closeFunc(shutdownCtx)
+}
+
+// setup creates the database of the instance and applies migrations and fixtures to it
+func (m *MSSQL) setup(ctx context.Context) error {
+	if err := m.createDatabase(ctx); err != nil {
+		return err
+	}
+
+	conn, err := sql.Open(driverName, m.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := applyFiles(ctx, conn, "migrations", m.cfg.migrationsFiles); err != nil {
+		return err
+	}
+	return applyFiles(ctx, conn, "fixtures", m.cfg.fixtureFiles)
+}
+
+// createDatabase creates the database of the instance if it does not exist
+func (m *MSSQL) createDatabase(ctx context.Context) error {
+	if strings.EqualFold(m.cfg.name, masterDB) {
+		return nil
+	}
+
+	conn, err := sql.Open(driverName, m.withName(masterDB).URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	stmt := fmt.Sprintf("if db_id(@p1) is null create database %s", quoteIdentifier(m.cfg.name))
+	if _, err := conn.ExecContext(ctx, stmt, m.cfg.name); err != nil {
+		return fmt.Errorf("create database failed: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the database
+func (m *MSSQL) Stop(ctx context.Context) error {
+	return container.TerminateByID(ctx, m.containerID)
+}
+
+// WaitForStart waits for SQL Server to start and accept queries, it connects to the master database
+// as the database of the instance is only created once the server is up
+func (m *MSSQL) WaitForStart(ctx context.Context, timeout time.Duration) error {
+	logger.InfoTo(m.cfg.logger, "Wait for database to boot up")
+	return utils.WaitFor(ctx, timeout, 500*time.Millisecond, 5*time.Second, func(ctx context.Context) error {
+		conn, err := sql.Open(driverName, m.withName(masterDB).URI())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		_, err = conn.ExecContext(ctx, "SELECT 1")
+		return err
+	})
+}
+
+// Instances returns a list of running mssql instances
+func Instances(ctx context.Context) ([]database.Info, error) {
+	l, err := container.List(ctx, map[string]string{container.LabelType: database.LabelMSSQL})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]database.Info, 0, len(l))
+	for _, c := range l {
+		out = append(out, database.Info{
+			ID:     c.ID,
+			Type:   c.Name,
+			Status: database.StatusFromState(c.State),
+		})
+	}
+	return out, nil
+}
+
+func (m *MSSQL) startUsingDocker(ctx context.Context, timeout time.Duration) (database.CloseFunc, error) {
+	req, err := buildCreateRequest(m.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := container.Run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	m.containerID = db.ID
+
+	closeFunc := func(ctx context.Context) error {
+		return db.Terminate(ctx)
+	}
+
+	if err := m.WaitForStart(ctx, timeout); err != nil {
+		_ = closeFunc(ctx)
+		return nil, err
+	}
+
+	return closeFunc, nil
+}
+
+func buildCreateRequest(cfg config) (container.CreateRequest, error) {
+	var rnd, err = rand.Int(rand.Reader, big.NewInt(20))
+	if err != nil {
+		return container.CreateRequest{}, err
+	}
+
+	req := container.CreateRequest{
+		Image: getImage(cfg.version),
+		Env: map[string]string{
+			eulaEnv:             "Y",
+			"MSSQL_SA_PASSWORD": cfg.pass,
+			"MSSQL_PID":         "Developer",
+		},
+		ExposedPorts: []string{fmt.Sprintf("%d:1433/tcp", cfg.port)},
+		Name:         fmt.Sprintf("dbctl_mssql_%d_%d", time.Now().Unix(), rnd.Uint64()),
+		Labels:       map[string]string{container.LabelType: database.LabelMSSQL},
+	}
+
+	if cfg.label != "" {
+		req.Labels[container.LabelCustom] = cfg.label
+	}
+
+	return req, nil
+}
+
+// applyFiles runs the batches of files in order, kind names the files in errors
+func applyFiles(ctx context.Context, conn *sql.DB, kind string, files []string) error {
+	for _, f := range files {
+		batches, err := pg.ReadStatementsFunc(f, SplitBatches)
+		if err != nil {
+			return fmt.Errorf("read %s file (%s) failed: %w", kind, f, err)
+		}
+
+		for _, batch := range batches {
+			if _, err := conn.ExecContext(ctx, batch); err != nil {
+				return fmt.Errorf("applying file (%s) failed: %w", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+// withName returns a mssql controller for the database with the given name on the same instance
+func (m *MSSQL) withName(name string) *MSSQL {
+	cfg := m.cfg
+	cfg.name = name
+	return &MSSQL{containerID: m.containerID, cfg: cfg}
+}
+
+// URI returns the connection string for the database
+func (m *MSSQL) URI() string {
+	host := net.JoinHostPort(m.host(), strconv.Itoa(int(m.cfg.port)))
+	query := url.Values{"database": {m.cfg.name}}
+	return (&url.URL{Scheme: "sqlserver", User: url.UserPassword(DefaultUser, m.cfg.pass), Host: host, RawQuery: query.Encode()}).String()
+}
+
+func (m *MSSQL) host() string {
+	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
+		return "host.docker.internal"
+	}
+	return "localhost"
+}
+
+// quoteIdentifier quotes name as a T-SQL identifier, like quotename does
+func quoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
@@ -0,0 +1,105 @@
+package pg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// sqlSource is the sql of a single file, name is the file path or archive/entry for files inside a tar archive
+type sqlSource struct {
+	name string
+	sql  string
+}
+
+// isTarGz reports whether file is a gzip compressed tar archive
+func isTarGz(file string) bool {
+	lower := strings.ToLower(file)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// readSQLSources reads the sql of file, decompressing it on the fly. A .sql.gz file is a single gzipped sql file,
+// a .tar.gz (or .tgz) archive contains sql files which are returned sorted by name like files of a directory.
+// Down migrations and non sql entries of an archive are ignored.
+func readSQLSources(file string) ([]sqlSource, error) {
+	if !strings.HasSuffix(strings.ToLower(file), ".gz") && !isTarGz(file) {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return []sqlSource{{name: file, sql: string(b)}}, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("open gzip file failed: %w", err)
+	}
+	defer gz.Close()
+
+	if !isTarGz(file) {
+		b, err := io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("decompress file failed: %w", err)
+		}
+		return []sqlSource{{name: file, sql: string(b)}}, nil
+	}
+
+	var out []sqlSource
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar archive failed: %w", err)
+		}
+
+		name := strings.ToLower(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, "down.sql") {
+			continue
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read tar entry (%s) failed: %w", hdr.Name, err)
+		}
+		out = append(out, sqlSource{name: path.Join(file, hdr.Name), sql: string(b)})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return path.Base(out[i].name) < path.Base(out[j].name) })
+	return out, nil
+}
+
+// ReadStatements reads the sql of file like migrations and fixtures are read, including compressed files
+// and archives, split into single statements for drivers that can not run several statements per exec
+func ReadStatements(file string) ([]string, error) {
+	return ReadStatementsFunc(file, SplitStatements)
+}
+
+// ReadStatementsFunc reads the sql of file like ReadStatements and splits it with split, for databases with
+// their own statement separators
+func ReadStatementsFunc(file string, split func(sql string) []string) ([]string, error) {
+	sources, err := readSQLSources(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, s := range sources {
+		out = append(out, split(s.sql)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package pg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeGzip(t *testing.T, path string, content string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatalf("gzip write failed %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close failed %s", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("write file failed %s", err)
+	}
+}
+
+// writeTarGz writes the entries in the given order, names are [name, content] pairs
+func writeTarGz(t *testing.T, path string, entries [][2]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e[0], Mode: 0o600, Size: int64(len(e[1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("tar header failed %s", err)
+		}
+		if _, err := tw.Write([]byte(e[1])); err != nil {
+			t.Fatalf("tar write failed %s", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close failed %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close failed %s", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("write file failed %s", err)
+	}
+}
+
+func TestReadSQLSources(t *testing.T) {
+	dir := t.TempDir()
+
+	gzFile := filepath.Join(dir, "seed.sql.gz")
+	writeGzip(t, gzFile, "insert into users values (1);")
+	sources, err := readSQLSources(gzFile)
+	if err != nil {
+		t.Fatalf("readSQLSources failed %s", err)
+	}
+	if !reflect.DeepEqual(sources, []sqlSource{{name: gzFile, sql: "insert into users values (1);"}}) {
+		t.Fatalf("unexpected sources %+v", sources)
+	}
+
+	tarFile := filepath.Join(dir, "migrations.tar.gz")
+	writeTarGz(t, tarFile, [][2]string{
+		{"migrations/0002_orders.up.sql", "create table orders(id int);"},
+		{"migrations/0002_orders.down.sql", "drop table orders;"},
+		{"migrations/README.md", "not sql"},
+		{"migrations/0001_users.up.sql", "create table users(id int);"},
+	})
+	sources, err = readSQLSources(tarFile)
+	if err != nil {
+		t.Fatalf("readSQLSources failed %s", err)
+	}
+
+	expected := []sqlSource{
+		{name: filepath.Join(tarFile, "migrations/0001_users.up.sql"), sql: "create table users(id int);"},
+		{name: filepath.Join(tarFile, "migrations/0002_orders.up.sql"), sql: "create table orders(id int);"},
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Fatalf("expected sources %+v, got %+v", expected, sources)
+	}
+
+	if err := ValidateMigrations([]string{gzFile, tarFile}); err != nil {
+		t.Fatalf("ValidateMigrations failed %s", err)
+	}
+}
+
+func TestApplyGzipFixtures(t *testing.T) {
+	db := startTestPostgres(t)
+
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "schema.tar.gz")
+	writeTarGz(t, migrations, [][2]string{
+		{"0002_index.up.sql", "create index users_name on users(name);"},
+		{"0001_users.up.sql", "create table users(id int, name text);"},
+	})
+	fixtures := filepath.Join(dir, "users.sql.gz")
+	writeGzip(t, fixtures, "insert into users select i, 'user' || i from generate_series(1, 100) i;")
+
+	if err := RunMigrations(context.Background(), nil, []string{migrations}, db.URI()); err != nil {
+		t.Fatalf("RunMigrations failed %s", err)
+	}
+	if err := ApplyFixtures(context.Background(), nil, []string{fixtures}, db.URI()); err != nil {
+		t.Fatalf("ApplyFixtures failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), "users"); n != 100 {
+		t.Fatalf("expected 100 users, got %d", n)
+	}
+}
@@ -0,0 +1,103 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// Assertion is a query checked once migrations and fixtures are applied, see WithAssertions. Without Value the
+// number of rows returned by Query must be Rows, with Value the first column of the single returned row must be
+// Value, compared as text, like 3 for select count(*) from users.
+type Assertion struct {
+	// Name describes the assertion in errors, the query is used if empty
+	Name  string
+	Query string
+	Rows  int
+	Value any
+}
+
+func (a Assertion) String() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Query
+}
+
+// check runs the query of the assertion on conn and returns an error describing a mismatch
+func (a Assertion) check(ctx context.Context, conn *sql.DB) error {
+	rows, err := conn.QueryContext(ctx, a.Query)
+	if err != nil {
+		return fmt.Errorf("assertion %q failed: %w", a, err)
+	}
+	defer rows.Close()
+
+	n := 0
+	var got sql.NullString
+	for rows.Next() {
+		n++
+		if a.Value != nil && n == 1 {
+			cols, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			// only the first column is compared
+			dest := make([]any, len(cols))
+			dest[0] = &got
+			for i := 1; i < len(dest); i++ {
+				dest[i] = new(any)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return fmt.Errorf("assertion %q failed: %w", a, err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("assertion %q failed: %w", a, err)
+	}
+
+	if a.Value == nil {
+		if n != a.Rows {
+			return fmt.Errorf("assertion %q failed, expected %d rows, got %d", a, a.Rows, n)
+		}
+		return nil
+	}
+
+	expected := fmt.Sprint(a.Value)
+	switch {
+	case n != 1:
+		return fmt.Errorf("assertion %q failed, expected a single row with value %s, got %d rows", a, expected, n)
+	case !got.Valid:
+		return fmt.Errorf("assertion %q failed, expected value %s, got null", a, expected)
+	case got.String != expected:
+		return fmt.Errorf("assertion %q failed, expected value %s, got %s", a, expected, got.String)
+	}
+	return nil
+}
+
+// checkAssertions checks all assertions of WithAssertions and returns the failed ones joined
+func (p *Postgres) checkAssertions(ctx context.Context) error {
+	if len(p.cfg.assertions) == 0 {
+		return nil
+	}
+
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p.log(logger.LevelInfo, "checking_assertions", "Checking assertions ...", fields{"assertions": len(p.cfg.assertions)})
+	var errs []error
+	for _, a := range p.cfg.assertions {
+		if err := a.check(ctx, conn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,72 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestWithAssertionsValidation(t *testing.T) {
+	if _, err := New(WithAssertions(Assertion{Rows: 1})); err == nil {
+		t.Fatal("expected error for an assertion without query")
+	}
+	if _, err := New(WithAssertions(Assertion{Query: "select 1", Rows: -1})); err == nil {
+		t.Fatal("expected error for a negative number of rows")
+	}
+}
+
+func TestWithAssertions(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{"0001_users.up.sql": "create table users(id int, name text);"})
+	fixtures := writeSQLFiles(t, map[string]string{"users.sql": "insert into users values (1, 'a'), (2, 'b');"})
+
+	db := startTestPostgres(t, WithMigrations(migrations), WithFixtures(fixtures), WithAssertions(
+		Assertion{Query: "select * from users", Rows: 2},
+		Assertion{Name: "admin exists", Query: "select name from users where id = 1", Value: "a"},
+		Assertion{Query: "select count(*) from users", Value: 2},
+	))
+
+	db.cfg.assertions = []Assertion{
+		{Query: "select * from users", Rows: 3},
+		{Name: "admin exists", Query: "select name from users where id = 3", Value: "a"},
+		{Query: "select count(*) from users", Value: 2},
+	}
+	err := db.checkAssertions(context.Background())
+	if err == nil {
+		t.Fatal("expected failed assertions")
+	}
+	for _, msg := range []string{`"select * from users" failed, expected 3 rows, got 2`, `"admin exists" failed, expected a single row with value a, got 0 rows`} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected error to contain %q, got %s", msg, err)
+		}
+	}
+	if strings.Contains(err.Error(), "count(*)") {
+		t.Fatalf("expected the passing assertion not to be reported, got %s", err)
+	}
+}
+
+func TestWithAssertionsFailStart(t *testing.T) {
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	migrations := writeSQLFiles(t, map[string]string{"0001_users.up.sql": "create table users(id int);"})
+	db, err := New(WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())), WithLogger(io.Discard),
+		WithMigrations(migrations), WithAssertions(Assertion{Query: "select * from users", Rows: 1}))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	err = db.Start(context.Background(), true)
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) || startErr.Phase != PhaseAssertions {
+		t.Fatalf("expected assertions phase error, got %v", err)
+	}
+}
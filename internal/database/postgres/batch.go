@@ -0,0 +1,154 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+// createDatabasesWorkers is the number of databases CreateDatabases creates concurrently
+const createDatabasesWorkers = 8
+
+// CreateDatabases creates n databases for the request and returns them like CreateDB does. The databases are created
+// concurrently over a pool of up to createDatabasesWorkers connections, a request with migrations and no template migrates the first
+// database only and clones the others from it. If any database fails, the ones already created are removed.
+func (p *Postgres) CreateDatabases(ctx context.Context, n int, req *database.CreateDBRequest) ([]*database.CreateDBResponse, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of databases must be positive, got %d", n)
+	}
+
+	start := time.Now()
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	workers := createDatabasesWorkers
+	if n < workers {
+		workers = n
+	}
+	conn.SetMaxOpenConns(workers)
+
+	names := make([]string, n)
+	removeCreated := func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, name := range names {
+			if name != "" {
+				_ = p.RemoveDB(cleanupCtx, p.withName(name).URI())
+			}
+		}
+	}
+
+	// migrate once and clone the rest, cloning is much faster than running migrations again
+	var source string
+	if !req.SkipTemplate && !req.WithDefaultMigrations && req.Template == "" && len(req.Migrations) > 0 {
+		// the name is also returned if migrating the database failed, so it is removed
+		source, err = p.createDatabaseWithMigrations(ctx, conn, req.Prefix, req.Migrations)
+		names[0] = source
+		if err != nil {
+			removeCreated()
+			return nil, err
+		}
+	}
+
+	err = parallel(ctx, n, workers, func(ctx context.Context, i int) error {
+		if names[i] != "" {
+			return nil
+		}
+
+		var name string
+		var err error
+		if source != "" {
+			name, err = p.createWithUniqueName(req.Prefix, func(name string) error {
+				return p.clone(ctx, conn, source, name)
+			})
+		} else {
+			name, err = p.createDatabaseForRequest(ctx, conn, req)
+		}
+		names[i] = name
+		return err
+	})
+	if err != nil {
+		removeCreated()
+		return nil, err
+	}
+
+	// fixtures and the callback belong to the request, so they are applied after cloning
+	responses := make([]*database.CreateDBResponse, n)
+	err = parallel(ctx, n, workers, func(ctx context.Context, i int) error {
+		newDB := p.withName(names[i])
+		fixtures, err := p.applyFixturesFromDir(ctx, req.Fixtures, newDB.URI())
+		if err != nil {
+			return err
+		}
+		if err := runAfterCreate(ctx, req, newDB.URI()); err != nil {
+			return err
+		}
+
+		migrations, err := appliedMigrations(ctx, newDB.URI())
+		if err != nil {
+			return err
+		}
+		responses[i] = newDB.createDBResponse()
+		responses[i].AppliedMigrations, responses[i].AppliedFixtures = migrations, fixtures
+		return nil
+	})
+	if err != nil {
+		removeCreated()
+		return nil, err
+	}
+
+	for _, name := range names {
+		p.observer().DBCreated(name, time.Since(start))
+	}
+	return responses, nil
+}
+
+// parallel calls fn for indexes 0 to n-1 using a pool of workers, the first error cancels the
+// context passed to the remaining calls and is returned
+func parallel(ctx context.Context, n, workers int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(ctx, i); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,123 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+func TestCreateDatabases(t *testing.T) {
+	db := startTestPostgres(t)
+
+	migrations := writeSQLFiles(t, map[string]string{"001_foo.up.sql": "create table foo(id int);"})
+	fixtures := writeSQLFiles(t, map[string]string{"foo.sql": "insert into foo values (1), (2);"})
+
+	const n = 5
+	dbs, err := db.CreateDatabases(context.Background(), n, &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures})
+	if err != nil {
+		t.Fatalf("CreateDatabases failed %s", err)
+	}
+
+	if len(dbs) != n {
+		t.Fatalf("expected %d databases, got %d", n, len(dbs))
+	}
+
+	seen := make(map[string]bool)
+	for _, res := range dbs {
+		if seen[res.URI] {
+			t.Fatalf("duplicate database uri %q", res.URI)
+		}
+		seen[res.URI] = true
+
+		// every database is migrated and has its own fixtures
+		if got := countRows(t, res.URI, "foo"); got != 2 {
+			t.Fatalf("expected 2 rows in foo of %q, got %d", res.URI, got)
+		}
+		if expected := []string{filepath.Join(fixtures, "foo.sql")}; !reflect.DeepEqual(res.AppliedMigrations, []string{"001"}) || !reflect.DeepEqual(res.AppliedFixtures, expected) {
+			t.Fatalf("expected applied migrations [001] and fixtures %v, got %v and %v", expected, res.AppliedMigrations, res.AppliedFixtures)
+		}
+	}
+}
+
+func TestCreateDatabasesMigrationError(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	migrations := writeSQLFiles(t, map[string]string{"001_foo.up.sql": "create table foo(id int); insert into missing values (1);"})
+	if _, err := db.CreateDatabases(ctx, 3, &database.CreateDBRequest{Migrations: migrations, Prefix: "batchfail"}); err == nil {
+		t.Fatal("expected CreateDatabases to fail")
+	}
+
+	names, err := db.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed %s", err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, "batchfail") {
+			t.Fatalf("expected the half migrated database to be removed, got %s", name)
+		}
+	}
+}
+
+func TestCreateDatabasesInvalidCount(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if _, err := db.CreateDatabases(context.Background(), 0, &database.CreateDBRequest{}); err == nil {
+		t.Fatalf("expected error for zero databases")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	var calls int32
+	err := parallel(context.Background(), 10, 3, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	if err != nil || calls != 10 {
+		t.Fatalf("expected 10 calls without error, got %d calls and %v", calls, err)
+	}
+
+	failed := errors.New("failed")
+	err = parallel(context.Background(), 100, 2, func(ctx context.Context, i int) error {
+		if i == 3 {
+			return failed
+		}
+		return nil
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected first error to be returned, got %v", err)
+	}
+}
+
+func BenchmarkCreateDatabases(b *testing.B) {
+	db := startTestPostgres(b)
+	migrations := writeSQLFiles(b, map[string]string{
+		"001_foo.up.sql": "create table foo(id int primary key, name text);",
+		"002_bar.up.sql": "create table bar(id int references foo(id));",
+	})
+	req := &database.CreateDBRequest{Migrations: migrations}
+
+	const n = 10
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dbs, err := db.CreateDatabases(context.Background(), n, req)
+		if err != nil {
+			b.Fatalf("CreateDatabases failed %s", err)
+		}
+
+		b.StopTimer()
+		for _, res := range dbs {
+			_ = db.RemoveDB(context.Background(), res.URI)
+		}
+		b.StartTimer()
+	}
+}
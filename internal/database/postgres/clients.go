@@ -0,0 +1,86 @@
+package pg
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PsqlCommand returns a psql command line connecting to the database, like
+// PGPASSWORD=secret psql -h localhost -p 5432 -U postgres -d postgres. The password is passed in the
+// environment, as psql prompts for it otherwise, and values are quoted for a posix shell when needed.
+func (p *Postgres) PsqlCommand() string {
+	host, port := p.host(), strconv.Itoa(int(p.cfg.port))
+	if p.cfg.unixSocketDir != "" {
+		// psql connects to the socket of the port inside the container, like the uri
+		host, port = p.cfg.unixSocketDir, "5432"
+	}
+
+	env := []string{"PGPASSWORD=" + shellQuote(p.cfg.pass)}
+	if p.cfg.sslMode != "" && p.cfg.sslMode != "disable" {
+		for _, v := range []struct{ name, value string }{
+			{"PGSSLMODE", p.cfg.sslMode},
+			{"PGSSLROOTCERT", p.cfg.sslRootCert},
+			{"PGSSLCERT", p.cfg.sslCert},
+			{"PGSSLKEY", p.cfg.sslKey},
+		} {
+			if v.value != "" {
+				env = append(env, v.name+"="+shellQuote(v.value))
+			}
+		}
+	}
+	if p.cfg.timezone != "" {
+		env = append(env, "PGTZ="+shellQuote(p.cfg.timezone))
+	}
+
+	args := []string{"psql", "-h", host, "-p", port, "-U", p.cfg.user, "-d", p.cfg.name}
+	for i, a := range args {
+		args[i] = shellQuote(a)
+	}
+	return strings.Join(env, " ") + " " + strings.Join(args, " ")
+}
+
+// JDBCURL returns the jdbc url of the database for java clients like DBeaver, with the credentials and ssl
+// parameters in the query, like jdbc:postgresql://localhost:5432/postgres?password=secret&sslmode=disable&user=postgres
+func (p *Postgres) JDBCURL() string {
+	q := p.sslParams()
+	q.Set("user", p.cfg.user)
+	q.Set("password", p.cfg.pass)
+
+	u := url.URL{Host: net.JoinHostPort(p.host(), strconv.Itoa(int(p.cfg.port))), Path: "/" + p.cfg.name, RawQuery: q.Encode()}
+	// url.URL can't have the jdbc:postgresql scheme, the colon is not allowed in a scheme
+	return "jdbc:postgresql:" + u.String()
+}
+
+// EnvFile returns the connection details of the database as .env lines for CI pipelines, like
+// PREFIX_HOST=localhost, with PORT, USER, PASSWORD, DATABASE and URL variables. Without a prefix the variables
+// are named HOST, PORT and so on. Values are quoted like PsqlCommand, so the file can also be sourced by a shell.
+func (p *Postgres) EnvFile(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	var b strings.Builder
+	for _, v := range []struct{ name, value string }{
+		{"HOST", p.host()},
+		{"PORT", strconv.Itoa(int(p.cfg.port))},
+		{"USER", p.cfg.user},
+		{"PASSWORD", p.cfg.pass},
+		{"DATABASE", p.cfg.name},
+		{"URL", p.URI()},
+	} {
+		b.WriteString(prefix + v.name + "=" + shellQuote(v.value) + "\n")
+	}
+	return b.String()
+}
+
+// shellQuote quotes s for a posix shell unless it only has characters without special meaning
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@%+=,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
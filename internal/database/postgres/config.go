@@ -2,14 +2,26 @@ package pg
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/container"
 )
 
+// containerNamePattern matches the names docker accepts for containers
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 type config struct {
 	pass    string
 	user    string
@@ -18,16 +30,161 @@ type config struct {
 	version string
 
 	label string
+	// instanceName names the instance, so it can be told apart from other instances and stopped by name
+	instanceName string
 
-	withUI bool
-	logger io.Writer
+	uiBackend UIBackend
+	logger    io.Writer
 
 	migrationsFiles []string
 	fixtureFiles    []string
+	// network is the user defined docker network the container joins, with networkAliases as extra host names
+	network        string
+	networkAliases []string
+	// fixturesPath is the path given to WithFixtures, the base directory of fixture profiles
+	fixturesPath string
+	// fixtureProfile selects the fixtures subdirectory of fixturesPath loaded after the common one
+	fixtureProfile string
+	// strictTemplate fails Start and CreateDB if saving a template database fails
+	strictTemplate bool
+	// goMigrations are applied along with the migration files, ordered by version
+	goMigrations []Migrator
+
+	// host directory WAL segments get archived into, archiving is disabled if empty
+	walArchiveDir string
+
+	// startupTimeout is how long Start waits for the database to be ready
+	startupTimeout time.Duration
+	// shutdownTimeout is how long postgres may take to shut down before its container is killed
+	shutdownTimeout time.Duration
+	// pullTimeout bounds running the container, including pulling the image
+	pullTimeout time.Duration
+	// pollInterval is the initial interval between readiness checks
+	pollInterval time.Duration
+
+	// dumpOptions are extra pg_dump flags used by Dump
+	dumpOptions []string
+	// dataDir is the host directory mounted as postgres data directory
+	dataDir string
+	// fixtureWorkers is the number of fixture files applied concurrently
+	fixtureWorkers int
+	// continueOnFixtureError applies the remaining fixture files after one fails
+	continueOnFixtureError bool
+	// resetSequences advances the sequences of serial and identity columns once fixtures are applied
+	resetSequences bool
+	// fixturesChecksum is the expected sha256 of the fixture files, not verified if empty
+	fixturesChecksum string
+	// assertions are checked once migrations and fixtures are applied
+	assertions []Assertion
+	// skipMigrations and skipFixtures skip applying migrations and fixtures on Start
+	skipMigrations bool
+	skipFixtures   bool
+	// logFormat is the format of emitted logs
+	logFormat LogFormat
+	// sslMode, sslRootCert, sslCert and sslKey are the ssl parameters of the database uri
+	sslMode     string
+	sslRootCert string
+	sslCert     string
+	sslKey      string
+	// idleTimeout stops the database after being idle for this duration, zero disables it
+	idleTimeout time.Duration
+	// observer is notified about timings of operations
+	observer Observer
+	// dryRun only logs the resolved container request and sql files on Start
+	dryRun bool
+	// unixSocketDir is the host directory the postgres unix socket is mounted into
+	unixSocketDir string
+	// appName is the application_name of the database uri
+	appName string
+	// cleanOrphans drops databases left behind by previous runs on Start
+	cleanOrphans bool
+	// readyCallback is called with the database uri once the database is ready
+	readyCallback func(uri string)
+	// signalHandling cancels Start on exit signals, for callers not wiring signals into the context
+	signalHandling bool
+	// keepOnFailure leaves the containers of a failed Start and the database of a failed CreateDB for inspection
+	keepOnFailure bool
+	// reuse attaches Start to a running container of the same configuration
+	reuse bool
+	// externalHost is the host of an external postgres set by WithExternalURI, no container is managed then
+	externalHost string
+	// memory in bytes and cpus limit the resources of the container, zero is unlimited
+	memory int64
+	cpus   float64
+	// initScripts are host files mounted into the init directory of the image, run on a fresh data directory
+	initScripts []string
+	// timezone is the time zone of the server and of connections, the image default UTC if empty
+	timezone string
+	// restartPolicy is the docker restart policy of the container, like unless-stopped
+	restartPolicy string
+	// pullPolicy tells when the postgres image is pulled
+	pullPolicy container.PullPolicy
+	// autoTemplate saves the migrated database of a CreateDB as template for the next ones with the same migrations
+	autoTemplate bool
+	// startRetries is how many times a transient container start failure is retried
+	startRetries int
+	// initdbArgs are extra initdb arguments used when the cluster is initialized
+	initdbArgs string
+	// autoPort lets docker pick a free host port, which is resolved after the container is started
+	autoPort bool
+	// extensions are created in the database before migrations are applied
+	extensions []string
+	// initSQL are statements executed once the server is ready, before migrations are applied
+	initSQL []string
+	// appUser and appPass are the credentials of the unprivileged application role
+	appUser string
+	appPass string
+	// namePrefix is the prefix of the container name
+	namePrefix string
+	// labels are user labels added to the container
+	labels map[string]string
+	// replica starts a streaming read replica next to the primary, on replicaPort or a port docker picks if zero
+	replica     bool
+	replicaPort uint32
+	// versionSet is set by WithVersion, the version is then validated once all options are applied
+	versionSet bool
+	// imageResolver maps the version to an image, the built-in images are used if nil
+	imageResolver ImageResolver
+	// encoding is the encoding and locale of databases created from scratch
+	encoding dbEncoding
+}
+
+// dbEncoding is the encoding and locale of a new database, empty values are inherited from template1
+type dbEncoding struct {
+	encoding string
+	collate  string
+	ctype    string
+}
+
+// clause returns the create database options of the encoding, empty if nothing is set. A database with another
+// encoding or locale than template1 can only be copied from template0.
+func (e dbEncoding) clause() string {
+	if e == (dbEncoding{}) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(" with template template0")
+	for _, opt := range [][2]string{{"encoding", e.encoding}, {"lc_collate", e.collate}, {"lc_ctype", e.ctype}} {
+		if opt[1] != "" {
+			b.WriteString(" " + opt[0] + " " + pq.QuoteLiteral(opt[1]))
+		}
+	}
+	return b.String()
 }
 
 var (
+	// supportedVersions maps versions to images, a major version like 14 selects the official postgres image,
+	// and a <postgres>.<postgis> tuple like 14.3.2 selects a postgis image
 	supportedVersions = map[string]string{
+		"10": "postgres:10-alpine",
+		"11": "postgres:11-alpine",
+		"12": "postgres:12-alpine",
+		"13": "postgres:13-alpine",
+		"14": "postgres:14-alpine",
+		"15": "postgres:15-alpine",
+		"16": "postgres:16-alpine",
+
 		"10.3.2": "postgis/postgis:10-3.2-alpine",
 		"11.2.5": "postgis/postgis:11-2.5-alpine",
 		"11.3.2": "postgis/postgis:11-3.2-alpine",
@@ -35,16 +192,21 @@ var (
 		"13-3.1": "odidev/postgis:13-3.1-alpine",
 		"13.3.2": "postgis/postgis:13-3.2-alpine",
 		"14.3.2": "postgis/postgis:14-3.2-alpine",
+		"15.3.4": "postgis/postgis:15-3.4-alpine",
+		"16.3.4": "postgis/postgis:16-3.4-alpine",
 	}
 )
 
 // Option is the type of the functional options for the postgres
 type Option func(*config) error
 
-// WithUI applied withUI option to config
-func WithUI(withIU bool) Option {
+// WithUI applied ui backend (UIPgweb or UIAdminer) to config, UINone disables the ui
+func WithUI(backend UIBackend) Option {
 	return func(c *config) error {
-		c.withUI = withIU
+		if _, ok := uiBackends[backend]; backend != UINone && !ok {
+			return fmt.Errorf("unknown ui backend %q, use %s or %s", backend, UIPgweb, UIAdminer)
+		}
+		c.uiBackend = backend
 		return nil
 	}
 }
@@ -57,6 +219,19 @@ func WithLabel(label string) Option {
 	}
 }
 
+// WithInstanceName applied instance name to config. Instances report it and StopByName stops the instance
+// by it, so several databases like an app and an analytics postgres can run side by side. Start fails if an
+// instance with the name is already running, unless WithReuse attaches to it.
+func WithInstanceName(name string) Option {
+	return func(c *config) error {
+		if name != "" && !containerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid instance name %q, use letters, digits, '_', '.' and '-'", name)
+		}
+		c.instanceName = name
+		return nil
+	}
+}
+
 // WithHost applied selected postgres host to config
 func WithHost(user, pass, name string, port uint32) Option {
 	return func(c *config) error {
@@ -68,6 +243,85 @@ func WithHost(user, pass, name string, port uint32) Option {
 	}
 }
 
+// WithExternalURI applied an external postgres to config, like a server of the environment where docker is not
+// available. Start does not run any container, it waits for the server to accept queries and applies migrations
+// and fixtures, and Stop leaves the server alone. The options of the uri override the ones of WithHost and
+// WithSSLMode, the port defaults to 5432 and the database name to the user.
+func WithExternalURI(uri string) Option {
+	return func(c *config) error {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("parse external uri failed: %w", err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return fmt.Errorf("unsupported external uri scheme %q, use postgres://", u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return errors.New("external uri has no host")
+		}
+
+		c.externalHost = u.Hostname()
+		c.port = 5432
+		if p := u.Port(); p != "" {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid external uri port %q: %w", p, err)
+			}
+			c.port = uint32(port)
+		}
+
+		c.user = u.User.Username()
+		c.pass, _ = u.User.Password()
+		c.name = strings.TrimPrefix(u.Path, "/")
+		if c.name == "" {
+			c.name = c.user
+		}
+
+		q := u.Query()
+		if mode := q.Get("sslmode"); mode != "" {
+			if !sslModes[mode] {
+				return fmt.Errorf("unsupported ssl mode %q", mode)
+			}
+			c.sslMode = mode
+		}
+		c.sslRootCert, c.sslCert, c.sslKey = q.Get("sslrootcert"), q.Get("sslcert"), q.Get("sslkey")
+		return nil
+	}
+}
+
+// WithAutoPort applied ephemeral port mapping to config, docker picks a free host port
+// and Port and URI return it once the database is started. It overrides the port of WithHost.
+func WithAutoPort() Option {
+	return func(c *config) error {
+		c.autoPort = true
+		return nil
+	}
+}
+
+// WithReadReplica applied a streaming read replica to config, it is started on the given host port
+// once the primary is set up and is reachable with ReplicaURI. With port zero docker picks a free port.
+func WithReadReplica(port uint32) Option {
+	return func(c *config) error {
+		c.replica = true
+		c.replicaPort = port
+		return nil
+	}
+}
+
+// WithDatabaseEncoding applied encoding, collation and character classification to config, they are used
+// by CreateDB for databases created from scratch and for templates, and by Reset. Databases cloned from a template
+// inherit the template ones, empty values are inherited from template1.
+func WithDatabaseEncoding(encoding, collate, ctype string) Option {
+	return func(c *config) error {
+		enc := dbEncoding{encoding: strings.TrimSpace(encoding), collate: strings.TrimSpace(collate), ctype: strings.TrimSpace(ctype)}
+		if enc == (dbEncoding{}) {
+			return fmt.Errorf("database encoding, collate or ctype must be set")
+		}
+		c.encoding = enc
+		return nil
+	}
+}
+
 // WithVersion applied selected postgres version to config
 func WithVersion(version string) Option {
 	vv := strings.TrimSpace(version)
@@ -76,15 +330,84 @@ func WithVersion(version string) Option {
 			c.version = "13-3.1"
 			return nil
 		}
-		versions := getVersions()
-		for _, v := range versions {
-			if v == vv {
-				c.version = version
-				return nil
-			}
+		// checked by New, once an image resolver accepting other versions may be configured
+		c.version = vv
+		c.versionSet = true
+		return nil
+	}
+}
+
+// ImageResolver maps a postgres version to the image started for it, see WithImageResolver
+type ImageResolver func(version string) (string, error)
+
+// DefaultImageResolver resolves versions to the built-in images, unknown versions fall back to a postgis 13 image
+func DefaultImageResolver(version string) (string, error) {
+	return getImage(version), nil
+}
+
+// WithImageResolver applied a custom version to image mapping to config, like images of a private mirror.
+// Versions of WithVersion are not checked against the built-in ones, the resolver is called instead.
+func WithImageResolver(resolver ImageResolver) Option {
+	return func(c *config) error {
+		if resolver == nil {
+			return fmt.Errorf("image resolver can not be nil")
 		}
-		return fmt.Errorf("seleced postgres version (%s) is not supported, select one of: %s", vv, strings.Join(versions, ","))
+		c.imageResolver = resolver
+		return nil
+	}
+}
+
+// validateVersion checks the version selected by WithVersion is known by the image resolver
+func (c config) validateVersion() error {
+	if !c.versionSet {
+		return nil
+	}
+
+	if c.imageResolver != nil {
+		if _, err := c.imageResolver(c.version); err != nil {
+			return fmt.Errorf("resolve image of postgres version %s failed: %w", c.version, err)
+		}
+		return nil
+	}
+
+	if _, ok := supportedVersions[c.version]; !ok {
+		return &UnsupportedVersionError{Version: c.version, Supported: getVersions()}
+	}
+	return nil
+}
+
+// validateExternal checks no container option is combined with WithExternalURI, as no container is started
+func (c config) validateExternal() error {
+	if c.externalHost == "" {
+		return nil
+	}
+
+	switch {
+	case c.replica:
+		return errors.New("a read replica can not be started for an external postgres")
+	case c.dataDir != "":
+		return errors.New("a data dir can not be mounted into an external postgres")
+	case c.uiBackend != UINone:
+		return errors.New("the ui can not be started for an external postgres")
+	case c.reuse:
+		return errors.New("an external postgres can not be reused, it is never started")
+	case len(c.initScripts) > 0:
+		return errors.New("init scripts can not be mounted into an external postgres, use migrations instead")
+	}
+	return nil
+}
+
+// image returns the image of the selected version
+func (c config) image() (string, error) {
+	if c.imageResolver == nil {
+		return DefaultImageResolver(c.version)
 	}
+
+	image, err := c.imageResolver(c.version)
+	if err != nil {
+		return "", fmt.Errorf("resolve image of postgres version %s failed: %w", c.version, err)
+	}
+	return image, nil
 }
 
 func getVersions() []string {
@@ -92,10 +415,201 @@ func getVersions() []string {
 	for k := range supportedVersions {
 		out = append(out, k)
 	}
+	sort.Strings(out)
 	return out
 }
 
-// WithLogger applied selected logger to config
+// WithWALArchiving enables WAL archiving into the given host directory,
+// the directory will be created if it does not exist
+func WithWALArchiving(dir string) Option {
+	return func(c *config) error {
+		if dir == "" {
+			return nil
+		}
+
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("get wal archive absolute path failed: %w", err)
+		}
+
+		if err := os.MkdirAll(absPath, 0o777); err != nil {
+			return fmt.Errorf("create wal archive directory failed: %w", err)
+		}
+
+		// the archive command runs as the postgres user of the container, which must be able to write the segments
+		if err := os.Chmod(absPath, 0o777); err != nil {
+			return fmt.Errorf("change wal archive directory permissions failed: %w", err)
+		}
+
+		c.walArchiveDir = absPath
+		return nil
+	}
+}
+
+// WithStartupTimeout applied the time Start waits for the database to be ready to config
+func WithStartupTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("startup timeout must be positive, got %s", d)
+		}
+		c.startupTimeout = d
+		return nil
+	}
+}
+
+// WithShutdownTimeout applied the time postgres may take to shut down to config. Stopping sends the stop signal
+// of the image first, a fast shutdown writing a checkpoint, and kills the container only once the timeout is
+// over, large databases with a data directory may need longer to flush. Default is 10 seconds.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("shutdown timeout must be positive, got %s", d)
+		}
+		c.shutdownTimeout = d
+		return nil
+	}
+}
+
+// WithPullTimeout applied the time running the container may take to config, it covers pulling the image,
+// creating and starting the container and is separate from the startup timeout. Default is 10 minutes.
+func WithPullTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("pull timeout must be positive, got %s", d)
+		}
+		c.pullTimeout = d
+		return nil
+	}
+}
+
+// WithPollInterval applied the initial interval between readiness checks to config,
+// the interval grows exponentially between attempts
+func WithPollInterval(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("poll interval must be positive, got %s", d)
+		}
+		c.pollInterval = d
+		return nil
+	}
+}
+
+// WithDataDir mounts the given host directory as postgres data directory, so the database survives restarts.
+// the directory will be created if it does not exist
+func WithDataDir(dir string) Option {
+	return func(c *config) error {
+		if dir == "" {
+			return nil
+		}
+
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("get data directory absolute path failed: %w", err)
+		}
+
+		if err := os.MkdirAll(absPath, 0o777); err != nil {
+			return fmt.Errorf("create data directory failed: %w", err)
+		}
+
+		c.dataDir = absPath
+		return nil
+	}
+}
+
+// WithParallelFixtures applied number of workers used to apply fixture files concurrently to config.
+// Files are picked up in order but can finish in any order, so it must only be used with fixtures
+// that do not depend on each other (e.g. one file per table without foreign keys between them).
+// Migrations are always applied sequentially.
+func WithParallelFixtures(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return fmt.Errorf("parallel fixtures workers must be positive, got %d", n)
+		}
+		c.fixtureWorkers = n
+		return nil
+	}
+}
+
+// WithContinueOnFixtureError applied continue on fixture error option to config. By default applying fixtures
+// stops at the first failing file, when enabled the remaining files are still applied. Either way the returned
+// FixtureError lists the files which were applied, failed and skipped.
+func WithContinueOnFixtureError(enabled bool) Option {
+	return func(c *config) error {
+		c.continueOnFixtureError = enabled
+		return nil
+	}
+}
+
+// WithFixturesChecksum applied expected sha256 of the fixture files to config, as hex. Start fails before
+// running the container if the fixture files concatenated in the order they are applied have another
+// checksum, so edited seed data is noticed. FixturesChecksum computes the value to record.
+func WithFixturesChecksum(checksum string) Option {
+	return func(c *config) error {
+		checksum = strings.ToLower(checksum)
+		if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid fixtures checksum %q, expected a hex sha256", checksum)
+		}
+		c.fixturesChecksum = checksum
+		return nil
+	}
+}
+
+// WithResetSequences applied reset sequences option to config. Once fixtures are applied, the sequence of every
+// serial and identity column is set to the largest value of its column, so fixtures can insert rows with explicit
+// ids and later inserts without an id don't fail on duplicate keys, see ResetSequences
+func WithResetSequences(enabled bool) Option {
+	return func(c *config) error {
+		c.resetSequences = enabled
+		return nil
+	}
+}
+
+// WithAssertions applied assertions to config, they are checked by Start once migrations and fixtures are applied
+// and any mismatch fails it, catching seed data which silently went missing
+func WithAssertions(assertions ...Assertion) Option {
+	return func(c *config) error {
+		for _, a := range assertions {
+			if a.Query == "" {
+				return errors.New("assertion query is required")
+			}
+			if a.Rows < 0 {
+				return fmt.Errorf("assertion %q expects a negative number of rows", a)
+			}
+		}
+		c.assertions = append(c.assertions, assertions...)
+		return nil
+	}
+}
+
+// WithSkipMigrations applied skip migrations option to config. Start does not apply migrations, but still
+// applies the fixtures, also to the existing cluster of a data directory, like for re-seeding a persistent
+// database. Migrate applies them later on.
+func WithSkipMigrations(skip bool) Option {
+	return func(c *config) error {
+		c.skipMigrations = skip
+		return nil
+	}
+}
+
+// WithSkipFixtures applied skip fixtures option to config. Start applies migrations without fixtures, Seed
+// applies them later on.
+func WithSkipFixtures(skip bool) Option {
+	return func(c *config) error {
+		c.skipFixtures = skip
+		return nil
+	}
+}
+
+// WithDumpOptions applied extra pg_dump flags used by Dump to config, like --schema-only or --data-only
+func WithDumpOptions(flags ...string) Option {
+	return func(c *config) error {
+		c.dumpOptions = append(c.dumpOptions, flags...)
+		return nil
+	}
+}
+
+// WithLogger applied selected logger to config, log messages and the container logs of a failed start are written
+// to it instead of the default logger
 func WithLogger(logger io.Writer) Option {
 	return func(c *config) error {
 		c.logger = logger
@@ -103,22 +617,462 @@ func WithLogger(logger io.Writer) Option {
 	}
 }
 
-// WithMigrations applied selected migrations to config
-func WithMigrations(path string) Option {
+// sslModes are the ssl modes supported by libpq
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// WithSSLMode applied selected sslmode of the database uri to config, default is disable
+func WithSSLMode(mode string) Option {
+	return func(c *config) error {
+		if !sslModes[mode] {
+			return fmt.Errorf("unsupported ssl mode %q", mode)
+		}
+		c.sslMode = mode
+		return nil
+	}
+}
+
+// WithSSLRootCert applied path of the root certificate used to verify the server to config,
+// it is only added to the database uri if ssl mode is not disable
+func WithSSLRootCert(path string) Option {
+	return func(c *config) error {
+		c.sslRootCert = path
+		return nil
+	}
+}
+
+// WithSSLCert applied path of the client certificate and its key to config,
+// they are only added to the database uri if ssl mode is not disable
+func WithSSLCert(certPath, keyPath string) Option {
+	return func(c *config) error {
+		c.sslCert = certPath
+		c.sslKey = keyPath
+		return nil
+	}
+}
+
+// WithIdleTimeout applied idle timeout to config, the database is stopped when it has no client
+// connections for the given duration, zero disables it. Without detach the check runs in the process calling Start,
+// so it only covers callers which keep running. A detached container checks its connections itself and is removed
+// once it stops, which can not be combined with a restart policy, a replica or a ui.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		if d < 0 {
+			return fmt.Errorf("idle timeout must not be negative, got %s", d)
+		}
+		c.idleTimeout = d
+		return nil
+	}
+}
+
+// WithObserver applied observer, which is notified about timings of startup, migrations, fixtures and database creation, to config
+func WithObserver(o Observer) Option {
+	return func(c *config) error {
+		c.observer = o
+		return nil
+	}
+}
+
+// WithDryRun applied dry run option to config, when enabled Start logs the resolved container
+// request and the ordered migration and fixture files, then returns without touching docker
+func WithDryRun(dryRun bool) Option {
+	return func(c *config) error {
+		c.dryRun = dryRun
+		return nil
+	}
+}
+
+// WithUnixSocket mounts the postgres unix socket directory into the given host directory and
+// makes URI connect through the socket instead of tcp, the directory will be created if it does not exist
+func WithUnixSocket(dir string) Option {
 	return func(c *config) error {
-		files, err := getFiles(path)
+		if dir == "" {
+			return nil
+		}
+
+		absPath, err := filepath.Abs(dir)
 		if err != nil {
-			return fmt.Errorf("read migraions failed: %w", err)
+			return fmt.Errorf("get unix socket directory absolute path failed: %w", err)
+		}
+
+		if err := os.MkdirAll(absPath, 0o777); err != nil {
+			return fmt.Errorf("create unix socket directory failed: %w", err)
+		}
+
+		// postgres runs as a different user inside the container and must be able to create the socket
+		if err := os.Chmod(absPath, 0o777); err != nil {
+			return fmt.Errorf("change unix socket directory permissions failed: %w", err)
+		}
+
+		c.unixSocketDir = absPath
+		return nil
+	}
+}
+
+// WithAppName applied application_name of the database uri to config, default is dbctl.
+// It makes connections opened using URI easy to identify in pg_stat_activity.
+func WithAppName(name string) Option {
+	return func(c *config) error {
+		if name == "" {
+			return nil
+		}
+		c.appName = name
+		return nil
+	}
+}
+
+// WithCleanOrphans applied clean orphans option to config, when enabled Start drops databases generated by
+// CreateDB more than an hour ago, e.g. left behind by crashed test runs. Templates are never dropped.
+func WithCleanOrphans(clean bool) Option {
+	return func(c *config) error {
+		c.cleanOrphans = clean
+		return nil
+	}
+}
+
+// WithSignalHandling applied signal handling to config, when enabled Start listens to SIGINT, SIGTERM and the other
+// exit signals of utils.DefaultExistSignals itself and shuts down on them like on a canceled context.
+// It is disabled by default, so processes embedding postgres keep their own signal handling.
+func WithSignalHandling(enabled bool) Option {
+	return func(c *config) error {
+		c.signalHandling = enabled
+		return nil
+	}
+}
+
+// WithKeepOnFailure applied keep on failure option to config. By default a Start failing once the container runs
+// removes it and a CreateDB failing on migrations or fixtures drops the new database, when enabled they are kept
+// and the connection uri and container id are logged for debugging.
+func WithKeepOnFailure(keep bool) Option {
+	return func(c *config) error {
+		c.keepOnFailure = keep
+		return nil
+	}
+}
+
+// WithAutoTemplate applied auto template option to config. By default the first CreateDB with migrations saves
+// the migrated database as a template named after the migration files, and later requests with the same
+// migrations clone it. When disabled, every CreateDB creates and migrates a database without any template.
+func WithAutoTemplate(enabled bool) Option {
+	return func(c *config) error {
+		c.autoTemplate = enabled
+		return nil
+	}
+}
+
+// WithResources applied container resource limits to config, memory in bytes and cpus as fraction of cpus
+// like 1.5, zero leaves a resource unlimited. Start fails if the docker daemon can't enforce the limits.
+func WithResources(memoryBytes int64, cpus float64) Option {
+	return func(c *config) error {
+		if memoryBytes < 0 {
+			return fmt.Errorf("memory limit must not be negative, got %d", memoryBytes)
+		}
+		if cpus < 0 {
+			return fmt.Errorf("cpu limit must not be negative, got %g", cpus)
+		}
+		c.memory, c.cpus = memoryBytes, cpus
+		return nil
+	}
+}
+
+// initScriptExts are the file extensions the entrypoint of the postgres image runs from its init directory
+var initScriptExts = []string{".sh", ".sql", ".sql.gz", ".sql.xz", ".sql.zst"}
+
+// WithInitScripts applied init scripts to config, files or directories of .sql (also compressed with gzip, xz or
+// zstd) or .sh files like a pg_dump schema. They are mounted into /docker-entrypoint-initdb.d, so the image
+// runs them in the given order while bootstrapping, before the database accepts connections. Like initdb they
+// only run on a fresh data directory, not on an existing one of WithDataDir.
+func WithInitScripts(paths ...string) Option {
+	return func(c *config) error {
+		for _, path := range paths {
+			files, err := GetFiles(path)
+			if err != nil {
+				return fmt.Errorf("read init scripts failed: %w", err)
+			}
+
+			for _, f := range files {
+				if !hasInitScriptExt(f) {
+					return fmt.Errorf("unsupported init script %s, use one of %s", f, strings.Join(initScriptExts, ", "))
+				}
+				abs, err := filepath.Abs(f)
+				if err != nil {
+					return fmt.Errorf("get init script absolute path failed: %w", err)
+				}
+				c.initScripts = append(c.initScripts, abs)
+			}
+		}
+		return nil
+	}
+}
+
+func hasInitScriptExt(file string) bool {
+	for _, ext := range initScriptExts {
+		if strings.HasSuffix(strings.ToLower(file), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTimezone applied time zone of the container to config, an IANA zone name like Europe/Berlin. It sets TZ
+// and PGTZ of the container, the timezone setting of the server and the options parameter of the uri, so
+// connections of any client use the zone too.
+func WithTimezone(tz string) Option {
+	return func(c *config) error {
+		if tz == "" || tz == "Local" {
+			return fmt.Errorf("invalid time zone %q, use a zone name like Europe/Berlin", tz)
+		}
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("invalid time zone %q: %w", tz, err)
+		}
+		c.timezone = tz
+		return nil
+	}
+}
+
+// WithRestartPolicy applied docker restart policy of the container to config, one of no, always, unless-stopped
+// or on-failure[:max-retries], like unless-stopped for a detached database surviving reboots. Stop still
+// removes the container, so it is not restarted afterwards.
+func WithRestartPolicy(policy string) Option {
+	return func(c *config) error {
+		if _, err := container.ParseRestartPolicy(policy); err != nil {
+			return err
+		}
+		c.restartPolicy = policy
+		return nil
+	}
+}
+
+// WithPullPolicy applied image pull policy to config, one of Always, IfNotPresent or Never. Always is the default
+// and picks up a newer image of the tag, IfNotPresent saves the pull of a cached image and Never fails Start
+// without a network call if the image is not present, like in an air-gapped CI.
+func WithPullPolicy(policy string) Option {
+	return func(c *config) error {
+		p, err := container.ParsePullPolicy(policy)
+		if err != nil {
+			return err
+		}
+		c.pullPolicy = p
+		return nil
+	}
+}
+
+// WithNetwork applied a user defined docker network to config, the container joins it instead of the default
+// bridge so other containers of the network, like an app under test, reach postgres by its container name,
+// see NetworkURI. The port is still published on the host. The network must exist, an empty name keeps
+// the default bridge network.
+func WithNetwork(name string) Option {
+	return func(c *config) error {
+		if name != "" && !containerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid network name %q", name)
+		}
+		c.network = name
+		return nil
+	}
+}
+
+// WithNetworkAlias applied network aliases to config, other containers of the network given to WithNetwork
+// can reach postgres by the aliases too, like db. NetworkURI uses the first alias as host.
+func WithNetworkAlias(aliases ...string) Option {
+	return func(c *config) error {
+		for _, alias := range aliases {
+			if !containerNamePattern.MatchString(alias) {
+				return fmt.Errorf("invalid network alias %q", alias)
+			}
+		}
+		c.networkAliases = append(c.networkAliases, aliases...)
+		return nil
+	}
+}
+
+// WithReuse applied reuse option to config, when enabled Start attaches to a running postgres container started
+// by dbctl with the same version, port, labels, credentials, database and server settings instead of starting a new one. Migrations and fixtures are
+// not applied again and Stop leaves the container running, it belongs to the process which started it.
+func WithReuse(reuse bool) Option {
+	return func(c *config) error {
+		c.reuse = reuse
+		return nil
+	}
+}
+
+// WithReadyCallback applied a callback to config, which is called once with the database uri
+// when the database is started and ready to use. It is useful in detached mode to coordinate startup.
+func WithReadyCallback(f func(uri string)) Option {
+	return func(c *config) error {
+		c.readyCallback = f
+		return nil
+	}
+}
+
+// WithStartRetries applied number of retries for transient container start failures to config,
+// like a port that is not released yet or a throttled image pull. Retries back off exponentially,
+// other failures are returned immediately.
+func WithStartRetries(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("start retries must not be negative, got %d", n)
+		}
+		c.startRetries = n
+		return nil
+	}
+}
+
+// WithInitdbArgs applied extra initdb arguments to config, like "--data-checksums --encoding=UTF8 --locale=C".
+// initdb only runs on an empty data directory, so it can not be combined with an already initialized data dir.
+func WithInitdbArgs(args string) Option {
+	return func(c *config) error {
+		c.initdbArgs = args
+		return nil
+	}
+}
+
+// WithExtensions applied postgres extensions, like uuid-ossp, pgcrypto or citext, to config.
+// They are created in the instance database and in every database CreateDB does not clone from it,
+// before migrations run, so migrations can rely on them. Extensions are created as the configured user,
+// which is the postgres superuser unless changed.
+func WithExtensions(names ...string) Option {
+	return func(c *config) error {
+		for _, name := range names {
+			if strings.TrimSpace(name) == "" {
+				return fmt.Errorf("extension name must not be empty")
+			}
+		}
+		c.extensions = append(c.extensions, names...)
+		return nil
+	}
+}
+
+// WithInitSQL applied inline statements to config, which are executed in order once the server is ready
+// and before migrations are applied, e.g. to create a role or set a GUC without a separate sql file
+func WithInitSQL(statements ...string) Option {
+	return func(c *config) error {
+		c.initSQL = append(c.initSQL, statements...)
+		return nil
+	}
+}
+
+// WithAppUser applied an unprivileged application role to config. On startup a role without superuser
+// and create database privileges is created along with a database named after it and owned by it,
+// AppURI returns its connection uri. Admin operations keep using the superuser of URI.
+func WithAppUser(user, pass string) Option {
+	return func(c *config) error {
+		if user == "" {
+			return fmt.Errorf("app user must not be empty")
 		}
+		c.appUser, c.appPass = user, pass
+		return nil
+	}
+}
 
-		for _, f := range files {
-			// ignore migration down files
-			if strings.HasSuffix(f, "down.sql") {
-				continue
+// WithNamePrefix applied container name prefix to config, default is dbctl_pg.
+// It can be used to namespace containers, e.g. per CI pipeline.
+func WithNamePrefix(prefix string) Option {
+	return func(c *config) error {
+		if !containerNamePattern.MatchString(prefix) {
+			return fmt.Errorf("invalid container name prefix %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", prefix)
+		}
+		c.namePrefix = prefix
+		return nil
+	}
+}
+
+// WithLabels applied user labels, which are added to the container, to config.
+// Instances can be filtered by them using InstancesWithLabels, and StopAll only stops instances having them.
+// Labels used by dbctl itself can't be set.
+func WithLabels(labels map[string]string) Option {
+	return func(c *config) error {
+		if c.labels == nil {
+			c.labels = make(map[string]string, len(labels))
+		}
+		for k, v := range labels {
+			if k == container.LabelManagedBy || strings.HasPrefix(k, "dbctl_") {
+				return fmt.Errorf("label %q is reserved by dbctl", k)
 			}
-			c.migrationsFiles = append(c.migrationsFiles, f)
+			c.labels[k] = v
+		}
+		return nil
+	}
+}
+
+// WithLogFormat applied selected log format (LogText or LogJSON) to config
+func WithLogFormat(format LogFormat) Option {
+	return func(c *config) error {
+		switch format {
+		case LogText, LogJSON:
+			c.logFormat = format
+			return nil
+		default:
+			return fmt.Errorf("unsupported log format %q", format)
 		}
+	}
+}
 
+// WithStrictTemplate applied strict template option to config. Saving the template databases which Start and
+// CreateDB clone later databases from is best effort and a failure is logged as warning, as databases are then
+// migrated from scratch. When strict, the failure is returned instead.
+func WithStrictTemplate(strict bool) Option {
+	return func(c *config) error {
+		c.strictTemplate = strict
+		return nil
+	}
+}
+
+// WithGoMigrations applied go migrations to config, they are applied together with the migration files of
+// WithMigrations and interleaved with them by version, see Migrator. A version can't be used by a migration file too.
+// Templates are rebuilt when the migrations change, a go migration is only known by its version unless it
+// implements ChecksumMigrator, so editing its code without a new checksum keeps using the stale template.
+func WithGoMigrations(migrations ...Migrator) Option {
+	return func(c *config) error {
+		for _, m := range migrations {
+			if m.Version() == "" {
+				return errors.New("go migration version is required")
+			}
+			if !hasUp(m) {
+				return fmt.Errorf("go migration %s has no UpFunc", m.Version())
+			}
+			for _, other := range c.goMigrations {
+				if sameVersion(other.Version(), m.Version()) {
+					return fmt.Errorf("duplicate go migration version %s", m.Version())
+				}
+			}
+			c.goMigrations = append(c.goMigrations, m)
+		}
+		return nil
+	}
+}
+
+// validateGoMigrations rejects go migrations with the version of a migration file, once all options are applied
+// so WithMigrations and WithGoMigrations can be given in any order. Both would run and record the same version.
+func (c *config) validateGoMigrations() error {
+	if len(c.goMigrations) == 0 || len(c.migrationsFiles) == 0 {
+		return nil
+	}
+
+	versions, err := migrationVersions(c.migrationsFiles)
+	if err != nil {
+		return err
+	}
+	for _, m := range c.goMigrations {
+		for _, v := range versions {
+			if sameVersion(v, m.Version()) {
+				return fmt.Errorf("go migration version %s is used by a migration file too", m.Version())
+			}
+		}
+	}
+	return nil
+}
+
+// WithMigrations applied selected migrations to config, paths can be files or directories.
+// Files of several paths are merged and ordered by their numeric prefix, a version used in more than one path is an error.
+func WithMigrations(paths ...string) Option {
+	return func(c *config) error {
+		files, err := CollectMigrations(paths)
+		if err != nil {
+			return fmt.Errorf("read migraions failed: %w", err)
+		}
+
+		c.migrationsFiles = append(c.migrationsFiles, files...)
 		return nil
 	}
 }
@@ -126,31 +1080,74 @@ func WithMigrations(path string) Option {
 // WithFixtures applied selected fixtures to config
 func WithFixtures(path string) Option {
 	return func(c *config) error {
-		files, err := getFiles(path)
+		files, err := GetFiles(path)
 		if err != nil {
 			return fmt.Errorf("read fixtures failed: %w", err)
 		}
-		c.fixtureFiles = files
+		c.fixtureFiles, c.fixturesPath = files, path
+		return nil
+	}
+}
+
+// commonFixtures is the fixtures subdirectory loaded before the one of the profile, see WithFixtureProfile
+const commonFixtures = "common"
+
+// WithFixtureProfile applied fixture profile to config. The fixtures path given to WithFixtures becomes a base
+// directory, of which only the files of the common subdirectory and then the ones of the subdirectory named
+// after the profile are applied, like fixtures/common and fixtures/ci. The common subdirectory is optional,
+// an empty name applies the fixtures path as is.
+func WithFixtureProfile(name string) Option {
+	return func(c *config) error {
+		if name == "." || name == ".." || name == commonFixtures || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid fixture profile %q", name)
+		}
+		c.fixtureProfile = name
+		return nil
+	}
+}
+
+// resolveFixtureProfile replaces the fixture files with the ones of the common and profile subdirectories of
+// the fixtures path, once all options are applied so WithFixtures and WithFixtureProfile can be given in any order
+func (c *config) resolveFixtureProfile() error {
+	if c.fixtureProfile == "" {
 		return nil
 	}
+	if c.fixturesPath == "" {
+		return fmt.Errorf("fixture profile %s requires a fixtures directory, see WithFixtures", c.fixtureProfile)
+	}
+
+	common, err := GetFiles(filepath.Join(c.fixturesPath, commonFixtures))
+	var notFound *PathNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("read common fixtures failed: %w", err)
+	}
+
+	profile, err := GetFiles(filepath.Join(c.fixturesPath, c.fixtureProfile))
+	if err != nil {
+		return fmt.Errorf("read fixtures of profile %s failed: %w", c.fixtureProfile, err)
+	}
+
+	c.fixtureFiles = append(common, profile...)
+	return nil
 }
 
-func getFiles(path string) ([]string, error) {
+// GetFiles returns the files of path sorted by name, path can be a single file or a directory
+func GetFiles(path string) ([]string, error) {
 	if len(path) == 0 {
 		return nil, nil
 	}
 
 	stat, err := os.Stat(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &PathNotFoundError{Path: path, Err: err}
+		}
 		return nil, fmt.Errorf("get path information failed, %w", err)
 	}
 
 	out := make([]string, 0)
 
 	if !stat.IsDir() {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return nil, fmt.Errorf("file %s not exit", path)
-		}
 		out = append(out, path)
 		return out, nil
 	}
@@ -172,7 +1169,7 @@ func getFiles(path string) ([]string, error) {
 	return out, nil
 }
 
-func getPostGisImage(version string) string {
+func getImage(version string) string {
 	if v, ok := supportedVersions[version]; ok {
 		return v
 	}
@@ -188,3 +1185,102 @@ func getHash(list []string) string {
 	cc := sha256.Sum256([]byte(xx))
 	return string(cc[:])
 }
+
+// ResolvedConfig is a snapshot of the configuration of a Postgres once all options are applied, for logging
+// and diagnostics. Passwords are left out, so it can be logged as is.
+type ResolvedConfig struct {
+	Image    string
+	Version  string
+	Host     string
+	Port     uint32
+	AutoPort bool
+	User     string
+	Database string
+	AppUser  string
+	SSLMode  string
+	Timezone string
+
+	// External is set when running against a postgres of WithExternalURI, no container is managed then
+	External bool
+	Label    string
+	Labels   map[string]string
+	Name     string
+	DataDir  string
+	UI       UIBackend
+
+	MigrationFiles []string
+	FixtureFiles   []string
+	Extensions     []string
+	SkipMigrations bool
+	SkipFixtures   bool
+
+	Network        string
+	NetworkAliases []string
+
+	StartupTimeout time.Duration
+	PullTimeout    time.Duration
+	StartRetries   int
+	FixtureWorkers int
+	MemoryBytes    int64
+	CPUs           float64
+
+	ReadReplica bool
+	ReplicaPort uint32
+
+	ShutdownTimeout time.Duration
+}
+
+// Config returns the resolved configuration. The port is the one docker picked once started with WithAutoPort,
+// and the image is empty if the image resolver fails.
+func (p *Postgres) Config() ResolvedConfig {
+	image, _ := p.cfg.image()
+
+	var labels map[string]string
+	if len(p.cfg.labels) > 0 {
+		labels = make(map[string]string, len(p.cfg.labels))
+		for k, v := range p.cfg.labels {
+			labels[k] = v
+		}
+	}
+
+	sslMode := p.cfg.sslMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	return ResolvedConfig{
+		Image:          image,
+		Version:        p.cfg.version,
+		Host:           p.host(),
+		Port:           p.cfg.port,
+		AutoPort:       p.cfg.autoPort,
+		User:           p.cfg.user,
+		Database:       p.cfg.name,
+		AppUser:        p.cfg.appUser,
+		SSLMode:        sslMode,
+		Timezone:       p.cfg.timezone,
+		External:       p.cfg.externalHost != "",
+		Label:          p.cfg.label,
+		Labels:         labels,
+		Name:           p.cfg.instanceName,
+		DataDir:        p.cfg.dataDir,
+		UI:             p.cfg.uiBackend,
+		MigrationFiles: append([]string(nil), p.cfg.migrationsFiles...),
+		FixtureFiles:   append([]string(nil), p.cfg.fixtureFiles...),
+		Extensions:     append([]string(nil), p.cfg.extensions...),
+		SkipMigrations: p.cfg.skipMigrations,
+		SkipFixtures:   p.cfg.skipFixtures,
+		Network:        p.cfg.network,
+		NetworkAliases: append([]string(nil), p.cfg.networkAliases...),
+		StartupTimeout: p.cfg.startupTimeout,
+		PullTimeout:    p.cfg.pullTimeout,
+		StartRetries:   p.cfg.startRetries,
+		FixtureWorkers: p.cfg.fixtureWorkers,
+		MemoryBytes:    p.cfg.memory,
+		CPUs:           p.cfg.cpus,
+		ReadReplica:    p.cfg.replica,
+		ReplicaPort:    p.cfg.replicaPort,
+
+		ShutdownTimeout: p.cfg.shutdownTimeout,
+	}
+}
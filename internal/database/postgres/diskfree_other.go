@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package pg
+
+// diskFree is not supported on this platform, Doctor skips the disk space check
+func diskFree(string) (uint64, error) {
+	return 0, errDiskSpaceUnsupported
+}
@@ -0,0 +1,140 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+// minFreeDiskSpace is the free space Doctor expects on the file systems of host directories mounted into
+// the container, postgres stops accepting writes once its disk is full
+const minFreeDiskSpace = 1 << 30
+
+// errDiskSpaceUnsupported is returned by diskFree on platforms it can't read the free disk space of
+var errDiskSpaceUnsupported = errors.New("reading free disk space is not supported on this platform")
+
+// CheckResult is the result of a single check of Doctor, Hint tells how to fix a failed check
+type CheckResult struct {
+	Name    string
+	OK      bool
+	Message string
+	Hint    string
+}
+
+// Doctor checks the environment Start depends on with the configuration of p: docker connectivity, the
+// availability of the port, the image being present or pullable and the free disk space of mounted host
+// directories. It does not start anything, failed checks come with a hint to fix them.
+// Checking the image may pull it.
+func (p *Postgres) Doctor(ctx context.Context) []CheckResult {
+	docker := checkDocker(ctx, p.runner)
+	results := []CheckResult{docker}
+
+	switch {
+	case p.cfg.externalHost != "":
+		results = append(results, CheckResult{Name: "port", OK: true, Message: "skipped, the server is external"})
+	case p.cfg.autoPort:
+		results = append(results, CheckResult{Name: "port", OK: true, Message: "skipped, docker picks a free port"})
+	default:
+		results = append(results, checkPort(p.cfg.port))
+	}
+
+	if p.cfg.externalHost != "" {
+		results = append(results, CheckResult{Name: "image", OK: true, Message: "skipped, the server is external"})
+	} else {
+		image, err := p.cfg.image()
+		switch {
+		case err != nil:
+			results = append(results, CheckResult{Name: "image", Message: err.Error(), Hint: "select a supported version with WithVersion or --version"})
+		case !docker.OK:
+			results = append(results, CheckResult{Name: "image", Message: fmt.Sprintf("can't check image %s, docker is not reachable", image), Hint: docker.Hint})
+		default:
+			results = append(results, checkImage(ctx, p.runner, image, p.cfg.pullPolicy))
+		}
+	}
+
+	var dirs []string
+	for _, dir := range []string{p.cfg.dataDir, p.cfg.walArchiveDir, p.cfg.unixSocketDir} {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return append(results, checkDiskSpace(dirs, diskFree))
+}
+
+func checkDocker(ctx context.Context, r runner) CheckResult {
+	if err := r.Ping(ctx); err != nil {
+		return CheckResult{Name: "docker", Message: err.Error(),
+			Hint: "start docker desktop or the docker daemon, or point DOCKER_HOST at a running one"}
+	}
+	return CheckResult{Name: "docker", OK: true, Message: "docker is reachable"}
+}
+
+// checkPort reports if the host port can be published, by listening on it like docker would
+func checkPort(port uint32) CheckResult {
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(port))))
+	if err != nil {
+		return CheckResult{Name: "port", Message: fmt.Sprintf("port %d is not available: %s", port, err),
+			Hint: "stop the process or database using the port, pick another one with WithHost or --port, or use WithAutoPort"}
+	}
+	_ = ln.Close()
+	return CheckResult{Name: "port", OK: true, Message: fmt.Sprintf("port %d is available", port)}
+}
+
+// checkImage makes sure image is present, pulling it only if it is missing unless the pull policy is Never
+func checkImage(ctx context.Context, r runner, image string, policy container.PullPolicy) CheckResult {
+	if policy != container.PullNever {
+		policy = container.PullIfNotPresent
+	}
+
+	if err := r.EnsureImage(ctx, image, policy); err != nil {
+		hint := "check the image name and your registry access, try docker pull " + image
+		if errors.Is(err, container.ErrImageNotPresent) {
+			hint = "pull the image with docker pull " + image + " or use another pull policy"
+		}
+		return CheckResult{Name: "image", Message: fmt.Sprintf("image %s is not available: %s", image, err), Hint: hint}
+	}
+	return CheckResult{Name: "image", OK: true, Message: fmt.Sprintf("image %s is available", image)}
+}
+
+// checkDiskSpace reports if the file systems of dirs have at least minFreeDiskSpace free, using free to read it
+func checkDiskSpace(dirs []string, free func(dir string) (uint64, error)) CheckResult {
+	if len(dirs) == 0 {
+		return CheckResult{Name: "disk", OK: true, Message: "skipped, no host directories are mounted"}
+	}
+
+	for _, dir := range dirs {
+		n, err := free(existingParent(dir))
+		if errors.Is(err, errDiskSpaceUnsupported) {
+			return CheckResult{Name: "disk", OK: true, Message: "skipped, " + err.Error()}
+		}
+		if err != nil {
+			return CheckResult{Name: "disk", Message: fmt.Sprintf("read free disk space of %s failed: %s", dir, err),
+				Hint: "make sure the directory is accessible"}
+		}
+		if n < minFreeDiskSpace {
+			return CheckResult{Name: "disk", Message: fmt.Sprintf("only %d MiB free for %s", n>>20, dir),
+				Hint: fmt.Sprintf("free at least %d MiB of disk space or use another directory", minFreeDiskSpace>>20)}
+		}
+	}
+	return CheckResult{Name: "disk", OK: true, Message: "enough free disk space for mounted directories"}
+}
+
+// existingParent returns dir or its closest existing parent, docker creates missing mounted directories
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+func TestCheckDocker(t *testing.T) {
+	if res := checkDocker(context.Background(), &fakeRunner{}); !res.OK {
+		t.Fatalf("expected reachable docker to pass, got %+v", res)
+	}
+
+	res := checkDocker(context.Background(), &fakeRunner{pingErr: errors.New("connect: no such file or directory")})
+	if res.OK || !strings.Contains(res.Message, "no such file") || res.Hint == "" {
+		t.Fatalf("expected unreachable docker to fail with a hint, got %+v", res)
+	}
+}
+
+func TestCheckPort(t *testing.T) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed %s", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	port := uint32(ln.Addr().(*net.TCPAddr).Port)
+
+	if res := checkPort(port); res.OK || res.Hint == "" {
+		t.Fatalf("expected taken port to fail with a hint, got %+v", res)
+	}
+
+	_ = ln.Close()
+	if res := checkPort(port); !res.OK {
+		t.Fatalf("expected free port to pass, got %+v", res)
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	fake := &fakeRunner{}
+	if res := checkImage(context.Background(), fake, "postgres:16", container.PullAlways); !res.OK {
+		t.Fatalf("expected present image to pass, got %+v", res)
+	}
+	if res := checkImage(context.Background(), fake, "postgres:16", container.PullNever); !res.OK {
+		t.Fatalf("expected present image to pass, got %+v", res)
+	}
+	// the image is only pulled if it is missing, and never with the Never policy
+	if want := []string{"postgres:16 IfNotPresent", "postgres:16 Never"}; !reflect.DeepEqual(fake.ensured, want) {
+		t.Fatalf("expected image ensured with %v, got %v", want, fake.ensured)
+	}
+
+	fake = &fakeRunner{imageErr: fmt.Errorf("%w: %q", container.ErrImageNotPresent, "postgres:16")}
+	res := checkImage(context.Background(), fake, "postgres:16", container.PullNever)
+	if res.OK || !strings.Contains(res.Hint, "docker pull postgres:16") {
+		t.Fatalf("expected missing image to fail with a pull hint, got %+v", res)
+	}
+
+	fake = &fakeRunner{imageErr: errors.New("pull access denied for postgres:99")}
+	if res := checkImage(context.Background(), fake, "postgres:99", ""); res.OK || !strings.Contains(res.Message, "access denied") {
+		t.Fatalf("expected unpullable image to fail, got %+v", res)
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	free := func(n uint64, err error) func(string) (uint64, error) {
+		return func(string) (uint64, error) { return n, err }
+	}
+
+	if res := checkDiskSpace(nil, free(0, nil)); !res.OK {
+		t.Fatalf("expected no mounted directories to pass, got %+v", res)
+	}
+	if res := checkDiskSpace([]string{dir}, free(10<<30, nil)); !res.OK {
+		t.Fatalf("expected enough disk space to pass, got %+v", res)
+	}
+	if res := checkDiskSpace([]string{dir}, free(100<<20, nil)); res.OK || !strings.Contains(res.Message, "100 MiB") || res.Hint == "" {
+		t.Fatalf("expected low disk space to fail with a hint, got %+v", res)
+	}
+	if res := checkDiskSpace([]string{dir}, free(0, errors.New("permission denied"))); res.OK {
+		t.Fatalf("expected unreadable disk space to fail, got %+v", res)
+	}
+	if res := checkDiskSpace([]string{dir}, free(0, errDiskSpaceUnsupported)); !res.OK {
+		t.Fatalf("expected unsupported platform to be skipped, got %+v", res)
+	}
+
+	// a data directory docker creates on start is checked on its parent
+	var checked string
+	checkDiskSpace([]string{filepath.Join(dir, "data", "pg")}, func(d string) (uint64, error) {
+		checked = d
+		return 10 << 30, nil
+	})
+	if checked != dir {
+		t.Fatalf("expected closest existing parent %s to be checked, got %s", dir, checked)
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	db, err := New(WithAutoPort(), WithDataDir(t.TempDir()), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = &fakeRunner{pingErr: errors.New("docker is down")}
+
+	results := db.Doctor(context.Background())
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if want := []string{"docker", "port", "image", "disk"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected checks %v, got %v", want, names)
+	}
+
+	if results[0].OK || !results[1].OK {
+		t.Fatalf("expected docker to fail and the auto port to be skipped, got %+v", results)
+	}
+	if results[2].OK || !strings.Contains(results[2].Message, "docker is not reachable") {
+		t.Fatalf("expected image check to fail without docker, got %+v", results[2])
+	}
+}
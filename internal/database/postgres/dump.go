@@ -0,0 +1,47 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes a sql dump of the database to w, by running pg_dump inside the container.
+// Extra pg_dump flags can be set using WithDumpOptions.
+func (p *Postgres) Dump(ctx context.Context, w io.Writer) error {
+	cmd := append([]string{"pg_dump", "--username", p.cfg.user, "--dbname", p.cfg.name}, p.cfg.dumpOptions...)
+	if err := p.exec(ctx, cmd, nil, w); err != nil {
+		return fmt.Errorf("dump database failed: %w", err)
+	}
+	return nil
+}
+
+// Restore reads a sql dump, like the one created by Dump, from r and applies it on the database using psql
+func (p *Postgres) Restore(ctx context.Context, r io.Reader) error {
+	cmd := []string{"psql", "--username", p.cfg.user, "--dbname", p.cfg.name, "--quiet", "--set", "ON_ERROR_STOP=1"}
+	if err := p.exec(ctx, cmd, r, io.Discard); err != nil {
+		return fmt.Errorf("restore database failed: %w", err)
+	}
+	return nil
+}
+
+// exec runs a command inside the postgres container and fails if it exits with a non-zero code
+func (p *Postgres) exec(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if p.containerID == "" {
+		return errors.New("postgres container is not running")
+	}
+
+	var stderr bytes.Buffer
+	code, err := p.runner.ExecStream(ctx, p.containerID, cmd, stdin, stdout, &stderr)
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		return fmt.Errorf("%s exited with code %d: %s", cmd[0], code, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
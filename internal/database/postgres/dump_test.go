@@ -0,0 +1,58 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	if err := applySQLStatement(ctx, db.URI(), "create table foo(id int, name varchar(20)); insert into foo values (1, 'foo'), (2, 'bar');"); err != nil {
+		t.Fatalf("seed database failed %s", err)
+	}
+
+	var dump bytes.Buffer
+	if err := db.Dump(ctx, &dump); err != nil {
+		t.Fatalf("Dump failed %s", err)
+	}
+
+	if !strings.Contains(dump.String(), "CREATE TABLE public.foo") {
+		t.Fatalf("expected table foo in the dump, got %q", dump.String())
+	}
+
+	if err := applySQLStatement(ctx, db.URI(), "drop table foo"); err != nil {
+		t.Fatalf("drop table failed %s", err)
+	}
+
+	if err := db.Restore(ctx, &dump); err != nil {
+		t.Fatalf("Restore failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), "foo"); n != 2 {
+		t.Fatalf("expected 2 rows after restore, got %d", n)
+	}
+}
+
+func TestDumpFailsOnNonZeroExit(t *testing.T) {
+	db, err := New(WithDumpOptions("--schema-only"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	fake := &fakeRunner{execCode: 1, execStderr: "pg_dump: error: connection failed"}
+	db.runner = fake
+	db.containerID = "fake"
+
+	err = db.Dump(context.Background(), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "connection failed") {
+		t.Fatalf("expected pg_dump error, got %v", err)
+	}
+
+	if len(fake.execs) != 1 || fake.execs[0][len(fake.execs[0])-1] != "--schema-only" {
+		t.Fatalf("expected pg_dump to be called with dump options, got %v", fake.execs)
+	}
+}
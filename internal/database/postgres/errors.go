@@ -0,0 +1,248 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUnsupportedVersion is matched by errors.Is when the requested postgres version is not supported
+	ErrUnsupportedVersion = errors.New("unsupported postgres version")
+	// ErrPathNotFound is matched by errors.Is when a migrations or fixtures path does not exist
+	ErrPathNotFound = errors.New("path not found")
+)
+
+// UnsupportedVersionError is returned by WithVersion when the requested version is not supported
+type UnsupportedVersionError struct {
+	Version   string
+	Supported []string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("selected postgres version (%s) is not supported, select one of: %s", e.Version, strings.Join(e.Supported, ","))
+}
+
+func (e *UnsupportedVersionError) Is(target error) bool {
+	return target == ErrUnsupportedVersion
+}
+
+// PathNotFoundError is returned by GetFiles when the given path does not exist
+type PathNotFoundError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathNotFoundError) Error() string {
+	return fmt.Sprintf("path %s not found: %s", e.Path, e.Err)
+}
+
+func (e *PathNotFoundError) Is(target error) bool {
+	return target == ErrPathNotFound
+}
+
+func (e *PathNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Phase is the step of Start a StartError happened in
+type Phase string
+
+const (
+	// PhaseContainer is creating and running the container
+	PhaseContainer Phase = "container"
+	// PhaseWait is waiting for the database to accept connections
+	PhaseWait Phase = "wait"
+	// PhaseSetup is creating extensions, the app user and running init statements
+	PhaseSetup Phase = "setup"
+	// PhaseMigrations is validating and applying migrations
+	PhaseMigrations Phase = "migrations"
+	// PhaseFixtures is applying fixtures
+	PhaseFixtures Phase = "fixtures"
+	// PhaseAssertions is checking the assertions of WithAssertions
+	PhaseAssertions Phase = "assertions"
+	// PhaseReplica is starting the read replica and waiting for it to stream
+	PhaseReplica Phase = "replica"
+	// PhaseUI is starting the ui container
+	PhaseUI Phase = "ui"
+)
+
+// StartError is returned by Start, it tells which phase failed so callers like a cli can pick an exit code
+type StartError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("start failed in %s phase: %s", e.Phase, e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// phaseError wraps err in a StartError of phase, it returns nil if err is nil
+func phaseError(phase Phase, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StartError{Phase: phase, Err: err}
+}
+
+// FixtureError is returned when applying fixture files fails. Applied are the files applied successfully, Failed
+// the ones which failed and Skipped the ones not applied as applying stopped at the first failure, or as the
+// context was cancelled while they were waiting or in flight, see WithContinueOnFixtureError. Files keep the order they were given in.
+type FixtureError struct {
+	Applied []string
+	Failed  []FixtureFailure
+	Skipped []string
+	// Cancelled is the context error if applying was cancelled
+	Cancelled error
+}
+
+// FixtureFailure is a fixture file which failed to apply and its error
+type FixtureFailure struct {
+	File string
+	Err  error
+}
+
+func (e *FixtureError) Error() string {
+	total := len(e.Applied) + len(e.Failed) + len(e.Skipped)
+	var b strings.Builder
+	fmt.Fprintf(&b, "applying fixtures failed, %d of %d files applied", len(e.Applied), total)
+	for _, f := range e.Failed {
+		// errors of fixture files name the file
+		fmt.Fprintf(&b, "; %s", f.Err)
+	}
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&b, "; skipped: %s", strings.Join(e.Skipped, ", "))
+	}
+	if e.Cancelled != nil {
+		fmt.Fprintf(&b, "; %s", e.Cancelled)
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed files and the context error, so errors.As finds a MigrationError
+// of a sql fixture and errors.Is finds context.Canceled
+func (e *FixtureError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed)+1)
+	for _, f := range e.Failed {
+		errs = append(errs, f.Err)
+	}
+	if e.Cancelled != nil {
+		errs = append(errs, e.Cancelled)
+	}
+	return errs
+}
+
+// MigrationError is returned when postgres rejects a statement of a migration or sql fixture file. File is the
+// file path or archive/entry for files inside an archive. StatementIndex is the zero based index of the failed
+// statement in the file and Line its line. They are taken from the error position postgres reports for parse
+// errors, runtime errors like constraint violations are located by running the statements of the file one at a
+// time in a transaction rolled back afterwards. If the statement is not found StatementIndex is -1 and Line is 0.
+// Err is the underlying *pq.Error.
+type MigrationError struct {
+	File           string
+	StatementIndex int
+	Line           int
+	Statement      string
+	Err            error
+}
+
+func (e *MigrationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("applying file (%s) failed at line %d: %s", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("applying file (%s) failed: %s", e.File, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// newMigrationError locates the statement of sql an error of postgres points at, the error position
+// counts characters from 1 in the whole sql sent to the server
+func newMigrationError(file, sql string, err error) *MigrationError {
+	merr := &MigrationError{File: file, StatementIndex: -1, Err: err}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Position == "" {
+		return merr
+	}
+	pos, convErr := strconv.Atoi(pqErr.Position)
+	if convErr != nil || pos < 1 {
+		return merr
+	}
+
+	// convert the character position to a byte offset
+	offset, chars := len(sql), 0
+	for i := range sql {
+		if chars == pos-1 {
+			offset = i
+			break
+		}
+		chars++
+	}
+
+	merr.Line = strings.Count(sql[:offset], "\n") + 1
+	for i, r := range statementRanges(sql) {
+		// the position may point at the end of a statement, like for a missing closing parenthesis
+		if offset <= r[1] {
+			merr.StatementIndex, merr.Statement = i, sql[r[0]:r[1]]
+			break
+		}
+	}
+	return merr
+}
+
+// locateStatement finds the failed statement of merr when postgres reports no error position, by running the
+// statements of sql one at a time in a transaction which is rolled back. Only a statement failing with the same
+// error code is taken, and files controlling transactions themselves are not run again.
+func locateStatement(ctx context.Context, conn execer, sql string, merr *MigrationError) {
+	var pqErr *pq.Error
+	b, ok := conn.(txBeginner)
+	if merr.StatementIndex >= 0 || !ok || !errors.As(merr.Err, &pqErr) {
+		return
+	}
+
+	ranges := statementRanges(sql)
+	for _, r := range ranges {
+		if isTransactionControl(sql[r[0]:r[1]]) {
+			return
+		}
+	}
+
+	tx, err := b.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for i, r := range ranges {
+		if _, err := tx.ExecContext(ctx, sql[r[0]:r[1]]); err != nil {
+			var stmtErr *pq.Error
+			if errors.As(err, &stmtErr) && stmtErr.Code == pqErr.Code {
+				merr.StatementIndex, merr.Statement = i, sql[r[0]:r[1]]
+				merr.Line = strings.Count(sql[:r[0]], "\n") + 1
+			}
+			return
+		}
+	}
+}
+
+// isTransactionControl reports whether stmt begins, commits or rolls back a transaction
+func isTransactionControl(stmt string) bool {
+	word := strings.ToLower(strings.SplitN(strings.TrimSpace(stmt), " ", 2)[0])
+	switch word {
+	case "begin", "start", "commit", "end", "rollback", "abort":
+		return true
+	}
+	return false
+}
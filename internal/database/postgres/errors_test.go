@@ -0,0 +1,173 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func assertPhase(t *testing.T, err error, phase Phase) {
+	t.Helper()
+
+	var startErr *StartError
+	if !errors.As(err, &startErr) {
+		t.Fatalf("expected StartError in %s phase, got %v", phase, err)
+	}
+	if startErr.Phase != phase {
+		t.Fatalf("expected %s phase, got %s (%v)", phase, startErr.Phase, startErr.Err)
+	}
+}
+
+func TestStartErrorPhases(t *testing.T) {
+	newDB := func(runner *fakeRunner, options ...Option) *Postgres {
+		t.Helper()
+		opts := append([]Option{
+			WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())),
+			WithLogger(io.Discard),
+			WithStartupTimeout(50 * time.Millisecond),
+			WithPollInterval(10 * time.Millisecond),
+		}, options...)
+		db, err := New(opts...)
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		db.runner = runner
+		return db
+	}
+
+	t.Run("container", func(t *testing.T) {
+		db := newDB(&fakeRunner{runErr: errors.New("no such image")})
+		assertPhase(t, db.Start(context.Background(), true), PhaseContainer)
+
+		db = newDB(&fakeRunner{pingErr: container.ErrDaemonUnreachable})
+		err := db.Start(context.Background(), true)
+		assertPhase(t, err, PhaseContainer)
+		if !errors.Is(err, container.ErrDaemonUnreachable) {
+			t.Fatalf("expected wrapped daemon error, got %v", err)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		// the fake container never accepts connections
+		db := newDB(&fakeRunner{})
+		assertPhase(t, db.Start(context.Background(), true), PhaseWait)
+	})
+
+	t.Run("migrations", func(t *testing.T) {
+		migrations := writeSQLFiles(t, map[string]string{"0001_users.up.sql": "create table users(name text default 'oops);"})
+		db := newDB(&fakeRunner{}, WithMigrations(migrations))
+		assertPhase(t, db.Start(context.Background(), true), PhaseMigrations)
+	})
+}
+
+func TestStartErrorSetupPhases(t *testing.T) {
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	migrations := writeSQLFiles(t, map[string]string{"0001_users.up.sql": "create table users(id int);"})
+	cases := map[Phase][]Option{
+		PhaseSetup:      {WithExtensions("not_an_extension")},
+		PhaseMigrations: {WithMigrations(writeSQLFiles(t, map[string]string{"0001_bad.up.sql": "select * from missing_table;"}))},
+		PhaseFixtures:   {WithMigrations(migrations), WithFixtures(writeSQLFiles(t, map[string]string{"users.sql": "insert into users values ('not a number');"}))},
+	}
+
+	for phase, options := range cases {
+		opts := append([]Option{
+			WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())),
+			WithLogger(io.Discard),
+		}, options...)
+		db, err := New(opts...)
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+
+		err = db.Start(context.Background(), true)
+		_ = db.Stop(context.Background())
+		assertPhase(t, err, phase)
+	}
+}
+
+func TestNewMigrationError(t *testing.T) {
+	sql := "create table foo(id int);\n-- naïve comment\ncreate table bar(id int);\ninsert into bar valuez (1);\n"
+	pos := strings.Index(sql, "valuez")
+	// positions count characters, the comment has a two byte character
+	pqErr := &pq.Error{Code: "42601", Message: `syntax error at or near "valuez"`, Position: strconv.Itoa(len([]rune(sql[:pos])) + 1)}
+
+	err := newMigrationError("001_init.sql", sql, pqErr)
+	if err.StatementIndex != 2 || err.Line != 4 || err.Statement != "insert into bar valuez (1)" {
+		t.Fatalf("unexpected migration error %+v", err)
+	}
+	if !errors.Is(err, pqErr) || !strings.Contains(err.Error(), "001_init.sql") || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	err = newMigrationError("001_init.sql", sql, &pq.Error{Code: "23505"})
+	if err.StatementIndex != -1 || err.Line != 0 {
+		t.Fatalf("expected unknown statement without position, got %+v", err)
+	}
+}
+
+func TestRunMigrationsMigrationError(t *testing.T) {
+	db := startTestPostgres(t)
+
+	migrations := writeSQLFiles(t, map[string]string{
+		"001_init.sql": "create table foo(id int);\n\ncreate table bar(\n  id int,\n  foo_id int refrences foo(id)\n);\n",
+	})
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("GetFiles failed %s", err)
+	}
+
+	err = RunMigrations(context.Background(), nil, files, db.URI())
+	var merr *MigrationError
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected a MigrationError, got %v", err)
+	}
+
+	var pqErr *pq.Error
+	if merr.StatementIndex != 1 || merr.Line != 5 || filepath.Base(merr.File) != "001_init.sql" || !errors.As(err, &pqErr) {
+		t.Fatalf("unexpected migration error %+v", merr)
+	}
+}
+
+func TestRunMigrationsConstraintViolation(t *testing.T) {
+	db := startTestPostgres(t)
+
+	// postgres reports no position for runtime errors, the statement is found by running them one by one
+	migrations := writeSQLFiles(t, map[string]string{
+		"001_init.sql": "create table foo(id int primary key);\n\ninsert into foo values (1), (1);\ncreate table bar(id int);\n",
+	})
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("GetFiles failed %s", err)
+	}
+
+	err = RunMigrations(context.Background(), nil, files, db.URI())
+	var merr *MigrationError
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected a MigrationError, got %v", err)
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+	if merr.StatementIndex != 1 || merr.Line != 3 || merr.Statement != "insert into foo values (1), (1)" {
+		t.Fatalf("unexpected migration error %+v", merr)
+	}
+
+	// the statements run to locate the error are rolled back
+	if n := countRows(t, db.URI(), "pg_tables where tablename = 'foo'"); n != 0 {
+		t.Fatalf("expected foo not to be created, got %d tables", n)
+	}
+}
@@ -0,0 +1,247 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// fixtureLoader loads a single fixture file into the database
+type fixtureLoader func(ctx context.Context, conn *sql.DB, file string) error
+
+// fixtureLoaders maps fixture file extensions to their loader,
+// files with any other extension are executed as plain sql
+var fixtureLoaders = map[string]fixtureLoader{
+	".csv":  copyCSV,
+	".json": loadDeclarative,
+	".yaml": loadDeclarative,
+	".yml":  loadDeclarative,
+}
+
+// applyFixtureFiles applies fixture files in the given order, picking the loader by file extension. The first
+// failure stops applying the remaining files unless continueOnError is set, a failure is returned as FixtureError.
+func applyFixtureFiles(ctx context.Context, conn *sql.DB, files []string, uri string, continueOnError bool) error {
+	if conn == nil {
+		var err error
+		conn, err = dbConnect(ctx, uri)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+	}
+
+	results := make([]fixtureResult, len(files))
+	for i, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		err := applyFixtureFile(ctx, conn, f, uri)
+		if err != nil && ctx.Err() != nil {
+			// cancelled while applying, the file is reported as skipped
+			break
+		}
+		results[i] = fixtureResult{done: true, err: err}
+		if err != nil && !continueOnError {
+			break
+		}
+	}
+	return fixtureError(files, results, ctx.Err())
+}
+
+// applyFixtureFilesParallel applies fixture files concurrently using a pool of workers,
+// each file is applied on its own connection. Files are picked up in the given order but
+// may finish in any order, so they must not depend on each other.
+// The first error stops picking up remaining files unless continueOnError is set.
+func applyFixtureFilesParallel(ctx context.Context, files []string, uri string, workers int, continueOnError bool) error {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	conn.SetMaxOpenConns(workers)
+
+	results := fixtureWorkers(ctx, len(files), workers, continueOnError, func(ctx context.Context, i int) error {
+		return applyFixtureFile(ctx, conn, files[i], uri)
+	})
+	return fixtureError(files, results, ctx.Err())
+}
+
+// fixtureWorkers calls apply for indexes 0 to n-1 using a pool of workers and returns their results, the first
+// error cancels the context of the files in flight unless continueOnError is set. A file failing once the context
+// is cancelled, by the parent or by the failure of another file, is left not done so it's reported as skipped.
+func fixtureWorkers(ctx context.Context, n, workers int, continueOnError bool, apply func(ctx context.Context, i int) error) []fixtureResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// every file is applied by a single worker, so results need no locking
+	results := make([]fixtureResult, n)
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := apply(ctx, i)
+				if err != nil && ctx.Err() != nil {
+					continue
+				}
+				results[i] = fixtureResult{done: true, err: err}
+				if err != nil && !continueOnError {
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// fixtureResult is the outcome of applying a fixture file, done is false for files not applied
+type fixtureResult struct {
+	done bool
+	err  error
+}
+
+// fixtureError returns a FixtureError listing the outcome of every file if any file failed or was not
+// applied, nil otherwise. cancelled is the error of the context the files were applied with.
+func fixtureError(files []string, results []fixtureResult, cancelled error) error {
+	ferr := FixtureError{Cancelled: cancelled}
+	for i, res := range results {
+		switch {
+		case !res.done:
+			ferr.Skipped = append(ferr.Skipped, files[i])
+		case res.err != nil:
+			ferr.Failed = append(ferr.Failed, FixtureFailure{File: files[i], Err: res.err})
+		default:
+			ferr.Applied = append(ferr.Applied, files[i])
+		}
+	}
+
+	if len(ferr.Failed) == 0 && len(ferr.Skipped) == 0 {
+		return nil
+	}
+	return &ferr
+}
+
+// applyFixtureFile applies a single fixture file, picking the loader by file extension
+func applyFixtureFile(ctx context.Context, conn *sql.DB, file string, uri string) error {
+	load, ok := fixtureLoaders[strings.ToLower(filepath.Ext(file))]
+	if !ok {
+		return applySQL(ctx, conn, []string{file}, uri)
+	}
+
+	if err := load(ctx, conn, file); err != nil {
+		return fmt.Errorf("applying file (%s) failed: %w", file, err)
+	}
+	return nil
+}
+
+// copyCSV loads a csv file into the table named after the file using COPY.
+// The first line must be a header with column names, empty values are loaded as NULL.
+// The file name can be schema qualified (public.users.csv) and can have an ordering prefix (01_users.csv).
+func copyCSV(ctx context.Context, conn *sql.DB, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := csv.NewReader(f)
+	columns, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("read csv header failed: %w", err)
+	}
+
+	schema, table := tableFromFileName(file)
+
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = txn.Rollback()
+	}()
+
+	stmt, err := txn.PrepareContext(ctx, pq.CopyInSchema(schema, table, columns...))
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read csv record failed: %w", err)
+		}
+
+		for i, v := range record {
+			if v == "" {
+				values[i] = nil
+			} else {
+				values[i] = v
+			}
+		}
+
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	// flush the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// tableFromFileName returns the schema and table a fixture file is meant for,
+// the ordering prefix of the file name (like 01_) is ignored
+func tableFromFileName(file string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	if i := strings.IndexByte(name, '_'); i > 0 && strings.Trim(name[:i], "0123456789") == "" {
+		name = name[i+1:]
+	}
+
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return schema, table
+	}
+	return "public", name
+}
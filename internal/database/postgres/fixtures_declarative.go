@@ -0,0 +1,165 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+// loadDeclarative loads a yaml or json fixture file, mapping table names to a list of rows:
+//
+//	users:
+//	  - id: 1
+//	    name: alice
+//	    manager_id: null
+//
+// Tables and columns are inserted in the order they appear in the file.
+// Numbers, strings, bools and nulls keep their type. Column types are read from information_schema,
+// lists are inserted as arrays into array columns and other nested values are inserted as json,
+// strings are passed as is so a json column can also be given a json document as string.
+func loadDeclarative(ctx context.Context, conn *sql.DB, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// json is a subset of yaml, so both formats are parsed the same way
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse fixture failed: %w", err)
+	}
+
+	// empty file
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("fixture must be a mapping of table names to rows, line %d", root.Line)
+	}
+
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = txn.Rollback()
+	}()
+
+	for i := 0; i < len(root.Content); i += 2 {
+		table, rows := root.Content[i].Value, root.Content[i+1]
+		if rows.Kind != yaml.SequenceNode {
+			return fmt.Errorf("rows of table %s must be a list, line %d", table, rows.Line)
+		}
+
+		types, err := columnTypes(ctx, txn, table)
+		if err != nil {
+			return fmt.Errorf("read columns of %s failed: %w", table, err)
+		}
+
+		for _, row := range rows.Content {
+			columns, values, err := decodeRow(row, types)
+			if err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+
+			if _, err := txn.ExecContext(ctx, insertStatement(table, columns), values...); err != nil {
+				return fmt.Errorf("insert into %s failed, line %d: %w", table, row.Line, err)
+			}
+		}
+	}
+
+	return txn.Commit()
+}
+
+// columnTypes returns the data types of the columns of table by column name, array columns have the type ARRAY.
+// A table that can't be found has no types, so its values are inserted like for an untyped table.
+func columnTypes(ctx context.Context, txn *sql.Tx, table string) (map[string]string, error) {
+	schema, name := "", table
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schema, name = table[:i], table[i+1:]
+	}
+
+	rows, err := txn.QueryContext(ctx, `select column_name, data_type from information_schema.columns
+		where table_name = $1 and table_schema = coalesce(nullif($2, ''), current_schema())`, name, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, err
+		}
+		types[column] = dataType
+	}
+	return types, rows.Err()
+}
+
+// decodeRow returns the column names and values of a row mapping node, types are the column data types of the table
+func decodeRow(row *yaml.Node, types map[string]string) ([]string, []interface{}, error) {
+	if row.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("row must be a mapping of columns to values, line %d", row.Line)
+	}
+
+	columns := make([]string, 0, len(row.Content)/2)
+	values := make([]interface{}, 0, len(row.Content)/2)
+	for i := 0; i < len(row.Content); i += 2 {
+		var v interface{}
+		if err := row.Content[i+1].Decode(&v); err != nil {
+			return nil, nil, fmt.Errorf("decode value of %s failed, line %d: %w", row.Content[i].Value, row.Content[i].Line, err)
+		}
+
+		column := row.Content[i].Value
+		switch vv := v.(type) {
+		case []interface{}:
+			if types[column] == "ARRAY" {
+				v = pq.Array(vv)
+				break
+			}
+			b, err := json.Marshal(vv)
+			if err != nil {
+				return nil, nil, err
+			}
+			v = string(b)
+		case map[string]interface{}:
+			b, err := json.Marshal(vv)
+			if err != nil {
+				return nil, nil, err
+			}
+			v = string(b)
+		}
+
+		columns = append(columns, column)
+		values = append(values, v)
+	}
+	return columns, values, nil
+}
+
+// insertStatement builds a parameterized insert statement, table can be schema qualified
+func insertStatement(table string, columns []string) string {
+	parts := strings.Split(table, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+
+	quoted := make([]string, len(columns))
+	params := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pq.QuoteIdentifier(c)
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	return fmt.Sprintf("insert into %s (%s) values (%s)", strings.Join(parts, "."), strings.Join(quoted, ", "), strings.Join(params, ", "))
+}
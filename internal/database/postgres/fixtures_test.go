@@ -0,0 +1,411 @@
+package pg
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTableFromFileName(t *testing.T) {
+	cases := []struct {
+		file   string
+		schema string
+		table  string
+	}{
+		{file: "/fixtures/users.csv", schema: "public", table: "users"},
+		{file: "/fixtures/01_users.csv", schema: "public", table: "users"},
+		{file: "/fixtures/billing.invoices.csv", schema: "billing", table: "invoices"},
+		{file: "/fixtures/002_billing.invoices.csv", schema: "billing", table: "invoices"},
+		{file: "/fixtures/user_roles.csv", schema: "public", table: "user_roles"},
+	}
+
+	for _, c := range cases {
+		schema, table := tableFromFileName(c.file)
+		if schema != c.schema || table != c.table {
+			t.Fatalf("%s: expected %s.%s, got %s.%s", c.file, c.schema, c.table, schema, table)
+		}
+	}
+}
+
+func TestApplyFixturesMixedSQLAndCSV(t *testing.T) {
+	db := startTestPostgres(t)
+
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_schema.sql": "create table foo(id int, name varchar(20));",
+		"01_foo.csv":    "id,name\n1,foo\n2,bar\n3,\n",
+	})
+
+	files, err := GetFiles(fixtures)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	if err := ApplyFixtures(context.Background(), nil, files, db.URI()); err != nil {
+		t.Fatalf("ApplyFixtures failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), "foo"); n != 3 {
+		t.Fatalf("expected 3 rows in foo, got %d", n)
+	}
+
+	if n := countRows(t, db.URI(), "foo where name is null"); n != 1 {
+		t.Fatalf("expected empty csv value to be loaded as null, got %d null rows", n)
+	}
+}
+
+func TestDecodeRowTypes(t *testing.T) {
+	var doc yaml.Node
+	src := "id: 7\nname: alice\nactive: true\nscore: 1.5\nmanager_id: null\ntags: {role: admin}\n"
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatalf("unmarshal failed %s", err)
+	}
+
+	columns, values, err := decodeRow(doc.Content[0], nil)
+	if err != nil {
+		t.Fatalf("decodeRow failed %s", err)
+	}
+
+	expectedColumns := []string{"id", "name", "active", "score", "manager_id", "tags"}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Fatalf("expected columns %v, got %v", expectedColumns, columns)
+	}
+
+	expectedValues := []interface{}{7, "alice", true, 1.5, nil, `{"role":"admin"}`}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Fatalf("expected values %#v, got %#v", expectedValues, values)
+	}
+}
+
+func TestInsertStatement(t *testing.T) {
+	got := insertStatement("billing.invoices", []string{"id", "total"})
+	expected := `insert into "billing"."invoices" ("id", "total") values ($1, $2)`
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestApplyDeclarativeFixtures(t *testing.T) {
+	db := startTestPostgres(t)
+
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_schema.sql": "create table users(id int, name text, active bool, score numeric, manager_id int);",
+		"01_users.yaml": `
+users:
+  - id: 1
+    name: alice
+    active: true
+    score: 9.5
+    manager_id: null
+  - id: 2
+    name: bob
+    active: false
+    score: 7
+    manager_id: 1
+`,
+		"02_users.json": `{"users": [{"id": 3, "name": "carol", "active": true, "score": 8, "manager_id": 1}]}`,
+	})
+
+	files, err := GetFiles(fixtures)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	if err := ApplyFixtures(context.Background(), nil, files, db.URI()); err != nil {
+		t.Fatalf("ApplyFixtures failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), "users"); n != 3 {
+		t.Fatalf("expected 3 users, got %d", n)
+	}
+
+	if n := countRows(t, db.URI(), "users where manager_id is null and active and score = 9.5"); n != 1 {
+		t.Fatalf("expected alice to keep her types and null manager, got %d matching rows", n)
+	}
+}
+
+func TestDecodeRowArrayColumns(t *testing.T) {
+	var doc yaml.Node
+	src := "tags: [a, b]\nitems: [1, 2]\n"
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatalf("unmarshal failed %s", err)
+	}
+
+	_, values, err := decodeRow(doc.Content[0], map[string]string{"tags": "ARRAY", "items": "jsonb"})
+	if err != nil {
+		t.Fatalf("decodeRow failed %s", err)
+	}
+
+	if _, ok := values[0].(driver.Valuer); !ok {
+		t.Fatalf("expected list of an array column to be a pq array, got %#v", values[0])
+	}
+	if values[1] != "[1,2]" {
+		t.Fatalf("expected list of a jsonb column to be json, got %#v", values[1])
+	}
+}
+
+func TestApplyDeclarativeFixturesJSONAndArrays(t *testing.T) {
+	db := startTestPostgres(t)
+
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_schema.sql": "create table docs(id int, data jsonb, tags text[]);",
+		"01_docs.yaml": `
+docs:
+  - id: 1
+    data: {name: alice, roles: [admin, dev]}
+    tags: [a, "b c"]
+`,
+	})
+
+	files, err := GetFiles(fixtures)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	if err := ApplyFixtures(context.Background(), nil, files, db.URI()); err != nil {
+		t.Fatalf("ApplyFixtures failed %s", err)
+	}
+
+	conn, err := dbConnect(context.Background(), db.URI())
+	if err != nil {
+		t.Fatalf("connect to database failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var role string
+	var tags []string
+	if err := conn.QueryRow("select data->'roles'->>0, tags from docs where id = 1").Scan(&role, pq.Array(&tags)); err != nil {
+		t.Fatalf("read docs failed %s", err)
+	}
+
+	if role != "admin" {
+		t.Fatalf("expected jsonb role admin, got %q", role)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b c"}) {
+		t.Fatalf("expected tags [a b c], got %q", tags)
+	}
+}
+
+func TestParallelFixturesMatchSequential(t *testing.T) {
+	db := startTestPostgres(t, WithParallelFixtures(4))
+	ctx := context.Background()
+
+	const tables = 8
+	migrations, fixtures := map[string]string{}, map[string]string{}
+	for i := 0; i < tables; i++ {
+		migrations[fmt.Sprintf("%04d.up.sql", i)] = fmt.Sprintf("create table t%d(id int);", i)
+		fixtures[fmt.Sprintf("t%d.sql", i)] = fmt.Sprintf("insert into t%d select generate_series(1, %d);", i, (i+1)*10)
+	}
+	migrationsDir, fixturesDir := writeSQLFiles(t, migrations), writeSQLFiles(t, fixtures)
+
+	parallel, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrationsDir, Fixtures: fixturesDir})
+	if err != nil {
+		t.Fatalf("CreateDB with parallel fixtures failed %s", err)
+	}
+
+	sequential, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrationsDir})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	files, err := GetFiles(fixturesDir)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	if err := ApplyFixtures(ctx, nil, files, sequential.URI); err != nil {
+		t.Fatalf("ApplyFixtures failed %s", err)
+	}
+
+	for i := 0; i < tables; i++ {
+		table := fmt.Sprintf("t%d", i)
+		if p, s := countRows(t, parallel.URI, table), countRows(t, sequential.URI, table); p != s {
+			t.Fatalf("expected %s to have %d rows like sequential fixtures, got %d", table, s, p)
+		}
+	}
+}
+
+func TestFixtureError(t *testing.T) {
+	files := []string{"01_a.sql", "02_b.sql", "03_c.sql"}
+	errB := errors.New("applying file (02_b.sql) failed: syntax error")
+
+	if err := fixtureError(files, make([]fixtureResult, 0), nil); err != nil {
+		t.Fatalf("expected no error without files, got %s", err)
+	}
+
+	err := fixtureError(files, []fixtureResult{{done: true}, {done: true, err: errB}, {}}, nil)
+	var ferr *FixtureError
+	if !errors.As(err, &ferr) || !errors.Is(err, errB) {
+		t.Fatalf("expected a FixtureError wrapping the file error, got %v", err)
+	}
+	if !reflect.DeepEqual(ferr.Applied, []string{"01_a.sql"}) || len(ferr.Failed) != 1 || ferr.Failed[0].File != "02_b.sql" ||
+		!reflect.DeepEqual(ferr.Skipped, []string{"03_c.sql"}) {
+		t.Fatalf("unexpected outcome %+v", ferr)
+	}
+
+	expected := "applying fixtures failed, 1 of 3 files applied; applying file (02_b.sql) failed: syntax error; skipped: 03_c.sql"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+
+	err = fixtureError(files, []fixtureResult{{done: true}, {}, {}}, context.Canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled FixtureError, got %v", err)
+	}
+}
+
+func TestFixtureWorkersCancelled(t *testing.T) {
+	failed := errors.New("syntax error")
+
+	// file 0 fails while the others are in flight, they fail by the cancelled context and are not done
+	started := make(chan struct{}, 3)
+	results := fixtureWorkers(context.Background(), 4, 4, false, func(ctx context.Context, i int) error {
+		if i == 0 {
+			for n := 0; n < 3; n++ {
+				<-started
+			}
+			return failed
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(results[0].err, failed) {
+		t.Fatalf("expected file 0 to fail, got %+v", results[0])
+	}
+	for i, res := range results[1:] {
+		if res.done {
+			t.Fatalf("expected file %d in flight to be skipped, got %+v", i+1, res)
+		}
+	}
+
+	// a cancelled parent skips the files in flight too
+	ctx, cancel := context.WithCancel(context.Background())
+	results = fixtureWorkers(ctx, 2, 2, true, func(ctx context.Context, i int) error {
+		if i == 0 {
+			return nil
+		}
+		cancel()
+		return ctx.Err()
+	})
+	if !results[0].done || results[0].err != nil || results[1].done {
+		t.Fatalf("expected file 0 applied and file 1 skipped, got %+v", results)
+	}
+}
+
+func TestApplyFixturesPartialFailure(t *testing.T) {
+	fixtures := writeSQLFiles(t, map[string]string{
+		"01_a.sql": "insert into foo values (1);",
+		"02_b.sql": "insert into missing values (2);",
+		"03_c.sql": "insert into foo values (3);",
+	})
+	files, err := GetFiles(fixtures)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	cases := []struct {
+		name    string
+		options []Option
+		rows    int
+		skipped int
+	}{
+		{name: "stop", rows: 1, skipped: 1},
+		{name: "continue", options: []Option{WithContinueOnFixtureError(true)}, rows: 2},
+		{name: "parallel continue", options: []Option{WithContinueOnFixtureError(true), WithParallelFixtures(2)}, rows: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := startTestPostgres(t, c.options...)
+			if err := applySQLStatement(context.Background(), db.URI(), "create table foo(id int)"); err != nil {
+				t.Fatalf("create table failed %s", err)
+			}
+
+			err := db.applyFixtures(context.Background(), files, db.URI())
+			var ferr *FixtureError
+			if !errors.As(err, &ferr) {
+				t.Fatalf("expected a FixtureError, got %v", err)
+			}
+			if len(ferr.Failed) != 1 || filepath.Base(ferr.Failed[0].File) != "02_b.sql" {
+				t.Fatalf("expected 02_b.sql to fail, got %+v", ferr.Failed)
+			}
+			if len(ferr.Applied) != c.rows || len(ferr.Skipped) != c.skipped {
+				t.Fatalf("expected %d applied and %d skipped files, got %+v", c.rows, c.skipped, ferr)
+			}
+
+			var merr *MigrationError
+			if !errors.As(err, &merr) {
+				t.Fatalf("expected the MigrationError of the failed file, got %v", err)
+			}
+			if n := countRows(t, db.URI(), "foo"); n != c.rows {
+				t.Fatalf("expected %d rows in foo, got %d", c.rows, n)
+			}
+		})
+	}
+}
+
+// writeFixtureProfiles writes fixtures/common, fixtures/ci and fixtures/dev and returns the base directory
+func writeFixtureProfiles(t *testing.T) string {
+	t.Helper()
+
+	base := t.TempDir()
+	dirs := map[string]map[string]string{
+		commonFixtures: {"00_schema.sql": "create table foo(id int, profile text);"},
+		"ci":           {"01_foo.sql": "insert into foo values (1, 'ci'), (2, 'ci');"},
+		"dev":          {"01_foo.sql": "insert into foo select i, 'dev' from generate_series(1, 100) i;"},
+	}
+	for dir, files := range dirs {
+		if err := os.Rename(writeSQLFiles(t, files), filepath.Join(base, dir)); err != nil {
+			t.Fatalf("create fixtures directory %s failed %s", dir, err)
+		}
+	}
+	return base
+}
+
+func TestWithFixtureProfile(t *testing.T) {
+	base := writeFixtureProfiles(t)
+
+	// the profile can be given before the fixtures directory
+	db, err := New(WithFixtureProfile("ci"), WithFixtures(base))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	want := []string{filepath.Join(base, commonFixtures, "00_schema.sql"), filepath.Join(base, "ci", "01_foo.sql")}
+	if got := db.Config().FixtureFiles; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected fixtures %v, got %v", want, got)
+	}
+
+	if _, err := New(WithFixtures(base), WithFixtureProfile("staging")); err == nil {
+		t.Fatal("expected missing profile directory to fail")
+	}
+	if _, err := New(WithFixtureProfile("ci")); err == nil {
+		t.Fatal("expected profile without fixtures directory to fail")
+	}
+	for _, name := range []string{".", commonFixtures, "..", "ci/dev"} {
+		if _, err := New(WithFixtureProfile(name)); err == nil {
+			t.Fatalf("expected invalid profile %q to fail", name)
+		}
+	}
+}
+
+func TestFixtureProfileApplied(t *testing.T) {
+	db := startTestPostgres(t, WithFixtures(writeFixtureProfiles(t)), WithFixtureProfile("ci"))
+
+	if n := countRows(t, db.URI(), "foo where profile = 'ci'"); n != 2 {
+		t.Fatalf("expected 2 rows of the ci profile, got %d", n)
+	}
+	if n := countRows(t, db.URI(), "foo where profile <> 'ci'"); n != 0 {
+		t.Fatalf("expected no rows of other profiles, got %d", n)
+	}
+}
@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Migrator is a migration written in go, for data transformations which need application code, see
+// WithGoMigrations. Up applies the migration, Down reverts it and is not run by dbctl, which only migrates up.
+// Version orders the migration among the migration files, like 0003 runs after 0002_users.up.sql.
+type Migrator interface {
+	Version() string
+	Up(ctx context.Context, db *sql.DB) error
+	Down(ctx context.Context, db *sql.DB) error
+}
+
+// ChecksumMigrator is a Migrator telling a checksum of its code, like a hash or revision of the source. Templates
+// built with the migration are rebuilt when its checksum changes.
+type ChecksumMigrator interface {
+	Migrator
+	Checksum() string
+}
+
+// GoMigration is a Migrator built from functions, UpFunc is required and a nil DownFunc does nothing.
+// Hash is returned as its Checksum, change it along with the code of UpFunc.
+type GoMigration struct {
+	ID       string
+	Hash     string
+	UpFunc   func(ctx context.Context, db *sql.DB) error
+	DownFunc func(ctx context.Context, db *sql.DB) error
+}
+
+func (m GoMigration) Version() string { return m.ID }
+
+func (m GoMigration) Checksum() string { return m.Hash }
+
+func (m GoMigration) Up(ctx context.Context, db *sql.DB) error { return m.UpFunc(ctx, db) }
+
+func (m GoMigration) Down(ctx context.Context, db *sql.DB) error {
+	if m.DownFunc == nil {
+		return nil
+	}
+	return m.DownFunc(ctx, db)
+}
+
+// hasUp reports whether m can be applied, a GoMigration without UpFunc can't
+func hasUp(m Migrator) bool {
+	switch gm := m.(type) {
+	case GoMigration:
+		return gm.UpFunc != nil
+	case *GoMigration:
+		return gm != nil && gm.UpFunc != nil
+	}
+	return true
+}
+
+// migrationStep is a migration file or a go migration
+type migrationStep struct {
+	file     string
+	migrator Migrator
+}
+
+// orderMigrations interleaves go migrations with the ordered migration files by version. Files are kept in
+// their order, so the go migrations are interleaved at file level, not between the entries of an archive.
+func orderMigrations(files []string, migrators []Migrator) []migrationStep {
+	migrators = append([]Migrator(nil), migrators...)
+	sort.SliceStable(migrators, func(i, j int) bool {
+		return migrationLess(migrators[i].Version(), migrators[j].Version())
+	})
+
+	steps := make([]migrationStep, 0, len(files)+len(migrators))
+	for len(files) > 0 || len(migrators) > 0 {
+		if len(migrators) > 0 && (len(files) == 0 || migrationLess(migrators[0].Version(), files[0])) {
+			steps = append(steps, migrationStep{migrator: migrators[0]})
+			migrators = migrators[1:]
+			continue
+		}
+		steps = append(steps, migrationStep{file: files[0]})
+		files = files[1:]
+	}
+	return steps
+}
+
+// runGoMigration applies a go migration, the migration gets its own connections of the pool as the session
+// holding the migrations lock is in use meanwhile
+func runGoMigration(ctx context.Context, db *sql.DB, m Migrator) error {
+	if err := m.Up(ctx, db); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("applying go migration (%s) cancelled: %w", m.Version(), ctxErr)
+		}
+		return fmt.Errorf("applying go migration (%s) failed: %w", m.Version(), err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderMigrations(t *testing.T) {
+	files := []string{"/m/0001_users.up.sql", "/m/0003_index.up.sql", "/m/seed.sql"}
+	migrators := []Migrator{GoMigration{ID: "0004"}, GoMigration{ID: "0002"}, GoMigration{ID: "0010"}}
+
+	var got []string
+	for _, s := range orderMigrations(files, migrators) {
+		if s.migrator != nil {
+			got = append(got, "go:"+s.migrator.Version())
+		} else {
+			got = append(got, s.file)
+		}
+	}
+
+	expected := []string{"/m/0001_users.up.sql", "go:0002", "/m/0003_index.up.sql", "go:0004", "go:0010", "/m/seed.sql"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestWithGoMigrationsValidation(t *testing.T) {
+	if _, err := New(WithGoMigrations(GoMigration{})); err == nil {
+		t.Fatal("expected error for a go migration without version")
+	}
+	if _, err := New(WithGoMigrations(GoMigration{ID: "0002"})); err == nil {
+		t.Fatal("expected error for a go migration without UpFunc")
+	}
+	if _, err := New(WithGoMigrations(&GoMigration{ID: "0002"})); err == nil {
+		t.Fatal("expected error for a go migration pointer without UpFunc")
+	}
+
+	up := func(ctx context.Context, db *sql.DB) error { return nil }
+	if _, err := New(WithGoMigrations(GoMigration{ID: "0002", UpFunc: up}, GoMigration{ID: "2", UpFunc: up})); err == nil {
+		t.Fatal("expected error for duplicate go migration versions")
+	}
+
+	migrations := writeSQLFiles(t, map[string]string{"0002_users.up.sql": "create table users(id int);"})
+	// the go migration is checked against the files whatever the order of the options
+	if _, err := New(WithGoMigrations(GoMigration{ID: "2", UpFunc: up}), WithMigrations(migrations)); err == nil {
+		t.Fatal("expected error for a go migration with the version of a migration file")
+	}
+	if _, err := New(WithMigrations(migrations), WithGoMigrations(GoMigration{ID: "0003", UpFunc: up})); err != nil {
+		t.Fatalf("expected a go migration with a new version to be accepted, got %s", err)
+	}
+}
+
+func TestWithGoMigrations(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_users.up.sql": "create table users(id int, name text); insert into users values (1, 'ada'), (2, 'alan');",
+		// fails unless the go migration ran before it
+		"0003_upper.up.sql": "alter table users add constraint users_name_upper check (name = upper(name));",
+	})
+
+	upper := GoMigration{ID: "0002", UpFunc: func(ctx context.Context, db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, "select id, name from users")
+		if err != nil {
+			return err
+		}
+		names := make(map[int]string)
+		for rows.Next() {
+			var id int
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				return err
+			}
+			names[id] = strings.ToUpper(name)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		for id, name := range names {
+			if _, err := db.ExecContext(ctx, "update users set name = $1 where id = $2", name, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+
+	db := startTestPostgres(t, WithMigrations(migrations), WithGoMigrations(upper))
+
+	if n := countRows(t, db.URI(), "users where name in ('ADA', 'ALAN')"); n != 2 {
+		t.Fatalf("expected the go migration to transform 2 rows, got %d", n)
+	}
+
+	versions, err := appliedMigrations(context.Background(), db.URI())
+	if err != nil {
+		t.Fatalf("appliedMigrations failed %s", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"0001", "0002", "0003"}) {
+		t.Fatalf("expected versions [0001 0002 0003], got %v", versions)
+	}
+}
@@ -0,0 +1,126 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// maxIdleCheckInterval is the longest interval between two idle checks
+const maxIdleCheckInterval = 10 * time.Second
+
+// clock abstracts time, so idle detection can be tested without waiting
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// watchIdle stops the database using shutdown once it is idle for the configured idle timeout.
+// The returned channel receives the shutdown result, it is nil if idle timeout is not set.
+func (p *Postgres) watchIdle(ctx context.Context, shutdown func(ctx context.Context) error) <-chan error {
+	if p.cfg.idleTimeout <= 0 {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.stopWhenIdle(ctx, p.activeConnections, shutdown)
+	}()
+	return done
+}
+
+// stopWhenIdle periodically checks active connections, and calls shutdown when there is no
+// active connection for idle timeout. Any activity restarts the idle period.
+func (p *Postgres) stopWhenIdle(ctx context.Context, active func(ctx context.Context) (int, error), shutdown func(ctx context.Context) error) error {
+	interval := p.cfg.idleTimeout
+	if interval > maxIdleCheckInterval {
+		interval = maxIdleCheckInterval
+	}
+
+	lastActive := p.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.clock.After(interval):
+		}
+
+		n, err := active(ctx)
+		if err != nil {
+			p.log(logger.LevelDebug, "idle_check_failed", fmt.Sprintf("check idle connections failed: %s", err), fields{"error": err.Error()})
+			continue
+		}
+
+		if n > 0 {
+			lastActive = p.clock.Now()
+			continue
+		}
+
+		if idle := p.clock.Now().Sub(lastActive); idle >= p.cfg.idleTimeout {
+			p.log(logger.LevelWarn, "idle_shutdown", fmt.Sprintf("No client connections for %s, stopping database", idle.Round(time.Second)),
+				fields{"container_id": p.containerID, "idle_ms": idle.Milliseconds()})
+			return shutdown(ctx)
+		}
+	}
+}
+
+// idleShutdownScript runs the postgres entrypoint with the arguments of the container command, next to a loop
+// stopping the server once there is no client connection for the idle timeout. It connects over tcp, as the
+// temporary server of the entrypoint is not listening on it during initialization, and the socket may be moved.
+const idleShutdownScript = `(
+idle=0
+while sleep %[1]d; do
+  n=$(PGPASSWORD="$POSTGRES_PASSWORD" psql -XAtq -h 127.0.0.1 -U "$POSTGRES_USER" -d "$POSTGRES_DB" \
+    -c "select count(*) from pg_stat_activity where backend_type = 'client backend' and pid <> pg_backend_pid()" 2>/dev/null) || { idle=0; continue; }
+  if [ "$n" -gt 0 ]; then idle=0; else idle=$((idle + %[1]d)); fi
+  if [ "$idle" -ge %[2]d ]; then
+    echo "dbctl: no client connections for %[2]ds, stopping database"
+    kill -INT 1
+    exit 0
+  fi
+done
+) &
+exec docker-entrypoint.sh "$@"`
+
+// withIdleShutdown makes the container of a detached database stop itself when it is idle for the idle timeout,
+// as no dbctl process is left to watch it. The container is removed once postgres exits.
+func withIdleShutdown(req container.CreateRequest, timeout time.Duration) (container.CreateRequest, error) {
+	if policy := req.RestartPolicy; policy != "" && policy != "no" {
+		return req, fmt.Errorf("idle timeout of a detached database can not be used with restart policy %q", policy)
+	}
+
+	interval := timeout
+	if interval > maxIdleCheckInterval {
+		interval = maxIdleCheckInterval
+	}
+	seconds := func(d time.Duration) int { return int(math.Ceil(d.Seconds())) }
+
+	req.Entrypoint = []string{"sh", "-c", fmt.Sprintf(idleShutdownScript, seconds(interval), seconds(timeout)), "sh"}
+	req.AutoRemove = true
+	return req, nil
+}
+
+// activeConnections returns the number of client connections, except the one used for checking
+func (p *Postgres) activeConnections(ctx context.Context) (int, error) {
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var n int
+	err = conn.QueryRowContext(ctx, "select count(*) from pg_stat_activity where backend_type = 'client backend' and pid <> pg_backend_pid()").Scan(&n)
+	return n, err
+}
@@ -0,0 +1,156 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+// fakeClock is a manually driven clock, each tick moves the time forward and fires the pending After
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters chan struct{}
+	ticks   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0), waiters: make(chan struct{}, 1), ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(time.Duration) <-chan time.Time {
+	c.waiters <- struct{}{}
+	return c.ticks
+}
+
+// tick waits for the next After call, then moves the clock to the given offset and fires it
+func (c *fakeClock) tick(offset time.Duration) {
+	<-c.waiters
+
+	c.mu.Lock()
+	c.now = time.Unix(0, 0).Add(offset)
+	now := c.now
+	c.mu.Unlock()
+	c.ticks <- now
+}
+
+func TestStopWhenIdle(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithIdleTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	clk := newFakeClock()
+	db.clock = clk
+
+	// each check reads the number of active connections after its tick
+	connections := make(chan int)
+	active := func(context.Context) (int, error) {
+		return <-connections, nil
+	}
+
+	stopped := make(chan struct{})
+	shutdown := func(context.Context) error {
+		close(stopped)
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- db.stopWhenIdle(context.Background(), active, shutdown)
+	}()
+
+	check := func(offset time.Duration, n int) {
+		clk.tick(offset)
+		connections <- n
+	}
+
+	// a tick is only received if the previous check did not stop the database
+	check(40*time.Second, 0)
+	check(50*time.Second, 1) // activity restarts the idle period
+	check(100*time.Second, 0)
+	check(109*time.Second, 0)
+	check(110*time.Second, 0)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("stopWhenIdle failed %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected database to be stopped after idle timeout")
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("expected shutdown to be called")
+	}
+}
+
+func TestStopWhenIdleCancelled(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithIdleTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.clock = newFakeClock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = db.stopWhenIdle(ctx, func(context.Context) (int, error) { return 0, nil }, func(context.Context) error {
+		t.Fatal("expected no shutdown after cancel")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestWithIdleShutdown(t *testing.T) {
+	req, err := withIdleShutdown(container.CreateRequest{Cmd: []string{"postgres", "-c", "fsync=off"}}, 90*time.Second)
+	if err != nil {
+		t.Fatalf("withIdleShutdown failed %s", err)
+	}
+
+	if len(req.Entrypoint) != 4 || req.Entrypoint[0] != "sh" || req.Entrypoint[1] != "-c" || req.Entrypoint[3] != "sh" {
+		t.Fatalf("expected a shell entrypoint, got %q", req.Entrypoint)
+	}
+	script := req.Entrypoint[2]
+	for _, want := range []string{"while sleep 10;", `-ge 90 ]`, "kill -INT 1", `exec docker-entrypoint.sh "$@"`} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("expected script to contain %q, got %s", want, script)
+		}
+	}
+	if !req.AutoRemove || len(req.Cmd) != 3 {
+		t.Fatalf("expected auto removed container keeping its command, got %+v", req)
+	}
+
+	if _, err := withIdleShutdown(container.CreateRequest{RestartPolicy: "unless-stopped"}, time.Minute); err == nil {
+		t.Fatal("expected idle shutdown with a restart policy to fail")
+	}
+}
+
+func TestStartDetachedIdleTimeout(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithIdleTimeout(time.Minute), WithReadReplica(0))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = &fakeRunner{}
+
+	var startErr *StartError
+	if err := db.Start(context.Background(), true); !errors.As(err, &startErr) || startErr.Phase != PhaseContainer {
+		t.Fatalf("expected detached idle timeout with a replica to fail, got %v", err)
+	}
+}
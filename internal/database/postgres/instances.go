@@ -0,0 +1,156 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+// InstanceFilter narrows the instances returned by InstancesFiltered, zero fields match any instance
+type InstanceFilter struct {
+	// Version is the postgres version selected by WithVersion, like 16 or 14.3.2
+	Version string
+	// Port is the host port, of instances started with WithAutoPort too
+	Port uint32
+	// Name is the instance name, see WithInstanceName
+	Name string
+	// NamePrefix is the prefix of the container name, see WithNamePrefix
+	NamePrefix string
+	// Labels are user labels the instance must have all of, see WithLabels
+	Labels map[string]string
+}
+
+// labels returns the container labels matched by the filter
+func (f InstanceFilter) labels() map[string]string {
+	labels := map[string]string{container.LabelType: database.LabelPostgres}
+	for k, v := range f.Labels {
+		labels[k] = v
+	}
+	if f.Version != "" {
+		labels[container.LabelDBVersion] = f.Version
+	}
+	if f.Name != "" {
+		labels[container.LabelName] = f.Name
+	}
+	return labels
+}
+
+func (f InstanceFilter) matches(c *container.Container) bool {
+	for k, v := range f.labels() {
+		if c.Labels[k] != v {
+			return false
+		}
+	}
+	// the port is not a label of instances started with WithAutoPort
+	if f.Port != 0 && containerInfo(c).Port != f.Port {
+		return false
+	}
+	// docker lists container names with a leading slash
+	return strings.HasPrefix(strings.TrimPrefix(c.Name, "/"), f.NamePrefix)
+}
+
+// InstancesFiltered returns the postgres instances matching filter
+func InstancesFiltered(ctx context.Context, filter InstanceFilter) ([]database.Info, error) {
+	l, err := dockerRunner{}.List(ctx, filter.labels())
+	if err != nil {
+		return nil, err
+	}
+	return filterInstances(l, filter), nil
+}
+
+func filterInstances(l []*container.Container, filter InstanceFilter) []database.Info {
+	out := make([]database.Info, 0, len(l))
+	for _, c := range l {
+		if filter.matches(c) {
+			out = append(out, containerInfo(c))
+		}
+	}
+	return out
+}
+
+// StopByName stops the postgres instance started with the given WithInstanceName, along with its read replica
+func StopByName(ctx context.Context, name string) error {
+	return stopByName(ctx, dockerRunner{}, name)
+}
+
+func stopByName(ctx context.Context, r runner, name string) error {
+	if name == "" {
+		return errors.New("instance name is required")
+	}
+
+	items, err := instances(ctx, r, map[string]string{container.LabelName: name})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no postgres instance named %q", name)
+	}
+
+	for _, i := range items {
+		if err := r.TerminateByID(ctx, i.ID); err != nil {
+			return fmt.Errorf("stop instance %q failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkInstanceName fails if a postgres instance with the instance name of p is already running
+func (p *Postgres) checkInstanceName(ctx context.Context) error {
+	items, err := instances(ctx, p.runner, map[string]string{container.LabelName: p.cfg.instanceName})
+	if err != nil {
+		return fmt.Errorf("list postgres containers failed: %w", err)
+	}
+
+	for _, i := range items {
+		if i.Status == database.Running {
+			return fmt.Errorf("postgres instance %q is already running as container %s, stop it or use WithReuse", p.cfg.instanceName, i.ID)
+		}
+	}
+	return nil
+}
+
+// InstancesCache lists postgres instances at most once per ttl and serves filtered results from the
+// last listing in between, for callers polling frequently like a dashboard. It is safe for concurrent use.
+type InstancesCache struct {
+	ttl    time.Duration
+	runner runner
+	clock  clock
+
+	mu       sync.Mutex
+	listed   []*container.Container
+	listedAt time.Time
+}
+
+// NewInstancesCache returns a cache listing instances again once the previous listing is older than ttl
+func NewInstancesCache(ttl time.Duration) *InstancesCache {
+	return &InstancesCache{ttl: ttl, runner: dockerRunner{}, clock: realClock{}}
+}
+
+// InstancesFiltered returns the postgres instances matching filter, from the cache if it is fresh
+func (c *InstancesCache) InstancesFiltered(ctx context.Context, filter InstanceFilter) ([]database.Info, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// listing while holding the lock makes concurrent callers share a single docker call
+	if c.listed == nil || c.clock.Now().Sub(c.listedAt) >= c.ttl {
+		l, err := c.runner.List(ctx, map[string]string{container.LabelType: database.LabelPostgres})
+		if err != nil {
+			return nil, err
+		}
+		c.listed, c.listedAt = l, c.clock.Now()
+	}
+	return filterInstances(c.listed, filter), nil
+}
+
+// Invalidate drops the cached listing, like after starting or stopping an instance
+func (c *InstancesCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listed = nil
+}
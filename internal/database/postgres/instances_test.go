@@ -0,0 +1,220 @@
+package pg
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+func testInstance(id, name, version, port string) *container.Container {
+	labels := map[string]string{
+		container.LabelType:      database.LabelPostgres,
+		container.LabelDBVersion: version,
+	}
+	if port != "" {
+		labels[container.LabelPort] = port
+	}
+	return &container.Container{ID: id, Name: "/" + name, State: "running", Labels: labels}
+}
+
+func instanceIDs(infos []database.Info) []string {
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID
+	}
+	return ids
+}
+
+func TestInstancesFilter(t *testing.T) {
+	l := []*container.Container{
+		testInstance("a", "dbctl_pg_1", "16", "15432"),
+		testInstance("b", "dbctl_pg_2", "14.3.2", "15433"),
+		testInstance("c", "ci_pg_3", "16", ""),
+	}
+	// started with WithAutoPort, the port is only known from the published ports
+	l[2].Ports = []container.Port{{PrivatePort: 5432, PublicPort: 49153, Type: "tcp"}}
+	other := testInstance("d", "dbctl_pg_4", "16", "15434")
+	other.Labels["team"] = "api"
+	l = append(l, other)
+
+	for _, tt := range []struct {
+		name   string
+		filter InstanceFilter
+		want   []string
+	}{
+		{"empty", InstanceFilter{}, []string{"a", "b", "c", "d"}},
+		{"version", InstanceFilter{Version: "16"}, []string{"a", "c", "d"}},
+		{"port", InstanceFilter{Port: 15433}, []string{"b"}},
+		{"auto port", InstanceFilter{Port: 49153}, []string{"c"}},
+		{"name prefix", InstanceFilter{NamePrefix: "ci_"}, []string{"c"}},
+		{"labels", InstanceFilter{Labels: map[string]string{"team": "api"}}, []string{"d"}},
+		{"combined", InstanceFilter{Version: "16", NamePrefix: "dbctl_"}, []string{"a", "d"}},
+		{"no match", InstanceFilter{Version: "15"}, []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := instanceIDs(filterInstances(l, tt.filter))
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerInfoPort(t *testing.T) {
+	c := testInstance("a", "dbctl_pg_1", "16", "15432")
+	c.Ports = []container.Port{{PrivatePort: 5432, PublicPort: 15432, Type: "tcp"}}
+	if port := containerInfo(c).Port; port != 15432 {
+		t.Fatalf("expected port 15432 of the label, got %d", port)
+	}
+
+	c = testInstance("b", "dbctl_pg_2", "16", "")
+	c.Ports = []container.Port{{PrivatePort: 5432, Type: "tcp"}, {PrivatePort: 5432, PublicPort: 49153, Type: "tcp"}}
+	if port := containerInfo(c).Port; port != 49153 {
+		t.Fatalf("expected published port 49153, got %d", port)
+	}
+
+	if port := containerInfo(testInstance("c", "dbctl_pg_3", "16", "")).Port; port != 0 {
+		t.Fatalf("expected no port without label and published ports, got %d", port)
+	}
+}
+
+func TestInstancesCache(t *testing.T) {
+	r := &fakeRunner{containers: []*container.Container{
+		testInstance("a", "dbctl_pg_1", "16", "15432"),
+		testInstance("b", "dbctl_pg_2", "14", "15433"),
+	}}
+	clock := newFakeClock()
+	cache := NewInstancesCache(time.Second)
+	cache.runner, cache.clock = r, clock
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.InstancesFiltered(ctx, InstanceFilter{}); err != nil {
+				t.Errorf("InstancesFiltered failed %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := cache.InstancesFiltered(ctx, InstanceFilter{Version: "14"})
+	if err != nil {
+		t.Fatalf("InstancesFiltered failed %s", err)
+	}
+	if ids := instanceIDs(got); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("expected instance b, got %v", ids)
+	}
+	if r.lists != 1 {
+		t.Fatalf("expected a single listing within ttl, got %d", r.lists)
+	}
+
+	// a listing older than the ttl is refreshed
+	r.mu.Lock()
+	r.containers = append(r.containers, testInstance("c", "dbctl_pg_3", "14", "15434"))
+	r.mu.Unlock()
+	clock.mu.Lock()
+	clock.now = clock.now.Add(time.Second)
+	clock.mu.Unlock()
+
+	got, err = cache.InstancesFiltered(ctx, InstanceFilter{Version: "14"})
+	if err != nil {
+		t.Fatalf("InstancesFiltered failed %s", err)
+	}
+	if len(got) != 2 || r.lists != 2 {
+		t.Fatalf("expected refreshed listing with 2 instances, got %v after %d listings", instanceIDs(got), r.lists)
+	}
+
+	cache.Invalidate()
+	if _, err := cache.InstancesFiltered(ctx, InstanceFilter{}); err != nil {
+		t.Fatalf("InstancesFiltered failed %s", err)
+	}
+	if r.lists != 3 {
+		t.Fatalf("expected listing after Invalidate, got %d listings", r.lists)
+	}
+}
+
+func TestStopByName(t *testing.T) {
+	app := testInstance("a", "dbctl_pg_1", "16", "15432")
+	app.Labels[container.LabelName] = "app"
+	analytics := testInstance("b", "dbctl_pg_2", "16", "15433")
+	analytics.Labels[container.LabelName] = "analytics"
+	r := &fakeRunner{containers: []*container.Container{app, analytics, testInstance("c", "dbctl_pg_3", "16", "15434")}}
+
+	if err := stopByName(context.Background(), r, "analytics"); err != nil {
+		t.Fatalf("stopByName failed %s", err)
+	}
+	if len(r.terminated) != 1 || r.terminated[0] != "b" {
+		t.Fatalf("expected only the analytics instance to be stopped, got %v", r.terminated)
+	}
+
+	if err := stopByName(context.Background(), r, "missing"); err == nil {
+		t.Fatal("expected an error stopping an unknown instance")
+	}
+}
+
+func TestCheckInstanceName(t *testing.T) {
+	running := testInstance("a", "dbctl_pg_1", "16", "15432")
+	running.Labels[container.LabelName] = "app"
+
+	db, err := New(WithInstanceName("app"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = &fakeRunner{containers: []*container.Container{running}}
+	if err := db.checkInstanceName(context.Background()); err == nil {
+		t.Fatal("expected an error for a name already taken by a running instance")
+	}
+
+	running.State = "exited"
+	if err := db.checkInstanceName(context.Background()); err != nil {
+		t.Fatalf("expected the name of a stopped instance to be available, got %s", err)
+	}
+
+	if _, err := New(WithInstanceName("my app")); err == nil {
+		t.Fatal("expected an error for an invalid instance name")
+	}
+}
+
+func TestNamedInstances(t *testing.T) {
+	app := startTestPostgres(t, WithInstanceName("dbctl_test_app"))
+	analytics := startTestPostgres(t, WithInstanceName("dbctl_test_analytics"))
+
+	ctx := context.Background()
+	named, err := InstancesFiltered(ctx, InstanceFilter{Name: "dbctl_test_analytics"})
+	if err != nil {
+		t.Fatalf("InstancesFiltered failed %s", err)
+	}
+	if len(named) != 1 || named[0].ID != analytics.ContainerID() || named[0].Name != "dbctl_test_analytics" {
+		t.Fatalf("expected the analytics instance, got %+v", named)
+	}
+
+	if err := StopByName(ctx, "dbctl_test_analytics"); err != nil {
+		t.Fatalf("StopByName failed %s", err)
+	}
+
+	named, err = InstancesFiltered(ctx, InstanceFilter{Name: "dbctl_test_analytics"})
+	if err != nil {
+		t.Fatalf("InstancesFiltered failed %s", err)
+	}
+	for _, i := range named {
+		if i.Status == database.Running {
+			t.Fatalf("expected the analytics instance to be stopped, got %+v", i)
+		}
+	}
+
+	if err := app.WaitForStart(ctx, 5*time.Second); err != nil {
+		t.Fatalf("expected the app instance to keep running, got %s", err)
+	}
+}
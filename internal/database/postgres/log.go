@@ -0,0 +1,63 @@
+package pg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// LogFormat is the format of the logs emitted by postgres
+type LogFormat string
+
+const (
+	// LogText emits human readable log messages using the default logger
+	LogText LogFormat = "text"
+	// LogJSON emits one json object per line, with the event name and its fields
+	LogJSON LogFormat = "json"
+)
+
+// fields are extra structured data attached to a log event
+type fields map[string]any
+
+// log emits a log event. In text format only the message is logged to the configured logger, or the default
+// logger. In json format the event, message and fields are written as a json line to the configured logger, or stderr.
+func (p *Postgres) log(lvl logger.LogLevel, event, msg string, f fields) {
+	if p.cfg.logFormat != LogJSON {
+		logger.PrintTo(p.cfg.logger, lvl, msg)
+		return
+	}
+
+	if !logger.Enabled(lvl) {
+		return
+	}
+
+	entry := map[string]any{}
+	for k, v := range f {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = lvl.String()
+	entry["event"] = event
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.PrintTo(p.cfg.logger, logger.LevelWarn, fmt.Sprintf("encode log event %q failed: %s", event, err))
+		return
+	}
+
+	var w io.Writer = os.Stderr
+	if p.cfg.logger != nil {
+		w = p.cfg.logger
+	}
+	_, _ = w.Write(append(line, '\n'))
+}
+
+// durationMs returns the elapsed time since start in milliseconds
+func durationMs(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
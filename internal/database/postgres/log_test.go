@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/logger"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestJSONLogFormat(t *testing.T) {
+	var out bytes.Buffer
+	port := uint32(utils.GetAvailablePort())
+	db, err := New(
+		WithHost(DefaultUser, DefaultPass, DefaultName, port),
+		WithVersion("14.3.2"),
+		WithLogger(&out),
+		WithLogFormat(LogJSON),
+		WithStartupTimeout(100*time.Millisecond),
+		WithPollInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	// the fake runner does not start anything, so the startup fails after the starting event
+	db.runner = &fakeRunner{logs: "FATAL: boom\n"}
+
+	if err := db.Start(context.Background(), true); err == nil {
+		t.Fatal("expected start to fail")
+	}
+
+	events := map[string]map[string]any{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected json log line, got %q: %s", scanner.Text(), err)
+		}
+		events[entry["event"].(string)] = entry
+	}
+
+	starting, ok := events["starting"]
+	if !ok {
+		t.Fatalf("expected a starting event, got %v", events)
+	}
+
+	if starting["level"] != "INFO" || starting["version"] != "14.3.2" || starting["port"] != float64(port) {
+		t.Fatalf("unexpected starting event %v", starting)
+	}
+
+	if failed, ok := events["start_failed"]; !ok || failed["logs"] != "FATAL: boom\n" {
+		t.Fatalf("expected start_failed event with container logs, got %v", events)
+	}
+}
+
+func TestStartedEventHasDuration(t *testing.T) {
+	var out bytes.Buffer
+	startTestPostgres(t, WithLogger(&out), WithLogFormat(LogJSON))
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected json log line, got %q: %s", scanner.Text(), err)
+		}
+
+		if entry["event"] == "started" {
+			if _, ok := entry["duration_ms"].(float64); !ok {
+				t.Fatalf("expected duration_ms in started event, got %v", entry)
+			}
+			return
+		}
+	}
+	t.Fatal("expected a started event")
+}
+
+func TestJSONLogDatabaseNameTaken(t *testing.T) {
+	var out bytes.Buffer
+	db, err := New(WithLogger(&out), WithLogFormat(LogJSON))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	var taken string
+	_, err = db.createWithUniqueName("", func(name string) error {
+		if taken == "" {
+			taken = name
+			return &pq.Error{Code: "42P04"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("createWithUniqueName failed %s", err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a json log line, got %q: %s", out.String(), err)
+	}
+	if entry["event"] != "database_name_taken" || entry["database"] != taken {
+		t.Fatalf("unexpected retry event %v", entry)
+	}
+}
+
+func TestTextLogFormatUsesLogger(t *testing.T) {
+	var out bytes.Buffer
+	db, err := New(WithLogger(&out))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	db.log(logger.LevelInfo, "starting", "Starting postgres", nil)
+	if !strings.HasSuffix(out.String(), "INFO: Starting postgres\n") {
+		t.Fatalf("expected the text message in the logger, got %q", out.String())
+	}
+}
+
+func TestWithLogFormatValidation(t *testing.T) {
+	if _, err := New(WithLogFormat("xml")); err == nil {
+		t.Fatal("expected an error for unsupported log format")
+	}
+}
@@ -0,0 +1,351 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+// migrationsTable records the versions of applied migrations, it's named after dbctl
+// so it doesn't collide with the schema_migrations table of migration tools
+const migrationsTable = "dbctl_schema_migrations"
+
+// migrationVersionPrefix matches the numeric prefix of a migration file name
+var migrationVersionPrefix = regexp.MustCompile(`^[0-9]+`)
+
+// migrationsLockKey is the key of the advisory lock held while applying migrations, "dbctl_mg" in ascii
+const migrationsLockKey int64 = 0x6462_6374_6c5f_6d67
+
+// applyMigrations applies migration files and go migrations in order and records the version of each applied
+// migration. It holds an advisory lock on the database meanwhile, so processes migrating the same database run
+// one at a time, and skips the migrations already recorded so the ones waiting for the lock don't apply them again.
+func applyMigrations(ctx context.Context, conn *sql.DB, files []string, uri string, migrators ...Migrator) error {
+	if conn == nil {
+		var err error
+		conn, err = dbConnect(ctx, uri)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+	}
+
+	// the lock belongs to a session, so the migrations run on the session holding it. Running them on other
+	// connections of the pool would deadlock a pool limited to a single connection.
+	session, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+
+	if err := lockMigrations(ctx, session); err != nil {
+		return err
+	}
+	defer unlockMigrations(session)
+
+	stmt := "create table if not exists " + migrationsTable + " (version text primary key, applied_at timestamptz not null default now())"
+	if _, err := session.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create migrations table failed: %w", err)
+	}
+
+	// read on the session holding the lock, so migrations recorded by the previous holder are seen
+	recorded, err := readAppliedMigrations(ctx, session)
+	if err != nil {
+		return fmt.Errorf("read applied migrations failed: %w", err)
+	}
+	isApplied := func(version string) bool {
+		for _, v := range recorded {
+			if sameVersion(v, version) {
+				return true
+			}
+		}
+		return false
+	}
+
+	record := func(ctx context.Context, conn execer, version string) error {
+		stmt := "insert into " + migrationsTable + " (version) values ($1) on conflict do nothing"
+		if _, err := conn.ExecContext(ctx, stmt, version); err != nil {
+			return fmt.Errorf("record migration (%s) failed: %w", version, err)
+		}
+		return nil
+	}
+
+	for _, step := range orderMigrations(files, migrators) {
+		if step.migrator != nil {
+			if isApplied(step.migrator.Version()) {
+				continue
+			}
+			if err := runGoMigration(ctx, conn, step.migrator); err != nil {
+				return err
+			}
+			if err := record(ctx, session, step.migrator.Version()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		skip := func(src sqlSource) bool { return isApplied(migrationVersion(src.name)) }
+		err := execSQLSources(ctx, session, []string{step.file}, skip, func(ctx context.Context, conn execer, src sqlSource) error {
+			return record(ctx, conn, migrationVersion(src.name))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockMigrations waits for the migrations advisory lock of the database
+func lockMigrations(ctx context.Context, session *sql.Conn) error {
+	if _, err := session.ExecContext(ctx, "select pg_advisory_lock($1)", migrationsLockKey); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("waiting for migrations lock cancelled: %w", ctxErr)
+		}
+		return fmt.Errorf("acquire migrations lock failed: %w", err)
+	}
+	return nil
+}
+
+// unlockMigrations releases the migrations advisory lock, even if applying migrations was cancelled
+func unlockMigrations(session *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := session.ExecContext(ctx, "select pg_advisory_unlock($1)", migrationsLockKey); err != nil {
+		// the session would go back to the pool still holding the lock, discard it to end the session
+		_ = session.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+// CollectMigrations returns the migration files of paths, down migrations are ignored. Files of a single path
+// keep their name order, files of several paths are merged and ordered by numeric prefix across all of them.
+// A path can also be the http or https url of a sql file or archive, it is downloaded first, see fetchRemote.
+// Versions are recorded as written, so a version written with and without leading zeros, like 001 and 1, is an error.
+func CollectMigrations(paths []string) ([]string, error) {
+	var out []string
+	versionPath, versionName := make(map[string]string), make(map[string]string)
+	for _, path := range paths {
+		local := path
+		if isRemote(path) {
+			file, err := fetchRemote(path)
+			if err != nil {
+				return nil, err
+			}
+			local = file
+		}
+
+		files, err := GetFiles(local)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			// ignore migration down files
+			if strings.HasSuffix(f, "down.sql") {
+				continue
+			}
+
+			if v, ok := numericVersion(f); ok {
+				if other, dup := versionPath[v]; dup && other != path {
+					return nil, fmt.Errorf("migration version %s exists in both %s and %s", migrationVersion(f), other, path)
+				}
+				if other, dup := versionName[v]; dup && other != migrationVersion(f) {
+					return nil, fmt.Errorf("migration versions %s and %s are the same version, write them alike", other, migrationVersion(f))
+				}
+				versionPath[v], versionName[v] = path, migrationVersion(f)
+			}
+			out = append(out, f)
+		}
+	}
+
+	if len(paths) > 1 {
+		sort.SliceStable(out, func(i, j int) bool { return migrationLess(out[i], out[j]) })
+	}
+	return out, nil
+}
+
+// numericVersion returns the numeric prefix of a migration file without leading zeros, so 001 and 1 are the same version
+func numericVersion(file string) (string, bool) {
+	v := migrationVersionPrefix.FindString(filepath.Base(file))
+	if v == "" {
+		return "", false
+	}
+	if v = strings.TrimLeft(v, "0"); v == "" {
+		v = "0"
+	}
+	return v, true
+}
+
+// migrationLess orders migration files by numeric prefix, files without one come last ordered by name
+func migrationLess(a, b string) bool {
+	va, aok := numericVersion(a)
+	vb, bok := numericVersion(b)
+	switch {
+	case aok && bok && va != vb:
+		// compare as numbers without parsing, so long timestamps can't overflow
+		if len(va) != len(vb) {
+			return len(va) < len(vb)
+		}
+		return va < vb
+	case aok != bok:
+		return aok
+	default:
+		return filepath.Base(a) < filepath.Base(b)
+	}
+}
+
+// migrationVersion returns the version of a migration file, which is its numeric prefix (0001 of 0001_users.up.sql)
+// or the file name without its extensions if it has none
+func migrationVersion(file string) string {
+	name := filepath.Base(file)
+	if v := migrationVersionPrefix.FindString(name); v != "" {
+		return v
+	}
+	for _, ext := range []string{".gz", ".sql", ".up"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// sameVersion reports whether two versions are the same, numeric versions are compared without leading zeros
+func sameVersion(a, b string) bool {
+	na, aok := numericVersion(a)
+	nb, bok := numericVersion(b)
+	if aok && bok {
+		return na == nb
+	}
+	return a == b
+}
+
+// migrationVersions returns the sorted versions of migration files, including the files of archives
+func migrationVersions(files []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, f := range files {
+		sources, err := readSQLSources(f)
+		if err != nil {
+			return nil, fmt.Errorf("read file (%s) failed: %w", f, err)
+		}
+		for _, src := range sources {
+			seen[migrationVersion(src.name)] = true
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for v := range seen {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// WaitForMigrations waits until all configured migrations are recorded as applied in the database of uri.
+// Unlike WaitForStart, which only checks connectivity, it lets another process wait for a detached
+// dbctl to finish migrating. Migrations are tracked in the dbctl_schema_migrations table.
+func (p *Postgres) WaitForMigrations(ctx context.Context, uri string, timeout time.Duration) error {
+	expected, err := migrationVersions(p.cfg.migrationsFiles)
+	if err != nil {
+		return err
+	}
+	for _, m := range p.cfg.goMigrations {
+		expected = append(expected, m.Version())
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+
+	return utils.WaitFor(ctx, timeout, p.cfg.pollInterval, maxPollInterval, func(ctx context.Context) error {
+		return checkMigrations(ctx, uri, expected)
+	})
+}
+
+// checkMigrations returns an error listing the expected versions which are not applied yet
+func checkMigrations(ctx context.Context, uri string, expected []string) error {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	versions, err := readAppliedMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("read applied migrations failed: %w", err)
+	}
+
+	applied := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	var missing []string
+	for _, v := range expected {
+		if !applied[v] {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("migrations not applied yet: %s", strings.Join(missing, ","))
+	}
+	return nil
+}
+
+// querier runs queries, it is satisfied by both a connection pool and a single session
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// readAppliedMigrations returns the versions of the migrations recorded as applied in the database, in order
+func readAppliedMigrations(ctx context.Context, conn querier) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "select version from "+migrationsTable+" order by version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// appliedMigrations returns the versions of the migrations applied to the database of uri, none if dbctl never
+// migrated it. A database cloned from a template has the migrations of the template.
+func appliedMigrations(ctx context.Context, uri string) ([]string, error) {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	versions, err := readAppliedMigrations(ctx, conn)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42P01" {
+		// undefined table
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations failed: %w", err)
+	}
+	return versions, nil
+}
@@ -0,0 +1,223 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationVersion(t *testing.T) {
+	cases := map[string]string{
+		"0001_users.up.sql":               "0001",
+		"/migrations/20230924_orders.sql": "20230924",
+		"seed.tar.gz/0002_index.up.sql":   "0002",
+		"users.up.sql":                    "users",
+		"schema.sql.gz":                   "schema",
+	}
+	for file, expected := range cases {
+		if got := migrationVersion(file); got != expected {
+			t.Fatalf("%s: expected version %s, got %s", file, expected, got)
+		}
+	}
+}
+
+func TestWaitForMigrations(t *testing.T) {
+	db := startTestPostgres(t)
+
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_users.up.sql":  "create table users(id int);",
+		"0002_orders.up.sql": "create table orders(id int);",
+	})
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("GetFiles failed %s", err)
+	}
+
+	// waiter has the same migrations as the process applying them, like a second process would
+	waiter, err := New(WithMigrations(migrations))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	versions, err := migrationVersions(waiter.cfg.migrationsFiles)
+	if err != nil || !reflect.DeepEqual(versions, []string{"0001", "0002"}) {
+		t.Fatalf("expected versions [0001 0002], got %v (%v)", versions, err)
+	}
+
+	if err := waiter.WaitForMigrations(context.Background(), db.URI(), 300*time.Millisecond); err == nil {
+		t.Fatal("expected waiting for unapplied migrations to time out")
+	}
+
+	applied := make(chan error, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		applied <- RunMigrations(context.Background(), nil, files, db.URI())
+	}()
+
+	if err := waiter.WaitForMigrations(context.Background(), db.URI(), 10*time.Second); err != nil {
+		t.Fatalf("WaitForMigrations failed %s", err)
+	}
+	if err := <-applied; err != nil {
+		t.Fatalf("RunMigrations failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), migrationsTable); n != 2 {
+		t.Fatalf("expected 2 recorded migrations, got %d", n)
+	}
+}
+
+func TestWithMigrationsMultipleDirs(t *testing.T) {
+	core := writeSQLFiles(t, map[string]string{
+		"001_users.up.sql":   "create table users(id int);",
+		"001_users.down.sql": "drop table users;",
+		"010_orders.up.sql":  "create table orders(id int);",
+	})
+	tenant := writeSQLFiles(t, map[string]string{
+		"2_tenants.up.sql":   "create table tenants(id int);",
+		"11_invoices.up.sql": "create table invoices(id int);",
+	})
+
+	db, err := New(WithMigrations(core, tenant))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	var names []string
+	for _, f := range db.cfg.migrationsFiles {
+		names = append(names, filepath.Base(f))
+	}
+	expected := []string{"001_users.up.sql", "2_tenants.up.sql", "010_orders.up.sql", "11_invoices.up.sql"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected merged order %v, got %v", expected, names)
+	}
+
+	duplicate := writeSQLFiles(t, map[string]string{"10_accounts.up.sql": "create table accounts(id int);"})
+	if _, err := New(WithMigrations(core, duplicate)); err == nil || !strings.Contains(err.Error(), "exists in both") {
+		t.Fatalf("expected duplicate version error, got %v", err)
+	}
+
+	// 001 and 1 would be ordered as one version but recorded as two
+	zeros := writeSQLFiles(t, map[string]string{
+		"001_users.up.sql":  "create table users(id int);",
+		"1_accounts.up.sql": "create table accounts(id int);",
+	})
+	if _, err := New(WithMigrations(zeros)); err == nil || !strings.Contains(err.Error(), "same version") {
+		t.Fatalf("expected an error for a version written differently, got %v", err)
+	}
+}
+
+func TestRunMigrationsConcurrently(t *testing.T) {
+	db := startTestPostgres(t)
+
+	// the run records how many other sessions are running the migration, then keeps running it for a while.
+	// the table is created without if not exists, so applying the migration twice fails.
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_runs.up.sql": `-- dbctl_lock_probe
+create table runs(overlapping int);
+insert into runs select count(*) from pg_stat_activity where query like '%dbctl_lock_probe%' and pid <> pg_backend_pid();
+select pg_sleep(0.5);`,
+	})
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("GetFiles failed %s", err)
+	}
+
+	start := time.Now()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- RunMigrations(context.Background(), nil, files, db.URI())
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("RunMigrations failed %s", err)
+		}
+	}
+
+	// the caller waiting for the lock finds the migration applied
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("expected the migration to run while holding the lock, both finished in %s", elapsed)
+	}
+	if n := countRows(t, db.URI(), "runs"); n != 1 {
+		t.Fatalf("expected the migration to run once, got %d runs", n)
+	}
+	if n := countRows(t, db.URI(), "runs where overlapping > 0"); n != 0 {
+		t.Fatalf("expected no overlapping runs, got %d", n)
+	}
+}
+
+func TestSeedsOnStart(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  config
+		want bool
+	}{
+		{"fixtures without migrations", config{}, false},
+		{"fixtures with migrations", config{migrationsFiles: []string{"001.sql"}}, true},
+		{"skip migrations", config{skipMigrations: true}, true},
+		{"skip fixtures", config{migrationsFiles: []string{"001.sql"}, skipFixtures: true}, false},
+		{"skip both", config{skipMigrations: true, skipFixtures: true}, false},
+	} {
+		if got := tt.cfg.seedsOnStart(); got != tt.want {
+			t.Fatalf("%s: expected %t, got %t", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestWithSkipMigrationsAndFixtures(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{"001_users.up.sql": "create table users(id int);"})
+	fixtures := writeSQLFiles(t, map[string]string{"seed.sql": "create table seeded(id int);"})
+	tableCount := func(t *testing.T, uri, name string) int {
+		return countRows(t, uri, fmt.Sprintf("pg_tables where tablename = '%s'", name))
+	}
+
+	t.Run("skip migrations", func(t *testing.T) {
+		db := startTestPostgres(t, WithMigrations(migrations), WithFixtures(fixtures), WithSkipMigrations(true))
+		if tableCount(t, db.URI(), "users") != 0 || tableCount(t, db.URI(), "seeded") != 1 {
+			t.Fatal("expected fixtures to be applied without migrations")
+		}
+
+		// the standalone entrypoint applies the skipped phase
+		if err := db.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate failed %s", err)
+		}
+		if tableCount(t, db.URI(), "users") != 1 {
+			t.Fatal("expected Migrate to apply migrations")
+		}
+
+		// Reset skips migrations like Start
+		if err := db.Reset(context.Background()); err != nil {
+			t.Fatalf("Reset failed %s", err)
+		}
+		if tableCount(t, db.URI(), "users") != 0 || tableCount(t, db.URI(), "seeded") != 1 {
+			t.Fatal("expected Reset to apply fixtures without migrations")
+		}
+	})
+
+	t.Run("skip fixtures", func(t *testing.T) {
+		db := startTestPostgres(t, WithMigrations(migrations), WithFixtures(fixtures), WithSkipFixtures(true))
+		if tableCount(t, db.URI(), "users") != 1 || tableCount(t, db.URI(), "seeded") != 0 {
+			t.Fatal("expected migrations to be applied without fixtures")
+		}
+
+		if err := db.Seed(context.Background()); err != nil {
+			t.Fatalf("Seed failed %s", err)
+		}
+		if tableCount(t, db.URI(), "seeded") != 1 {
+			t.Fatal("expected Seed to apply fixtures")
+		}
+
+		// Reset skips fixtures like Start
+		if err := db.Reset(context.Background()); err != nil {
+			t.Fatalf("Reset failed %s", err)
+		}
+		if tableCount(t, db.URI(), "users") != 1 || tableCount(t, db.URI(), "seeded") != 0 {
+			t.Fatal("expected Reset to apply migrations without fixtures")
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package pg
+
+import "time"
+
+// Observer is notified about timings of postgres operations, e.g. to export them as metrics.
+// Methods are called synchronously, so they should return quickly.
+type Observer interface {
+	// ContainerStarted is called once the container is started and accepts queries
+	ContainerStarted(d time.Duration)
+	// MigrationsApplied is called after n migration files are applied
+	MigrationsApplied(n int, d time.Duration)
+	// FixturesApplied is called after n fixture files are applied
+	FixturesApplied(n int, d time.Duration)
+	// DBCreated is called after a database is created by CreateDB, including its migrations and fixtures
+	DBCreated(name string, d time.Duration)
+}
+
+// NopObserver ignores all notifications, it can be embedded to implement only some of Observer methods
+type NopObserver struct{}
+
+func (NopObserver) ContainerStarted(time.Duration)       {}
+func (NopObserver) MigrationsApplied(int, time.Duration) {}
+func (NopObserver) FixturesApplied(int, time.Duration)   {}
+func (NopObserver) DBCreated(string, time.Duration)      {}
+
+// observer returns the configured observer or a no-op one
+func (p *Postgres) observer() Observer {
+	if p.cfg.observer == nil {
+		return NopObserver{}
+	}
+	return p.cfg.observer
+}
@@ -0,0 +1,80 @@
+package pg
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+// recordingObserver records observed operations with their durations
+type recordingObserver struct {
+	mu     sync.Mutex
+	events map[string][]time.Duration
+	counts map[string]int
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{events: map[string][]time.Duration{}, counts: map[string]int{}}
+}
+
+func (r *recordingObserver) record(event string, n int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[event] = append(r.events[event], d)
+	r.counts[event] += n
+}
+
+func (r *recordingObserver) ContainerStarted(d time.Duration) {
+	r.record("container_started", 1, d)
+}
+
+func (r *recordingObserver) MigrationsApplied(n int, d time.Duration) {
+	r.record("migrations_applied", n, d)
+}
+
+func (r *recordingObserver) FixturesApplied(n int, d time.Duration) {
+	r.record("fixtures_applied", n, d)
+}
+
+func (r *recordingObserver) DBCreated(_ string, d time.Duration) {
+	r.record("db_created", 1, d)
+}
+
+func TestObserver(t *testing.T) {
+	obs := newRecordingObserver()
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001.up.sql": "create table foo(id int);",
+		"0002.up.sql": "create table bar(id int);",
+	})
+	fixtures := writeSQLFiles(t, map[string]string{"foo.sql": "insert into foo values (1);"})
+
+	db := startTestPostgres(t, WithObserver(obs), WithMigrations(migrations), WithFixtures(fixtures))
+	if _, err := db.CreateDB(context.Background(), &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures}); err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	expected := map[string]int{
+		"container_started": 1,
+		// migrations run on start and again to build the template for the request migrations
+		"migrations_applied": 4,
+		"fixtures_applied":   2,
+		"db_created":         1,
+	}
+	for event, n := range expected {
+		if obs.counts[event] != n {
+			t.Fatalf("expected %s count %d, got %d", event, n, obs.counts[event])
+		}
+
+		for _, d := range obs.events[event] {
+			if d <= 0 || d > time.Minute {
+				t.Fatalf("implausible %s duration %s", event, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// orphanMaxAge is how old a generated database must be to be considered orphaned
+const orphanMaxAge = time.Hour
+
+// generatedDBName matches names generated by randomDBName with the default prefix, with the creation time in unix nano
+var generatedDBName = regexp.MustCompile(`^dbctl_(\d+)(_[0-9a-f]+)?$`)
+
+// cleanOrphans drops databases left behind by previous runs, failures are logged as they must not prevent starting
+func (p *Postgres) cleanOrphans(ctx context.Context) {
+	names, err := p.ListDatabases(ctx)
+	if err != nil {
+		p.log(logger.LevelWarn, "clean_orphans_failed", fmt.Sprintf("list orphan databases failed: %s", err), fields{"error": err.Error()})
+		return
+	}
+
+	for _, name := range orphanedDatabases(names, p.clock.Now(), orphanMaxAge) {
+		if err := p.DropDB(ctx, p.withName(name).URI(), false); err != nil {
+			p.log(logger.LevelWarn, "clean_orphans_failed", fmt.Sprintf("drop orphan database %s failed: %s", name, err),
+				fields{"database": name, "error": err.Error()})
+			continue
+		}
+		p.log(logger.LevelInfo, "orphan_dropped", fmt.Sprintf("Dropped orphan database %s", name), fields{"database": name})
+	}
+}
+
+// orphanedDatabases returns the generated database names created more than maxAge before now,
+// other databases like the templates never match
+func orphanedDatabases(names []string, now time.Time, maxAge time.Duration) []string {
+	var out []string
+	for _, name := range names {
+		if name == DefaultTemplate {
+			continue
+		}
+
+		m := generatedDBName.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if now.Sub(time.Unix(0, nanos)) > maxAge {
+			out = append(out, name)
+		}
+	}
+	return out
+}
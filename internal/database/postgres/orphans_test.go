@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrphanedDatabases(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	stale := fmt.Sprintf("dbctl_%d_0ede0891248e", now.Add(-2*time.Hour).UnixNano())
+	legacy := fmt.Sprintf("dbctl_%d", now.Add(-3*time.Hour).UnixNano())
+	recent := fmt.Sprintf("dbctl_%d_0ede0891248e", now.Add(-time.Minute).UnixNano())
+
+	names := []string{stale, legacy, recent, DefaultTemplate, "billing_1_abc", "app"}
+	if got := orphanedDatabases(names, now, time.Hour); !reflect.DeepEqual(got, []string{stale, legacy}) {
+		t.Fatalf("expected stale databases %v, got %v", []string{stale, legacy}, got)
+	}
+}
+
+func TestCleanOrphans(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	stale := []string{
+		fmt.Sprintf("dbctl_%d_0ede0891248e", now.Add(-2*time.Hour).UnixNano()),
+		fmt.Sprintf("dbctl_%d_1ede0891248e", now.Add(-24*time.Hour).UnixNano()),
+	}
+	recent := fmt.Sprintf("dbctl_%d_2ede0891248e", now.Add(-time.Minute).UnixNano())
+
+	for _, name := range append([]string{DefaultTemplate, recent}, stale...) {
+		if err := applySQLStatement(ctx, db.URI(), fmt.Sprintf("create database %q", name)); err != nil {
+			t.Fatalf("create database %s failed %s", name, err)
+		}
+	}
+
+	db.cleanOrphans(ctx)
+
+	names, err := db.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed %s", err)
+	}
+
+	if !reflect.DeepEqual(names, []string{recent}) {
+		t.Fatalf("expected only the recent database to be kept, got %v", names)
+	}
+
+	// the default template is not listed, so check it still exists directly
+	if n := countRows(t, db.URI(), fmt.Sprintf("pg_database where datname = '%s'", DefaultTemplate)); n != 1 {
+		t.Fatal("expected the template database to be kept")
+	}
+}
@@ -3,22 +3,29 @@ package pg
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
 	"net"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mirzakhany/dbctl/internal/logger"
 	"github.com/mirzakhany/dbctl/internal/utils"
 
 	// golang postgres driver
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/mirzakhany/dbctl/internal/container"
 	"github.com/mirzakhany/dbctl/internal/database"
 )
@@ -30,6 +37,28 @@ var (
 	errDatabaseNotExists = errors.New("database does not exist")
 )
 
+const (
+	// maxCreateAttempts is the number of times CreateDB tries to find a free database name
+	maxCreateAttempts = 3
+	// maxDropAttempts is the number of times DropDB terminates connections and drops a database in use
+	maxDropAttempts = 5
+	// dropRetryDelay is the delay between drop attempts
+	dropRetryDelay = 100 * time.Millisecond
+	// dropDBTimeout bounds DropDB and RemoveDB, including connecting to the server
+	dropDBTimeout = 30 * time.Second
+
+	defaultStartupTimeout  = 20 * time.Second
+	defaultPullTimeout     = 10 * time.Minute
+	defaultShutdownTimeout = 10 * time.Second
+	defaultPollInterval    = 100 * time.Millisecond
+	// maxPollInterval caps the WaitForStart backoff
+	maxPollInterval = 2 * time.Second
+	// removeTimeout bounds waiting for Stop to see the containers removed
+	removeTimeout = 30 * time.Second
+	// removeGrace is the time removing containers may take on shutdown once they are stopped
+	removeGrace = 5 * time.Second
+)
+
 const (
 	// DefaultPort is the default port for postgres
 	DefaultPort = 15432
@@ -39,25 +68,68 @@ const (
 	DefaultPass = "postgres"
 	// DefaultName is the default database name for postgres
 	DefaultName = "postgres"
+	// DefaultAppName is the default application_name of connections opened by dbctl
+	DefaultAppName = "dbctl"
 	// DefaultTemplate is the default template name for postgres when creating a new database with migtations and fixtures
 	DefaultTemplate = "dbctl_template"
+
+	// walArchivePath is where the wal archive directory is mounted inside the container
+	walArchivePath = "/var/lib/postgresql/wal_archive"
+	// defaultDBPrefix is the prefix of generated database names
+	defaultDBPrefix = "dbctl"
+	// maxDBPrefixLen keeps generated names in postgres identifier limit, the random suffix takes 33 bytes
+	maxDBPrefixLen = 30
+	// defaultNamePrefix is the prefix of container names
+	defaultNamePrefix = "dbctl_pg"
+
+	// socketPath is the postgres unix socket directory inside the container
+	socketPath = "/var/run/postgresql"
+	// dataPath is the postgres data directory inside the container
+	dataPath = "/var/lib/postgresql/data"
+	// initScriptsPath is the directory the entrypoint of the image runs init scripts from
+	initScriptsPath = "/docker-entrypoint-initdb.d"
 )
 
 // Postgres is a postgres database instance
 type Postgres struct {
 	containerID string
-	cfg         config
+	// containerName is the name of the started or reused container, the host of NetworkURI
+	containerName string
+	cfg           config
+
+	// replicaID and replicaPort are the container and host port of the read replica once started
+	replicaID   string
+	replicaPort uint32
+	// reused is set when Start attached to a running container, see WithReuse
+	reused bool
+
+	runner runner
+	clock  clock
+
+	started     chan struct{}
+	startedOnce sync.Once
 }
 
 // New creates a new postgres database instance controller
 func New(options ...Option) (*Postgres, error) {
 	// create postgres with default values
-	pg := &Postgres{cfg: config{
+	pg := &Postgres{runner: dockerRunner{}, clock: realClock{}, started: make(chan struct{}), cfg: config{
 		pass:    DefaultPass,
 		user:    DefaultUser,
 		name:    DefaultName,
 		port:    DefaultPort,
 		version: "14.3.0",
+
+		appName: DefaultAppName,
+
+		startupTimeout:  defaultStartupTimeout,
+		pullTimeout:     defaultPullTimeout,
+		shutdownTimeout: defaultShutdownTimeout,
+		pollInterval:    defaultPollInterval,
+
+		namePrefix: defaultNamePrefix,
+
+		autoTemplate: true,
 	}}
 
 	for _, o := range options {
@@ -66,11 +138,28 @@ func New(options ...Option) (*Postgres, error) {
 		}
 	}
 
+	if err := pg.cfg.resolveFixtureProfile(); err != nil {
+		return nil, err
+	}
+
+	if err := pg.cfg.validateVersion(); err != nil {
+		return nil, err
+	}
+
+	if err := pg.cfg.validateExternal(); err != nil {
+		return nil, err
+	}
+
+	if err := pg.cfg.validateGoMigrations(); err != nil {
+		return nil, err
+	}
+
 	return pg, nil
 }
 
 // CreateDB creates a new database with given migrations and fixtures
 func (p *Postgres) CreateDB(ctx context.Context, req *database.CreateDBRequest) (*database.CreateDBResponse, error) {
+	start := time.Now()
 	// connect to default database
 	conn, err := dbConnect(ctx, p.URI())
 	if err != nil {
@@ -80,122 +169,891 @@ func (p *Postgres) CreateDB(ctx context.Context, req *database.CreateDBRequest)
 		_ = conn.Close()
 	}()
 
-	// create a random name for new database
-	dbName := fmt.Sprintf("dbctl_%d", time.Now().UnixNano())
-	newDB, _ := New(WithHost(p.cfg.user, p.cfg.pass, dbName, p.cfg.port))
-	newURI := newDB.URI()
-
-	if req.WithDefaultMigrations {
-		if err = p.createDatabaseWithTemplate(ctx, conn, dbName, DefaultTemplate); err != nil {
-			if errors.Is(err, errDatabaseNotExists) {
-				return nil, fmt.Errorf("default database not found, please create it first: %w", err)
-			}
+	dbName, err := p.createDatabaseForRequest(ctx, conn, req)
+	if err != nil {
+		if dbName != "" {
+			p.abortCreate(dbName)
 		}
+		return nil, err
+	}
 
-		// run apply fixtures if exist
-		if len(req.Fixtures) != 0 {
-			if err := applyFixturesFromDir(ctx, conn, req.Fixtures, newURI); err != nil {
-				return nil, err
-			}
+	// fixtures belong to the request, so they are applied even if the database is cloned from a template
+	newDB := p.withName(dbName)
+	fixtures, err := p.applyFixturesFromDir(ctx, req.Fixtures, newDB.URI())
+	if err != nil {
+		p.abortCreate(dbName)
+		return nil, err
+	}
+	if err := runAfterCreate(ctx, req, newDB.URI()); err != nil {
+		p.abortCreate(dbName)
+		return nil, err
+	}
+
+	migrations, err := appliedMigrations(ctx, newDB.URI())
+	if err != nil {
+		p.abortCreate(dbName)
+		return nil, err
+	}
+	p.observer().DBCreated(dbName, time.Since(start))
+
+	res := newDB.createDBResponse()
+	res.AppliedMigrations, res.AppliedFixtures = migrations, fixtures
+	return res, nil
+}
+
+// runAfterCreate calls the AfterCreate callback of the request, if any, with a connection to the database at uri
+func runAfterCreate(ctx context.Context, req *database.CreateDBRequest, uri string) error {
+	if req.AfterCreate == nil {
+		return nil
+	}
+
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := req.AfterCreate(ctx, conn); err != nil {
+		return fmt.Errorf("after create callback failed: %w", err)
+	}
+	return nil
+}
+
+// createDatabaseForRequest creates a database as requested without applying fixtures and returns its name,
+// the name is also returned if the database was created but migrating it failed
+func (p *Postgres) createDatabaseForRequest(ctx context.Context, conn *sql.DB, req *database.CreateDBRequest) (string, error) {
+	var dbName string
+	var err error
+	switch {
+	case req.SkipTemplate:
+		dbName, err = p.createDatabaseFromScratch(ctx, conn, req)
+	case req.WithDefaultMigrations || req.Template != "":
+		template := DefaultTemplate
+		if req.Template != "" {
+			template = req.Template
 		}
+		dbName, err = p.createWithUniqueName(req.Prefix, func(name string) error {
+			return p.clone(ctx, conn, template, name)
+		})
+		if errors.Is(err, errDatabaseNotExists) {
+			return "", fmt.Errorf("template database %q not found, please create it first: %w", template, err)
+		}
+	case len(req.Migrations) == 0:
+		// if no migrations provided, just create a new database
+		p.log(logger.LevelDebug, "create_database", "No migrations provided, creating a new database ...", nil)
+		dbName, err = p.createWithUniqueName(req.Prefix, func(name string) error {
+			return createDatabase(ctx, conn, name, p.cfg.encoding)
+		})
+		if err == nil {
+			err = p.createExtensions(ctx, p.withName(dbName).URI())
+		}
+	default:
+		dbName, err = p.createDatabaseWithMigrations(ctx, conn, req.Prefix, req.Migrations)
+	}
+	return dbName, err
+}
 
-		//retun new database uri
-		return &database.CreateDBResponse{URI: newURI}, nil
+// CreateTemplate creates a template database with the given name, by applying migrations and fixtures
+// on a fresh database. Databases can be cloned from it by setting CreateDBRequest.Template.
+func (p *Postgres) CreateTemplate(ctx context.Context, name, migrations, fixtures string) error {
+	migrationFiles, err := GetFiles(migrations)
+	if err != nil {
+		return fmt.Errorf("read migraions failed: %w", err)
 	}
 
-	// if no migrations provided, just create a new database
-	if len(req.Migrations) == 0 {
-		logger.Debug("No migrations provided, creating a new database ...")
-		if err := createDatabase(ctx, conn, dbName); err != nil {
-			return nil, err
+	fixtureFiles, err := GetFiles(fixtures)
+	if err != nil {
+		return fmt.Errorf("read fixtures failed: %w", err)
+	}
+
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := createDatabase(ctx, conn, name, p.cfg.encoding); err != nil {
+		return err
+	}
+
+	uri := p.withName(name).URI()
+	if err := p.createExtensions(ctx, uri); err != nil {
+		return err
+	}
+	if err := p.runMigrations(ctx, migrationFiles, uri); err != nil {
+		return err
+	}
+
+	return p.applyFixtures(ctx, fixtureFiles, uri)
+}
+
+// createDatabaseFromScratch creates a plain new database and runs the request migrations on it,
+// or the instance migrations if default migrations are requested
+func (p *Postgres) createDatabaseFromScratch(ctx context.Context, conn *sql.DB, req *database.CreateDBRequest) (string, error) {
+	p.log(logger.LevelDebug, "create_database", "Skipping templates, creating a new database from scratch ...", nil)
+	migrationFiles, migrators := p.cfg.migrationsFiles, p.cfg.goMigrations
+	if !req.WithDefaultMigrations {
+		files, err := GetFiles(req.Migrations)
+		if err != nil {
+			return "", fmt.Errorf("read migraions failed: %w", err)
 		}
-		return &database.CreateDBResponse{URI: newURI}, nil
+		migrationFiles, migrators = files, nil
+	}
+
+	dbName, err := p.createWithUniqueName(req.Prefix, func(name string) error {
+		return createDatabase(ctx, conn, name, p.cfg.encoding)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.createExtensions(ctx, p.withName(dbName).URI()); err != nil {
+		return dbName, err
 	}
+	return dbName, p.runMigrations(ctx, migrationFiles, p.withName(dbName).URI(), migrators...)
+}
 
-	logger.Debug("Creating a new database with migrations ...")
-	// if migrations provided, create a template database and create a new database from template
-	// new a new database with provided migrations and fixtures
-	// run migrations if exist
-	migrationFiles, err := getFiles(req.Migrations)
+// createDatabaseWithMigrations creates a new database from the template matching the given migrations,
+// the template is created on the first call by running the migrations on a fresh database
+func (p *Postgres) createDatabaseWithMigrations(ctx context.Context, conn *sql.DB, prefix, migrations string) (string, error) {
+	p.log(logger.LevelDebug, "create_database", "Creating a new database with migrations ...", nil)
+	migrationFiles, err := GetFiles(migrations)
 	if err != nil {
-		return nil, fmt.Errorf("read migraions failed: %w", err)
+		return "", fmt.Errorf("read migraions failed: %w", err)
 	}
 	templateName := utils.GetListHash(migrationFiles)
-	logger.Debug("template name is:", templateName)
+	var hash string
+	if p.cfg.autoTemplate {
+		p.log(logger.LevelDebug, "template_selected", "template name is: "+templateName, fields{"template": templateName})
 
-	// try to create database using template
-	err = p.createDatabaseWithTemplate(ctx, conn, dbName, templateName)
-	if err != nil && !errors.Is(err, errDatabaseNotExists) {
-		logger.Debug("create database with template failed, trying to create a new database ...")
-		return nil, err
+		// the template is named after the file names, edited migrations make it stale
+		if hash, err = migrationsHash(migrationFiles); err != nil {
+			return "", err
+		}
+		if _, err := p.dropStaleTemplate(ctx, conn, templateName, hash); err != nil {
+			return "", err
+		}
+
+		// try to create database using template
+		dbName, err := p.createWithUniqueName(prefix, func(name string) error {
+			return p.clone(ctx, conn, templateName, name)
+		})
+		if err == nil || !errors.Is(err, errDatabaseNotExists) {
+			return dbName, err
+		}
+		p.log(logger.LevelDebug, "template_not_found", "template database not found, creating a new database ...", fields{"template": templateName})
 	}
 
-	if errors.Is(err, errDatabaseNotExists) {
-		logger.Debug("template database not found, creating a new database ...")
-		// create database if not exist
-		if err := createDatabase(ctx, conn, dbName); err != nil {
-			return nil, err
+	dbName, err := p.createWithUniqueName(prefix, func(name string) error {
+		return createDatabase(ctx, conn, name, p.cfg.encoding)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the template is saved from this database, so the extensions are created before the migrations
+	if err := p.createExtensions(ctx, p.withName(dbName).URI()); err != nil {
+		return dbName, err
+	}
+
+	// connect to new database and run migrations
+	if err := p.runMigrations(ctx, migrationFiles, p.withName(dbName).URI()); err != nil {
+		return dbName, err
+	}
+
+	// create a template from new database, a concurrent CreateDB with the same migrations may have saved it already
+	if p.cfg.autoTemplate {
+		if err := p.saveTemplate(ctx, conn, dbName, templateName, hash); err != nil && !isDuplicateDatabase(err) {
+			return dbName, p.templateFailed(templateName, err)
 		}
+	}
+	return dbName, nil
+}
 
-		logger.Debug("template database found, creating a new database from template ...")
-		// connect to new database and run migrations
-		if err := RunMigrations(ctx, nil, migrationFiles, newURI); err != nil {
-			return nil, err
+// withName returns a postgres controller for the database with the given name on the same instance
+func (p *Postgres) withName(name string) *Postgres {
+	cfg := p.cfg
+	cfg.name = name
+	return &Postgres{containerID: p.containerID, cfg: cfg, runner: p.runner, clock: p.clock, started: make(chan struct{})}
+}
+
+// createWithUniqueName calls create with a random database name starting with prefix, and retries with
+// a new name in the unlikely case of the name being already taken
+func (p *Postgres) createWithUniqueName(prefix string, create func(name string) error) (string, error) {
+	var err error
+	for i := 0; i < maxCreateAttempts; i++ {
+		var name string
+		name, err = randomDBName(prefix)
+		if err != nil {
+			return "", err
+		}
+
+		if err = create(name); err == nil {
+			return name, nil
 		}
 
-		// create a template from new database
-		_ = p.createDatabaseWithTemplate(ctx, conn, templateName, dbName)
+		if !isDuplicateDatabase(err) {
+			return "", err
+		}
+		p.log(logger.LevelDebug, "database_name_taken", fmt.Sprintf("database name %s is already taken, retrying with a new name ...", name),
+			fields{"database": name})
 	}
+	return "", err
+}
 
-	if len(req.Fixtures) != 0 {
-		if err := applyFixturesFromDir(ctx, nil, req.Fixtures, newURI); err != nil {
-			return nil, err
+// randomDBName generates a database name in form of <prefix>_<unix nano>_<random hex>
+func randomDBName(prefix string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate database name failed: %w", err)
+	}
+	return fmt.Sprintf("%s_%d_%x", sanitizePrefix(prefix), time.Now().UnixNano(), b), nil
+}
+
+// sanitizePrefix makes prefix a valid unquoted identifier, by lower casing it and replacing invalid characters
+// with underscore. It is truncated so the generated name fits in postgres identifier limit of 63 bytes.
+func sanitizePrefix(prefix string) string {
+	if prefix == "" {
+		return defaultDBPrefix
+	}
+
+	b := []byte(strings.ToLower(prefix))
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			b[i] = '_'
 		}
 	}
 
+	if b[0] >= '0' && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+
+	if len(b) > maxDBPrefixLen {
+		b = b[:maxDBPrefixLen]
+	}
+	return string(b)
+}
+
+func isDuplicateDatabase(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P04"
+}
+
+// createDBResponse builds the CreateDB response for the database p is pointing to
+func (p *Postgres) createDBResponse() *database.CreateDBResponse {
+	uri, host := p.URI(), p.host()
+	if p.cfg.unixSocketDir != "" {
+		host = p.cfg.unixSocketDir
+	}
 	// make sure we retrun localhost instead of host.docker.internal
 	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
-		newURI = strings.ReplaceAll(newURI, "host.docker.internal", "localhost")
+		uri = strings.ReplaceAll(uri, "host.docker.internal", "localhost")
+		host = "localhost"
+	}
+
+	return &database.CreateDBResponse{
+		URI:      uri,
+		Host:     host,
+		Port:     p.cfg.port,
+		User:     p.cfg.user,
+		Password: p.cfg.pass,
+		Database: p.cfg.name,
 	}
+}
+
+// CreateDBStream creates n databases concurrently and emits each result as soon as its database is ready.
+// The returned channel is closed once all databases are created or ctx is cancelled.
+func (p *Postgres) CreateDBStream(ctx context.Context, req *database.CreateDBRequest, n int) (<-chan database.CreateDBResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of databases must be positive, got %d", n)
+	}
+
+	out := make(chan database.CreateDBResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := p.CreateDB(ctx, req)
+			select {
+			case out <- database.CreateDBResult{Response: res, Err: err}:
+			case <-ctx.Done():
+				// nobody is listening anymore, remove the database we just created
+				if res != nil {
+					cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					_ = p.RemoveDB(cleanupCtx, res.URI)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// Clone creates the target database as a copy of the source database. Postgres can't copy a database
+// while it has connections, so all connections to the source are terminated first.
+func (p *Postgres) Clone(ctx context.Context, source, target string) error {
+	return p.clone(ctx, nil, source, target)
+}
+
+func (p *Postgres) clone(ctx context.Context, conn *sql.DB, source, target string) error {
+	if conn == nil {
+		var err error
+		conn, err = dbConnect(ctx, p.URI())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+	}
+
+	stmt, args := terminateBackendsQuery(source, p.cfg.appName, true)
+	if _, err := conn.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("terminate source database connections failed: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("create database %s with template %s", pq.QuoteIdentifier(target), pq.QuoteIdentifier(source))); err != nil {
+		// is error database not exist?
+		if strings.Contains(err.Error(), "does not exist") {
+			return errDatabaseNotExists
+		}
+		return fmt.Errorf("create database with template failed: %w", err)
+	}
+	return nil
+}
+
+// RemoveDB removes a database from postgres by given uri, see DropDB for how connections are handled
+func (p *Postgres) RemoveDB(ctx context.Context, uri string) error {
+	return p.DropDB(ctx, uri, false)
+}
+
+// DropDB drops a database by its uri. Only connections opened by dbctl, identified by application_name,
+// are terminated before dropping, so dropping fails while other clients like a psql session are connected.
+// If force is set all connections to the database get terminated.
+func (p *Postgres) DropDB(ctx context.Context, uri string, force bool) error {
+	// parse the uri to get database name
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	// get database name
+	dbName := strings.TrimPrefix(u.Path, "/")
+
+	// an unreachable server must not block the caller, like a test cleanup without a deadline
+	ctx, cancel := context.WithTimeout(ctx, dropDBTimeout)
+	defer cancel()
+
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for attempt := 1; ; attempt++ {
+		// terminate connections
+		stmt, args := terminateBackendsQuery(dbName, p.cfg.appName, force)
+		if _, err := conn.ExecContext(ctx, stmt, args...); err != nil {
+			return fmt.Errorf("terminate database connections failed: %w", err)
+		}
+
+		_, err = conn.ExecContext(ctx, "drop database if exists "+pq.QuoteIdentifier(dbName))
+		// a client may connect between terminating and dropping, like a pool replacing a terminated connection
+		if err == nil || !isDatabaseInUse(err) || attempt == maxDropAttempts {
+			break
+		}
+
+		select {
+		case <-p.clock.After(dropRetryDelay):
+		case <-ctx.Done():
+			return fmt.Errorf("drop database failed: %w", ctx.Err())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("drop database failed: %w", err)
+	}
+
+	return nil
+}
+
+// isDatabaseInUse reports if err is postgres refusing to drop a database with connections
+func isDatabaseInUse(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "55006"
+}
+
+// terminateBackendsQuery returns the query terminating connections to the database,
+// only the ones with the given application name unless force is set
+func terminateBackendsQuery(dbName, appName string, force bool) (string, []any) {
+	stmt := "select pg_terminate_backend(pid) from pg_stat_activity where datname = $1 and pid <> pg_backend_pid()"
+	if force {
+		return stmt, []any{dbName}
+	}
+	return stmt + " and application_name = $2", []any{dbName, appName}
+}
+
+// ListDatabases returns the names of databases in the instance sorted by name, like the ones made by CreateDB.
+// Template and system databases, the instance default database and the templates saved by dbctl are not included.
+func (p *Postgres) ListDatabases(ctx context.Context) ([]string, error) {
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// the templates of dbctl are plain databases, they are told apart by the migrations hash in their comment
+	rows, err := conn.QueryContext(ctx, `select datname from pg_database
+		where not datistemplate and datname not in ('postgres', 'template0', 'template1', $1, $2)
+		and left(coalesce(shobj_description(oid, 'pg_database'), ''), length($3)) <> $3
+		order by datname`, p.cfg.name, DefaultTemplate, templateHashPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list databases failed: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("list databases failed: %w", err)
+		}
+		out = append(out, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list databases failed: %w", err)
+	}
+	return out, nil
+}
+
+// CreateRestorePoint creates a named restore point on the database with the given uri,
+// to be used as recovery target when testing point-in-time recovery.
+// If WAL archiving is enabled, the current WAL segment is switched so the restore point gets archived.
+func (p *Postgres) CreateRestorePoint(ctx context.Context, uri, name string) error {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(ctx, "select pg_create_restore_point($1)", name); err != nil {
+		return fmt.Errorf("create restore point failed: %w", err)
+	}
+
+	if p.cfg.walArchiveDir != "" {
+		if _, err := conn.ExecContext(ctx, "select pg_switch_wal()"); err != nil {
+			return fmt.Errorf("switch wal failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Start starts a postgres database, failures are returned as StartError telling the failed phase
+func (p *Postgres) Start(ctx context.Context, detach bool) error {
+	ctx, stop := p.signalContext(ctx)
+	defer stop()
+
+	start := time.Now()
+	p.log(logger.LevelInfo, "starting", fmt.Sprintf("Starting postgres version %s on port %d ...", p.cfg.version, p.cfg.port),
+		fields{"version": p.cfg.version, "port": p.cfg.port})
+
+	// broken migrations should fail before the slow container boot
+	if !p.cfg.skipMigrations {
+		if err := ValidateMigrations(p.cfg.migrationsFiles); err != nil {
+			return phaseError(PhaseMigrations, err)
+		}
+	}
+
+	if p.cfg.fixturesChecksum != "" && !p.cfg.skipFixtures {
+		if err := verifyFixturesChecksum(p.cfg.fixtureFiles, p.cfg.fixturesChecksum); err != nil {
+			return phaseError(PhaseFixtures, err)
+		}
+	}
+
+	if p.cfg.dryRun {
+		return p.logPlan()
+	}
+
+	// only the postgres container stops itself when idle, the others would be left running
+	if detach && p.cfg.idleTimeout > 0 && (p.cfg.replica || p.cfg.uiBackend != UINone) {
+		return phaseError(PhaseContainer, fmt.Errorf("idle timeout of a detached database can not be used with a replica or ui"))
+	}
+
+	// an external server is not managed by dbctl, so docker is not needed
+	external := p.cfg.externalHost != ""
+	if !external {
+		// fail early with a clear error instead of a low level one from container creation
+		if err := p.runner.Ping(ctx); err != nil {
+			return phaseError(PhaseContainer, err)
+		}
+	}
+
+	// must be checked before starting the container, as the container initializes an empty data directory
+	initialized := hasCluster(p.cfg.dataDir)
+
+	var attached bool
+	if p.cfg.reuse {
+		var err error
+		if attached, err = p.attach(ctx); err != nil {
+			return phaseError(PhaseContainer, err)
+		}
+	}
+
+	if !attached && !external && p.cfg.instanceName != "" {
+		if err := p.checkInstanceName(ctx); err != nil {
+			return phaseError(PhaseContainer, err)
+		}
+	}
+
+	var closeFunc database.CloseFunc
+	var err error
+	if attached || external {
+		// the container is removed by the process which started it, an external server is never removed
+		closeFunc = func(context.Context) error { return nil }
+		if err := p.WaitForStart(ctx, p.cfg.startupTimeout); err != nil {
+			return phaseError(PhaseWait, err)
+		}
+	} else {
+		containerStart := time.Now()
+		closeFunc, err = p.startUsingDocker(ctx, p.cfg.startupTimeout, detach)
+		if err != nil {
+			if closeFunc != nil {
+				p.abortStart(closeFunc)
+			}
+			return err
+		}
+		p.observer().ContainerStarted(time.Since(containerStart))
+	}
+
+	p.log(logger.LevelInfo, "started", "Postgres is up and running",
+		fields{"version": p.cfg.version, "port": p.cfg.port, "container_id": p.containerID, "duration_ms": durationMs(start)})
+	if p.cfg.cleanOrphans {
+		p.cleanOrphans(ctx)
+	}
+
+	if attached {
+		p.log(logger.LevelInfo, "reused_container", fmt.Sprintf("Reusing running container %s, skipping migrations and fixtures", p.containerID),
+			fields{"container_id": p.containerID})
+	} else if initialized {
+		p.log(logger.LevelInfo, "existing_cluster", fmt.Sprintf("Using existing cluster in %q, skipping migrations", p.cfg.dataDir),
+			fields{"data_dir": p.cfg.dataDir})
+		// re-seed the persistent database, fixtures go with migrations unless migrations are skipped
+		if p.cfg.skipMigrations && p.cfg.seedsOnStart() {
+			if err := p.Seed(ctx); err != nil {
+				p.abortStart(closeFunc)
+				return phaseError(PhaseFixtures, err)
+			}
+			if err := p.checkAssertions(ctx); err != nil {
+				p.abortStart(closeFunc)
+				return phaseError(PhaseAssertions, err)
+			}
+		}
+	} else if err := p.setup(ctx); err != nil {
+		p.abortStart(closeFunc)
+		return err
+	}
+
+	var replicaCloseFunc database.CloseFunc
+	if p.cfg.replica && !attached {
+		replicaCloseFunc, err = p.startReplica(ctx)
+		if err != nil {
+			p.abortStart(replicaCloseFunc, closeFunc)
+			return phaseError(PhaseReplica, err)
+		}
+	}
+
+	// print connection url
+	p.log(logger.LevelInfo, "ready", fmt.Sprintf("Database uri is: %q", p.URI()),
+		fields{"uri": p.URI(), "port": p.cfg.port, "duration_ms": durationMs(start)})
+	p.markStarted()
+
+	var uiCloseFunc database.CloseFunc
+	// the ui of a reused container is started by the process which started it
+	if p.cfg.uiBackend != UINone && !attached {
+		uiCloseFunc, err = p.runUI(ctx)
+		if err != nil {
+			p.abortStart(replicaCloseFunc, closeFunc)
+			return phaseError(PhaseUI, err)
+		}
+	}
+
+	shutdown := func(ctx context.Context) error {
+		// TODO we need a better solution to manage containers and make sure we remove all of them.
+		if uiCloseFunc != nil {
+			if err := uiCloseFunc(ctx); err != nil {
+				return err
+			}
+		}
+		if replicaCloseFunc != nil {
+			if err := replicaCloseFunc(ctx); err != nil {
+				return err
+			}
+		}
+		return closeFunc(ctx)
+	}
+
+	// detach and stop cli if asked, the container of a detached database watches its idle time itself
+	if detach {
+		return nil
+	}
+
+	idle := p.watchIdle(ctx, shutdown)
+
+	select {
+	case <-ctx.Done():
+	case err := <-idle:
+		return err
+	}
+	p.log(logger.LevelInfo, "stopping", "Shutdown signal received, stopping database", fields{"container_id": p.containerID})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), p.shutdownContextTimeout())
+	defer func() {
+		cancel()
+	}()
+
+	return shutdown(shutdownCtx)
+}
+
+// attach looks for a running postgres container started by dbctl with the same version, port, labels and
+// settings, and uses it instead of starting a new one. It reports whether a container was found.
+func (p *Postgres) attach(ctx context.Context) (bool, error) {
+	req, err := buildCreateRequest(p.cfg)
+	if err != nil {
+		return false, err
+	}
+
+	containers, err := p.runner.List(ctx, req.Labels)
+	if err != nil {
+		return false, fmt.Errorf("list postgres containers failed: %w", err)
+	}
+
+	for _, c := range containers {
+		if database.StatusFromState(c.State) != database.Running {
+			continue
+		}
+
+		p.containerID, p.containerName = c.ID, strings.TrimPrefix(c.Name, "/")
+		p.reused = true
+		if p.cfg.autoPort {
+			if err := p.resolvePort(ctx); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// abortStart removes the containers of a failed Start. With keep on failure they are left running
+// and the connection uri and container ids are logged instead, so the database can be inspected.
+func (p *Postgres) abortStart(closeFuncs ...database.CloseFunc) {
+	if p.cfg.keepOnFailure {
+		p.log(logger.LevelWarn, "kept_on_failure", fmt.Sprintf("Start failed, keeping container %s for inspection, database uri is: %q", p.containerID, p.URI()),
+			fields{"container_id": p.containerID, "uri": p.URI(), "replica_container_id": p.replicaID})
+		return
+	}
+
+	// the start context may be the reason of the failure
+	ctx, cancel := context.WithTimeout(context.Background(), p.shutdownContextTimeout())
+	defer cancel()
+
+	p.replicaID = ""
+	for _, closeFunc := range closeFuncs {
+		if closeFunc == nil {
+			continue
+		}
+		if err := closeFunc(ctx); err != nil {
+			p.log(logger.LevelWarn, "container_cleanup_failed", fmt.Sprintf("remove container of failed start failed: %s", err),
+				fields{"error": err.Error()})
+		}
+	}
+}
+
+// abortCreate drops a database of a failed CreateDB, with keep on failure it is left for inspection
+func (p *Postgres) abortCreate(name string) {
+	uri := p.withName(name).URI()
+	if p.cfg.keepOnFailure {
+		p.log(logger.LevelWarn, "kept_on_failure", fmt.Sprintf("CreateDB failed, keeping database for inspection, database uri is: %q", uri),
+			fields{"database": name, "uri": uri})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.DropDB(ctx, uri, true); err != nil {
+		p.log(logger.LevelWarn, "database_cleanup_failed", fmt.Sprintf("drop database of failed create failed: %s", err),
+			fields{"database": name, "error": err.Error()})
+	}
+}
+
+// signalContext returns ctx canceled on exit signals if signal handling is enabled, ctx itself otherwise
+func (p *Postgres) signalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !p.cfg.signalHandling {
+		return ctx, func() {}
+	}
+	return signal.NotifyContext(ctx, utils.DefaultExistSignals...)
+}
+
+// Started returns a channel which is closed once the database is started and ready to use,
+// that is after it accepts queries and migrations and fixtures are applied
+func (p *Postgres) Started() <-chan struct{} {
+	return p.started
+}
+
+// markStarted closes the started channel and calls the ready callback, only on the first call
+func (p *Postgres) markStarted() {
+	p.startedOnce.Do(func() {
+		close(p.started)
+		if p.cfg.readyCallback != nil {
+			p.cfg.readyCallback(p.URI())
+		}
+	})
+}
+
+// logPlan logs what Start would do, the container request and the sql files in the order they are applied
+func (p *Postgres) logPlan() error {
+	req, err := buildCreateRequest(p.cfg)
+	if err != nil {
+		return err
+	}
+
+	cfg := p.Config()
+	p.log(logger.LevelInfo, "dry_run_config", fmt.Sprintf("Dry run, config: %+v", cfg), fields{"config": cfg})
+	p.log(logger.LevelInfo, "dry_run_container", fmt.Sprintf("Dry run, container: image=%s ports=%v cmd=%v binds=%v labels=%v",
+		req.Image, req.ExposedPorts, req.Cmd, req.Binds, req.Labels),
+		fields{"image": req.Image, "ports": req.ExposedPorts, "cmd": req.Cmd, "binds": req.Binds, "labels": req.Labels, "env": req.Env})
+	var migrations []string
+	if !p.cfg.skipMigrations {
+		migrations = p.cfg.migrationsFiles
+	}
+	p.log(logger.LevelInfo, "dry_run_migrations", fmt.Sprintf("Dry run, migrations: %v", migrations),
+		fields{"files": migrations})
+	var fixtures []string
+	if p.cfg.seedsOnStart() {
+		fixtures = p.cfg.fixtureFiles
+	}
+	p.log(logger.LevelInfo, "dry_run_fixtures", fmt.Sprintf("Dry run, fixtures: %v", fixtures),
+		fields{"files": fixtures})
+	return nil
+}
+
+// saveDefaultTemplate saves the migrated database as DefaultTemplate, a template left by a previous Start with
+// other migrations, like on an external postgres, is rebuilt
+func (p *Postgres) saveDefaultTemplate(ctx context.Context) error {
+	hash, err := migrationsHash(p.cfg.migrationsFiles, p.cfg.goMigrations...)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	return p.saveTemplate(ctx, conn, p.cfg.name, DefaultTemplate, hash)
+}
+
+// setup runs migrations and fixtures on a freshly started database
+func (p *Postgres) setup(ctx context.Context) error {
+	if err := p.createExtensions(ctx, p.URI()); err != nil {
+		return phaseError(PhaseSetup, err)
+	}
+
+	if err := p.createAppUser(ctx, p.URI()); err != nil {
+		return phaseError(PhaseSetup, err)
+	}
+
+	if err := p.runInitSQL(ctx, p.URI()); err != nil {
+		return phaseError(PhaseSetup, err)
+	}
+
+	if !p.cfg.skipMigrations {
+		if err := p.Migrate(ctx); err != nil {
+			return phaseError(PhaseMigrations, err)
+		}
+	}
+
+	if p.cfg.seedsOnStart() {
+		if err := p.Seed(ctx); err != nil {
+			return phaseError(PhaseFixtures, err)
+		}
+	}
+	return phaseError(PhaseAssertions, p.checkAssertions(ctx))
+}
+
+// seedsOnStart reports if Start applies fixtures. They are applied together with migrations, or on their
+// own when migrations are skipped
+func (c config) seedsOnStart() bool {
+	return !c.skipFixtures && (c.hasMigrations() || c.skipMigrations)
+}
 
-	return &database.CreateDBResponse{URI: newURI}, nil
+// hasMigrations reports if migration files or go migrations are configured
+func (c config) hasMigrations() bool {
+	return len(c.migrationsFiles) > 0 || len(c.goMigrations) > 0
 }
 
-func (p *Postgres) createDatabaseWithTemplate(ctx context.Context, conn *sql.DB, name, template string) error {
-	if conn == nil {
-		var err error
-		conn, err = dbConnect(ctx, p.URI())
-		if err != nil {
-			return err
-		}
-		defer func() {
-			_ = conn.Close()
-		}()
+// Migrate applies the configured migrations to the database and saves it as DefaultTemplate, like Start does
+// unless WithSkipMigrations is set
+func (p *Postgres) Migrate(ctx context.Context) error {
+	if err := p.runMigrations(ctx, p.cfg.migrationsFiles, p.URI(), p.cfg.goMigrations...); err != nil {
+		return err
 	}
 
-	// if default is exist, use it as template and create new database
-	if _, err := conn.Exec(fmt.Sprintf("create database %q with template %q", name, template)); err != nil {
-		// is error database not exist?
-		if strings.Contains(err.Error(), "does not exist") {
-			return errDatabaseNotExists
+	if p.cfg.hasMigrations() {
+		if err := p.saveDefaultTemplate(ctx); err != nil {
+			return p.templateFailed(DefaultTemplate, err)
 		}
-		return fmt.Errorf("create database with template failed: %w", err)
 	}
 	return nil
 }
 
-// RemoveDB removes a database from postgres by given uri
-func (p *Postgres) RemoveDB(ctx context.Context, uri string) error {
-	// parse the uri to get database name
-	u, err := url.Parse(uri)
-	if err != nil {
-		return err
+// templateFailed returns the error of saving a template with WithStrictTemplate, otherwise it is logged as warning
+func (p *Postgres) templateFailed(name string, err error) error {
+	if p.cfg.strictTemplate {
+		return fmt.Errorf("create template %s failed: %w", name, err)
 	}
+	p.log(logger.LevelWarn, "template_failed", fmt.Sprintf("create template %s failed: %s", name, err),
+		fields{"template": name, "error": err.Error()})
+	return nil
+}
 
-	// get database name
-	dbName := strings.TrimPrefix(u.Path, "/")
+// Seed applies the configured fixtures to the database, like Start does unless WithSkipFixtures is set
+func (p *Postgres) Seed(ctx context.Context) error {
+	return p.applyFixtures(ctx, p.cfg.fixtureFiles, p.URI())
+}
 
-	conn, err := dbConnect(ctx, p.URI())
+// createExtensions creates the configured extensions in the database of uri if they don't exist yet, it runs
+// on every new database which is not cloned, as extensions are per database
+func (p *Postgres) createExtensions(ctx context.Context, uri string) error {
+	if len(p.cfg.extensions) == 0 {
+		return nil
+	}
+
+	conn, err := dbConnect(ctx, uri)
 	if err != nil {
 		return err
 	}
@@ -203,248 +1061,675 @@ func (p *Postgres) RemoveDB(ctx context.Context, uri string) error {
 		_ = conn.Close()
 	}()
 
-	// terminate connection
-	_, _ = conn.ExecContext(ctx, fmt.Sprintf("select pg_terminate_backend(pid) from pg_stat_activity where datname = %s", dbName))
-	if _, err := conn.ExecContext(ctx, fmt.Sprintf("drop database if exists %s", dbName)); err != nil {
-		return fmt.Errorf("drop database failed: %v", err)
+	p.log(logger.LevelInfo, "creating_extensions", "Creating extensions ...", fields{"extensions": p.cfg.extensions})
+	for _, name := range p.cfg.extensions {
+		if _, err := conn.ExecContext(ctx, "create extension if not exists "+pq.QuoteIdentifier(name)); err != nil {
+			return fmt.Errorf("create extension (%s) failed: %w", name, err)
+		}
 	}
-
 	return nil
 }
 
-// Start starts a postgres database
-func (p *Postgres) Start(ctx context.Context, detach bool) error {
-	logger.Info(fmt.Sprintf("Starting postgres version %s on port %d ...", p.cfg.version, p.cfg.port))
+// createAppUser creates the unprivileged application role and its own database
+func (p *Postgres) createAppUser(ctx context.Context, uri string) error {
+	if p.cfg.appUser == "" {
+		return nil
+	}
 
-	closeFunc, err := p.startUsingDocker(ctx, 20*time.Second)
+	conn, err := dbConnect(ctx, uri)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = conn.Close()
+	}()
 
-	logger.Info("Postgres is up and running")
-	// run migrations if exist
-	if err := RunMigrations(ctx, nil, p.cfg.migrationsFiles, p.URI()); err != nil {
-		return err
+	p.log(logger.LevelInfo, "creating_app_user", fmt.Sprintf("Creating app user %q ...", p.cfg.appUser), fields{"user": p.cfg.appUser})
+	user := pq.QuoteIdentifier(p.cfg.appUser)
+	stmt := fmt.Sprintf("create role %s login nosuperuser nocreatedb nocreaterole password %s", user, pq.QuoteLiteral(p.cfg.appPass))
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create app user failed: %w", err)
 	}
 
-	// create template database if migrations exist
-	if len(p.cfg.migrationsFiles) > 0 {
-		_ = p.createDatabaseWithTemplate(ctx, nil, DefaultTemplate, p.cfg.name)
-
-		// run apply fixtures if exist
-		if err := ApplyFixtures(ctx, nil, p.cfg.fixtureFiles, p.URI()); err != nil {
-			return err
-		}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("create database %s owner %s", user, user)); err != nil {
+		return fmt.Errorf("create app user database failed: %w", err)
 	}
+	return nil
+}
 
-	// print connection url
-	logger.Info(fmt.Sprintf("Database uri is: %q", p.URI()))
-
-	var pgwebCloseFunc database.CloseFunc
-	if p.cfg.withUI {
-		pgwebCloseFunc, err = p.runUI(ctx)
-		if err != nil {
-			_ = closeFunc(ctx)
-			return err
-		}
+// AppURI returns the connection uri of the application role set by WithAppUser to its own database,
+// it returns URI if no application role is configured
+func (p *Postgres) AppURI() string {
+	if p.cfg.appUser == "" {
+		return p.URI()
 	}
 
-	// detach and stop cli if asked
-	if detach {
+	app := p.withName(p.cfg.appUser)
+	app.cfg.user, app.cfg.pass = p.cfg.appUser, p.cfg.appPass
+	return app.URI()
+}
+
+// runInitSQL executes the configured init statements
+func (p *Postgres) runInitSQL(ctx context.Context, uri string) error {
+	if len(p.cfg.initSQL) == 0 {
 		return nil
 	}
 
-	<-ctx.Done()
-	logger.Info("Shutdown signal received, stopping database")
-
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		cancel()
+		_ = conn.Close()
 	}()
 
-	// TODO we need a better solution to manage containers and make sure we remove all of them.
-	if pgwebCloseFunc != nil {
-		if err := pgwebCloseFunc(shutdownCtx); err != nil {
-			return err
+	p.log(logger.LevelInfo, "running_init_sql", "Running init statements ...", fields{"statements": len(p.cfg.initSQL)})
+	for i, stmt := range p.cfg.initSQL {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("init statement %d failed: %w", i+1, err)
 		}
 	}
+	return nil
+}
 
-	return closeFunc(shutdownCtx)
+// hasCluster reports whether dir already contains an initialized postgres cluster
+func hasCluster(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "PG_VERSION"))
+	return err == nil
 }
 
 // Stop stops a postgres database
 func (p *Postgres) Stop(ctx context.Context) error {
-	return container.TerminateByID(ctx, p.containerID)
+	// a reused container keeps running for the process which started it, and an external server is not ours
+	if p.reused || p.cfg.externalHost != "" {
+		return nil
+	}
+
+	ids := []string{p.containerID}
+	if p.replicaID != "" {
+		if err := p.terminate(ctx, p.replicaID); err != nil {
+			return err
+		}
+		ids = append(ids, p.replicaID)
+		p.replicaID = ""
+	}
+	if err := p.terminate(ctx, p.containerID); err != nil {
+		return err
+	}
+	return p.waitRemoved(ctx, ids)
 }
 
-// WaitForStart waits for postgres to start
-func (p *Postgres) WaitForStart(ctx context.Context, timeout time.Duration) error {
-	logger.Info("Wait for database to boot up")
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+// terminate stops a postgres container gracefully within the shutdown timeout and removes it, a failed
+// stop still removes the container
+func (p *Postgres) terminate(ctx context.Context, id string) error {
+	if err := p.runner.Stop(ctx, id, p.cfg.shutdownTimeout); err != nil {
+		p.log(logger.LevelWarn, "stop_failed", fmt.Sprintf("stop container gracefully failed: %s", err),
+			fields{"container_id": id, "error": err.Error()})
+	}
+	return p.runner.TerminateByID(ctx, id)
+}
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// shutdownContextTimeout is the timeout of stopping the containers on shutdown, it leaves each of postgres
+// and its replica the shutdown timeout and time to remove the containers afterwards
+func (p *Postgres) shutdownContextTimeout() time.Duration {
+	n := time.Duration(1)
+	if p.cfg.replica {
+		n = 2
+	}
+	return n*p.cfg.shutdownTimeout + removeGrace
+}
 
-	for range ticker.C {
-		conn, err := dbConnect(ctx, p.URI())
+// waitRemoved polls the containers until none of ids is listed anymore, as removing a container can be slow
+// to take effect or partially fail on some docker setups, leaking containers otherwise. Stopped containers
+// are listed too, as a failed removal can leave the container stopped.
+func (p *Postgres) waitRemoved(ctx context.Context, ids []string) error {
+	return utils.WaitFor(ctx, removeTimeout, p.cfg.pollInterval, maxPollInterval, func(ctx context.Context) error {
+		containers, err := p.runner.ListAll(ctx, nil)
 		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) {
-				return err
+			return fmt.Errorf("list containers failed: %w", err)
+		}
+		for _, c := range containers {
+			for _, id := range ids {
+				if c.ID == id {
+					return fmt.Errorf("container %s is not removed", id)
+				}
 			}
-		} else {
-			_ = conn.Close()
+		}
+		return nil
+	})
+}
+
+// WaitForStart waits for postgres to start and accept queries, once a read replica is started
+// it also waits for the replica to accept queries and stream from the primary
+func (p *Postgres) WaitForStart(ctx context.Context, timeout time.Duration) error {
+	p.log(logger.LevelInfo, "waiting", "Wait for database to boot up", fields{"timeout_ms": timeout.Milliseconds()})
+	return utils.WaitFor(ctx, timeout, p.cfg.pollInterval, maxPollInterval, func(ctx context.Context) error {
+		if err := p.ready(ctx); err != nil {
+			return err
+		}
+		if p.replicaID == "" {
 			return nil
 		}
+		return p.replicaStreaming(ctx)
+	})
+}
+
+// ready reports if postgres is ready to execute queries, a successful connection
+// is not enough as the server accepts connections while initdb is still running
+func (p *Postgres) ready(ctx context.Context) error {
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
 	}
-	return nil
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.ExecContext(ctx, "select 1")
+	return err
 }
 
 func (p *Postgres) runUI(ctx context.Context) (database.CloseFunc, error) {
-	logger.Info("Starting postgres ui using pgweb (https://github.com/sosedoff/pgweb)")
+	backend := uiBackends[p.cfg.uiBackend]
+	p.log(logger.LevelInfo, "ui_starting", "Starting postgres ui using "+backend.name, fields{"backend": string(p.cfg.uiBackend)})
 
-	var rnd, err = rand.Int(rand.Reader, big.NewInt(20))
+	req, err := buildUIRequest(p.cfg.uiBackend, p.URI())
 	if err != nil {
 		return nil, err
 	}
 
-	pgweb, err := container.Run(ctx, container.CreateRequest{
-		Image: "sosedoff/pgweb:latest",
-		Env: map[string]string{
-			// replace localhost with docker internal network
-			"PGWEB_DATABASE_URL": strings.ReplaceAll(p.URI(), "localhost", "host.docker.internal"),
-		},
-		ExposedPorts: []string{"8081:8081"},
-		Name:         fmt.Sprintf("dbctl_pgweb_%d_%d", time.Now().Unix(), rnd.Uint64()),
-		Labels:       map[string]string{container.LabelType: database.LabelPGWeb},
-	})
+	ui, err := p.runner.Run(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
 	// log ui url
-	logger.Info("Database UI is running on: http://localhost:8081")
+	uiURL := "http://localhost:" + backend.port
+	p.log(logger.LevelInfo, "ui_started", "Database UI is running on: "+uiURL, fields{"url": uiURL})
 
 	closeFunc := func(ctx context.Context) error {
-		return pgweb.Terminate(ctx)
+		return p.runner.TerminateByID(ctx, ui.ID)
 	}
 
 	return closeFunc, nil
 }
 
+// buildUIRequest returns the container create request of the ui backend connecting to the database uri
+func buildUIRequest(b UIBackend, uri string) (container.CreateRequest, error) {
+	backend, ok := uiBackends[b]
+	if !ok {
+		return container.CreateRequest{}, fmt.Errorf("unknown ui backend %q", b)
+	}
+
+	var rnd, err = rand.Int(rand.Reader, big.NewInt(20))
+	if err != nil {
+		return container.CreateRequest{}, err
+	}
+
+	env, err := backend.env(dockerInternalURI(uri))
+	if err != nil {
+		return container.CreateRequest{}, err
+	}
+
+	return container.CreateRequest{
+		Image:        backend.image,
+		Env:          env,
+		ExposedPorts: []string{fmt.Sprintf("%[1]s:%[1]s", backend.port)},
+		Name:         fmt.Sprintf("dbctl_%s_%d_%d", b, time.Now().Unix(), rnd.Uint64()),
+		Labels:       map[string]string{container.LabelType: backend.label},
+		ExtraHosts:   dockerHostMapping(runtime.GOOS),
+	}, nil
+}
+
 // Instances returns a list of postgres instances
 func Instances(ctx context.Context) ([]database.Info, error) {
-	l, err := container.List(ctx, map[string]string{container.LabelType: database.LabelPostgres})
+	return instances(ctx, dockerRunner{}, nil)
+}
+
+// InstancesWithLabels returns a list of postgres instances having all the given labels, see WithLabels
+func InstancesWithLabels(ctx context.Context, labels map[string]string) ([]database.Info, error) {
+	return instances(ctx, dockerRunner{}, labels)
+}
+
+// StopAll stops all postgres instances having the labels of this instance, set by WithLabels.
+// Without labels it stops every postgres instance managed by dbctl.
+func (p *Postgres) StopAll(ctx context.Context) error {
+	items, err := instances(ctx, p.runner, p.cfg.labels)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range items {
+		if err := p.runner.TerminateByID(ctx, i.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func instances(ctx context.Context, r runner, labels map[string]string) ([]database.Info, error) {
+	filter := map[string]string{container.LabelType: database.LabelPostgres}
+	for k, v := range labels {
+		filter[k] = v
+	}
+
+	l, err := r.List(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
 	out := make([]database.Info, 0, len(l))
 	for _, c := range l {
-		out = append(out, database.Info{
-			ID:     c.ID,
-			Type:   c.Name,
-			Status: database.Running,
-		})
+		out = append(out, containerInfo(c))
 	}
 	return out, nil
 }
 
-func (p *Postgres) startUsingDocker(ctx context.Context, timeout time.Duration) (database.CloseFunc, error) {
+// containerInfo returns the instance information of a postgres container, port and version are read from its labels.
+// Containers started with WithAutoPort have no port label, their port is the one docker published 5432 on.
+func containerInfo(c *container.Container) database.Info {
+	info := database.Info{
+		ID:      c.ID,
+		Type:    c.Name,
+		Status:  database.StatusFromState(c.State),
+		Version: c.Labels[container.LabelDBVersion],
+		Name:    c.Labels[container.LabelName],
+	}
+	if port, err := strconv.ParseUint(c.Labels[container.LabelPort], 10, 32); err == nil {
+		info.Port = uint32(port)
+		return info
+	}
+	for _, port := range c.Ports {
+		if port.PrivatePort == 5432 && port.Type == "tcp" && port.PublicPort != 0 {
+			info.Port = uint32(port.PublicPort)
+			break
+		}
+	}
+	return info
+}
+
+// PlanStart returns the container create request Start would use for the current configuration,
+// without touching docker. It can be used to validate a configuration in tests.
+func (p *Postgres) PlanStart() (container.CreateRequest, error) {
+	return buildCreateRequest(p.cfg)
+}
+
+func buildCreateRequest(cfg config) (container.CreateRequest, error) {
+	if cfg.initdbArgs != "" && hasCluster(cfg.dataDir) {
+		return container.CreateRequest{}, fmt.Errorf("initdb args can not be applied, data dir %q is already initialized", cfg.dataDir)
+	}
+
 	var rnd, err = rand.Int(rand.Reader, big.NewInt(20))
 	if err != nil {
-		return nil, err
+		return container.CreateRequest{}, err
+	}
+
+	image, err := cfg.image()
+	if err != nil {
+		return container.CreateRequest{}, err
 	}
 
-	port := strconv.Itoa(int(p.cfg.port))
+	port := strconv.Itoa(int(cfg.port))
+	if cfg.autoPort {
+		// publishing without a host port makes docker pick a free one
+		port = ""
+	}
 	req := container.CreateRequest{
-		Image: getPostGisImage(p.cfg.version),
+		Image: image,
 		Env: map[string]string{
-			"POSTGRES_PASSWORD": p.cfg.pass,
-			"POSTGRES_USER":     p.cfg.user,
-			"POSTGRES_DB":       p.cfg.name,
+			"POSTGRES_PASSWORD": cfg.pass,
+			"POSTGRES_USER":     cfg.user,
+			"POSTGRES_DB":       cfg.name,
 		},
 		Cmd:          []string{"postgres", "-c", "fsync=off", "-c", "synchronous_commit=off", "-c", "full_page_writes=off"},
 		ExposedPorts: []string{fmt.Sprintf("%s:5432/tcp", port)},
-		Name:         fmt.Sprintf("dbctl_pg_%d_%d", time.Now().Unix(), rnd.Uint64()),
+		Name:         fmt.Sprintf("%s_%d_%d", cfg.namePrefix, time.Now().Unix(), rnd.Uint64()),
 		Labels:       map[string]string{container.LabelType: database.LabelPostgres},
+		Memory:       cfg.memory,
+		NanoCPUs:     int64(cfg.cpus * 1e9),
+
+		RestartPolicy: cfg.restartPolicy,
+		PullPolicy:    cfg.pullPolicy,
+
+		Network:        cfg.network,
+		NetworkAliases: cfg.networkAliases,
+	}
+
+	for k, v := range cfg.labels {
+		req.Labels[k] = v
+	}
+	req.Labels[container.LabelDBVersion] = cfg.version
+	if !cfg.autoPort {
+		// the port docker picks is only known after the container is created
+		req.Labels[container.LabelPort] = port
+	}
+	if cfg.label != "" {
+		req.Labels[container.LabelCustom] = cfg.label
+	}
+	if cfg.instanceName != "" {
+		req.Labels[container.LabelName] = cfg.instanceName
+	}
+
+	if cfg.initdbArgs != "" {
+		req.Env["POSTGRES_INITDB_ARGS"] = cfg.initdbArgs
+	}
+
+	if cfg.timezone != "" {
+		req.Env["TZ"], req.Env["PGTZ"] = cfg.timezone, cfg.timezone
+		req.Cmd = append(req.Cmd, "-c", "timezone="+cfg.timezone)
+	}
+
+	if cfg.unixSocketDir != "" {
+		req.Binds = append(req.Binds, fmt.Sprintf("%s:%s", cfg.unixSocketDir, socketPath))
+	}
+
+	if cfg.dataDir != "" {
+		req.Binds = append(req.Binds, fmt.Sprintf("%s:%s", cfg.dataDir, dataPath))
 	}
 
-	if p.cfg.label != "" {
-		req.Labels[container.LabelCustom] = p.cfg.label
+	// the entrypoint runs the scripts sorted by name, the index prefix keeps the given order
+	for i, script := range cfg.initScripts {
+		req.Binds = append(req.Binds, fmt.Sprintf("%s:%s/%03d_%s:ro", script, initScriptsPath, i, filepath.Base(script)))
 	}
 
-	pg, err := container.Run(ctx, req)
+	if cfg.walArchiveDir != "" {
+		req.Binds = append(req.Binds, fmt.Sprintf("%s:%s", cfg.walArchiveDir, walArchivePath))
+		req.Cmd = append(req.Cmd,
+			"-c", "wal_level=replica",
+			"-c", "archive_mode=on",
+			"-c", fmt.Sprintf("archive_command=test ! -f %[1]s/%%f && cp %%p %[1]s/%%f", walArchivePath),
+		)
+	}
+
+	// reuse attaches only to a container with the same credentials, database and server settings
+	req.Labels[container.LabelConfig] = configHash(req, cfg)
+
+	return req, nil
+}
+
+// configHash returns a short hash of the settings a reused container must match: the credentials and database
+// in the environment, the server settings in the command and mounts, and the extensions, encoding and app user
+func configHash(req container.CreateRequest, cfg config) string {
+	envs := make([]string, 0, len(req.Env))
+	for k, v := range req.Env {
+		envs = append(envs, k+"="+v)
+	}
+	sort.Strings(envs)
+
+	h := sha256.New()
+	settings := [][]string{{req.Image}, req.Cmd, envs, req.Binds, cfg.extensions,
+		{cfg.encoding.encoding, cfg.encoding.collate, cfg.encoding.ctype, cfg.appUser, cfg.appPass}}
+	for _, values := range settings {
+		for _, v := range values {
+			_, _ = h.Write(append([]byte(v), 0))
+		}
+		_, _ = h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (p *Postgres) startUsingDocker(ctx context.Context, timeout time.Duration, detach bool) (database.CloseFunc, error) {
+	req, err := buildCreateRequest(p.cfg)
 	if err != nil {
-		return nil, err
+		return nil, phaseError(PhaseContainer, err)
+	}
+
+	if detach && p.cfg.idleTimeout > 0 {
+		if req, err = withIdleShutdown(req, p.cfg.idleTimeout); err != nil {
+			return nil, phaseError(PhaseContainer, err)
+		}
+	}
+
+	pg, err := p.runContainer(ctx, req)
+	if err != nil {
+		return nil, phaseError(PhaseContainer, err)
 	}
 
-	p.containerID = pg.ID
+	p.containerID, p.containerName = pg.ID, pg.Name
 
 	closeFunc := func(ctx context.Context) error {
-		return pg.Terminate(ctx)
+		return p.terminate(ctx, pg.ID)
+	}
+
+	if p.cfg.autoPort {
+		if err := p.resolvePort(ctx); err != nil {
+			return closeFunc, phaseError(PhaseContainer, err)
+		}
+	}
+
+	if err := p.WaitForStart(ctx, timeout); err != nil {
+		p.writeContainerLogs(ctx)
+		return closeFunc, phaseError(PhaseWait, err)
+	}
+
+	return closeFunc, nil
+}
+
+// resolvePort reads back the host port docker published the postgres port on
+func (p *Postgres) resolvePort(ctx context.Context) error {
+	hostPort, err := p.runner.HostPort(ctx, p.containerID, "5432/tcp")
+	if err != nil {
+		return fmt.Errorf("resolve postgres port failed: %w", err)
+	}
+
+	port, err := strconv.ParseUint(hostPort, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid postgres host port %q: %w", hostPort, err)
+	}
+	p.cfg.port = uint32(port)
+	return nil
+}
+
+// writeContainerLogs writes the container logs to the configured logger,
+// so the actual postgres error is visible when the container fails to boot
+func (p *Postgres) writeContainerLogs(ctx context.Context) {
+	logs, err := p.runner.Logs(ctx, p.containerID)
+	if err != nil {
+		p.log(logger.LevelWarn, "container_logs_failed", fmt.Sprintf("read postgres container logs failed: %s", err), fields{"error": err.Error()})
+		return
 	}
 
-	return closeFunc, p.WaitForStart(ctx, timeout)
+	if p.cfg.logFormat == LogJSON {
+		p.log(logger.LevelError, "start_failed", "postgres failed to start", fields{"container_id": p.containerID, "logs": string(logs)})
+		return
+	}
+
+	if p.cfg.logger == nil {
+		logger.Error("postgres failed to start, container logs:\n" + string(logs))
+		return
+	}
+	_, _ = fmt.Fprintf(p.cfg.logger, "postgres failed to start, container logs:\n%s", logs)
 }
 
 // URI returns the postgres connection uri
 func (p *Postgres) URI() string {
-	addr := "localhost"
+	q := p.sslParams()
+	q.Set("application_name", p.cfg.appName)
+	if p.cfg.timezone != "" {
+		// timezone is not a connection parameter of libpq, options is understood by lib/pq, psql and pgx alike
+		q.Set("options", "-c timezone="+p.cfg.timezone)
+	}
+	host := net.JoinHostPort(p.host(), strconv.Itoa(int(p.cfg.port)))
+	if p.cfg.unixSocketDir != "" {
+		// lib/pq connects to the socket in the host directory, the socket is named after the port inside the container
+		q.Set("host", p.cfg.unixSocketDir)
+		q.Set("port", "5432")
+		host = ""
+	}
+	return (&url.URL{Scheme: "postgres", User: url.UserPassword(p.cfg.user, p.cfg.pass), Host: host, Path: "/" + p.cfg.name, RawQuery: queryEncode(q)}).String()
+}
+
+// queryEncode encodes q like url.Values.Encode, with spaces as %20 as libpq reads a + literally
+func queryEncode(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// NetworkURI returns the connection uri of the database for containers of the network given to WithNetwork,
+// the host is the first network alias or the container name and the port is the one inside the container.
+// It is empty without WithNetwork and before Start.
+func (p *Postgres) NetworkURI() string {
+	if p.cfg.network == "" || p.containerName == "" {
+		return ""
+	}
+
+	cfg := p.cfg
+	cfg.externalHost, cfg.port, cfg.unixSocketDir = p.containerName, 5432, ""
+	if len(cfg.networkAliases) > 0 {
+		cfg.externalHost = cfg.networkAliases[0]
+	}
+	return (&Postgres{cfg: cfg}).URI()
+}
+
+// sslParams returns the ssl parameters of the uri, certificates are only used if ssl is not disabled
+func (p *Postgres) sslParams() url.Values {
+	mode := p.cfg.sslMode
+	if mode == "" {
+		mode = "disable"
+	}
+
+	q := url.Values{"sslmode": []string{mode}}
+	if mode != "disable" {
+		for key, value := range map[string]string{"sslrootcert": p.cfg.sslRootCert, "sslcert": p.cfg.sslCert, "sslkey": p.cfg.sslKey} {
+			if value != "" {
+				q.Set(key, value)
+			}
+		}
+	}
+	return q
+}
+
+func (p *Postgres) host() string {
+	if p.cfg.externalHost != "" {
+		return p.cfg.externalHost
+	}
 	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
-		addr = "host.docker.internal"
+		return "host.docker.internal"
 	}
+	return "localhost"
+}
+
+// Connect opens a connection pool to the database and makes sure it's reachable,
+// it's up to the caller to close it
+func (p *Postgres) Connect(ctx context.Context) (*sql.DB, error) {
+	return dbConnect(ctx, p.URI())
+}
 
-	host := net.JoinHostPort(addr, strconv.Itoa(int(p.cfg.port)))
-	return (&url.URL{Scheme: "postgres", User: url.UserPassword(p.cfg.user, p.cfg.pass), Host: host, Path: p.cfg.name, RawQuery: "sslmode=disable"}).String()
+// Port returns the host port of the database, with WithAutoPort it is the port docker picked once started
+func (p *Postgres) Port() uint32 {
+	return p.cfg.port
 }
 
 func (p *Postgres) ContainerID() string {
 	return p.containerID
 }
 
-// RunMigrations runs migrations on a postgres database
+// RunMigrations runs migrations on a postgres database, it logs nothing so callers log in their own format
 func RunMigrations(ctx context.Context, conn *sql.DB, migrationsFiles []string, uri string) error {
 	if migrationsFiles == nil {
 		return nil
 	}
 
-	logger.Info("Applying migrations ...")
-	return applySQL(ctx, conn, migrationsFiles, uri)
+	return applyMigrations(ctx, conn, migrationsFiles, uri)
 }
 
-// ApplyFixtures applies fixtures on a postgres database
+// ApplyFixtures applies fixtures on a postgres database, it logs nothing so callers log in their own format
 func ApplyFixtures(ctx context.Context, conn *sql.DB, fixtureFiles []string, uri string) error {
 	if len(fixtureFiles) == 0 {
 		return nil
 	}
 
-	logger.Info("Applying fixtures ...")
-	return applySQL(ctx, conn, fixtureFiles, uri)
+	return applyFixtureFiles(ctx, conn, fixtureFiles, uri, false)
 }
 
-func applyFixturesFromDir(ctx context.Context, conn *sql.DB, dir string, uri string) error {
+// applyFixturesFromDir applies the fixture files of dir and returns them
+func (p *Postgres) applyFixturesFromDir(ctx context.Context, dir string, uri string) ([]string, error) {
 	if dir == "" {
+		return nil, nil
+	}
+
+	files, err := GetFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures failed: %w", err)
+	}
+
+	return files, p.applyFixtures(ctx, files, uri)
+}
+
+// applyFixtures applies fixture files sequentially, or concurrently if parallel fixtures are enabled
+func (p *Postgres) applyFixtures(ctx context.Context, files []string, uri string) error {
+	if len(files) == 0 {
 		return nil
 	}
 
-	files, err := getFiles(dir)
+	start := time.Now()
+	var err error
+	if p.cfg.fixtureWorkers <= 1 {
+		p.log(logger.LevelInfo, "applying_fixtures", "Applying fixtures ...", fields{"files": len(files)})
+		err = applyFixtureFiles(ctx, nil, files, uri, p.cfg.continueOnFixtureError)
+	} else {
+		p.log(logger.LevelInfo, "applying_fixtures", fmt.Sprintf("Applying fixtures using %d workers ...", p.cfg.fixtureWorkers),
+			fields{"files": len(files), "workers": p.cfg.fixtureWorkers})
+		err = applyFixtureFilesParallel(ctx, files, uri, p.cfg.fixtureWorkers, p.cfg.continueOnFixtureError)
+	}
 	if err != nil {
-		return fmt.Errorf("read fixtures failed: %w", err)
+		return err
+	}
+
+	if p.cfg.resetSequences {
+		if err := ResetSequences(ctx, uri); err != nil {
+			return err
+		}
+	}
+
+	p.observer().FixturesApplied(len(files), time.Since(start))
+	p.log(logger.LevelDebug, "fixtures_applied", "Fixtures applied", fields{"files": len(files), "duration_ms": durationMs(start)})
+	return nil
+}
+
+// runMigrations applies migration files and go migrations sequentially
+func (p *Postgres) runMigrations(ctx context.Context, files []string, uri string, migrators ...Migrator) error {
+	if files == nil && len(migrators) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	n := len(files) + len(migrators)
+	p.log(logger.LevelInfo, "applying_migrations", "Applying migrations ...", fields{"files": len(files), "go_migrations": len(migrators)})
+	if err := applyMigrations(ctx, nil, files, uri, migrators...); err != nil {
+		return err
 	}
 
-	logger.Info("Applying fixtures ...")
-	return applySQL(ctx, conn, files, uri)
+	p.observer().MigrationsApplied(n, time.Since(start))
+	p.log(logger.LevelDebug, "migrations_applied", "Migrations applied", fields{"files": len(files), "go_migrations": len(migrators), "duration_ms": durationMs(start)})
+	return nil
 }
 
-func createDatabase(ctx context.Context, conn *sql.DB, name string) error {
-	if _, err := conn.ExecContext(ctx, fmt.Sprintf("create database %s", name)); err != nil {
+func createDatabase(ctx context.Context, conn *sql.DB, name string, enc dbEncoding) error {
+	if _, err := conn.ExecContext(ctx, "create database "+pq.QuoteIdentifier(name)+enc.clause()); err != nil {
 		return fmt.Errorf("create database failed: %w", err)
 	}
 	return nil
 }
 
 func applySQL(ctx context.Context, conn *sql.DB, stmts []string, uri string) error {
+	return execSQLFiles(ctx, conn, stmts, uri, nil)
+}
+
+// execer runs statements, it is satisfied by both a connection pool and a single session
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// txBeginner starts transactions, it is satisfied by both a connection pool and a single session
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// execSQLFiles executes the sql of files in order, applied is called after each executed sql source if not nil
+func execSQLFiles(ctx context.Context, conn *sql.DB, stmts []string, uri string, applied func(ctx context.Context, conn execer, src sqlSource) error) error {
 	if conn == nil {
 		var err error
 		conn, err = dbConnect(ctx, uri)
@@ -455,15 +1740,37 @@ func applySQL(ctx context.Context, conn *sql.DB, stmts []string, uri string) err
 			_ = conn.Close()
 		}()
 	}
+	return execSQLSources(ctx, conn, stmts, nil, applied)
+}
 
+// execSQLSources executes the sql of files in order on conn, see execSQLFiles. Sources skip returns true for
+// are not executed, skip can be nil.
+func execSQLSources(ctx context.Context, conn execer, stmts []string, skip func(src sqlSource) bool, applied func(ctx context.Context, conn execer, src sqlSource) error) error {
 	for _, f := range stmts {
-		b, err := os.ReadFile(f)
+		sources, err := readSQLSources(f)
 		if err != nil {
 			return fmt.Errorf("read file (%s) failed: %w", f, err)
 		}
 
-		if _, err := conn.Exec(string(b)); err != nil {
-			return fmt.Errorf("applying file (%s) failed: %w", f, err)
+		for _, src := range sources {
+			if skip != nil && skip(src) {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, src.sql); err != nil {
+				// lib/pq reports a cancelled statement as a server error, surface the context error instead
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return fmt.Errorf("applying file (%s) cancelled: %w", src.name, ctxErr)
+				}
+				merr := newMigrationError(src.name, src.sql, err)
+				locateStatement(ctx, conn, src.sql, merr)
+				return merr
+			}
+
+			if applied != nil {
+				if err := applied(ctx, conn, src); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,2011 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestCreateDBResponse(t *testing.T) {
+	for _, insideDocker := range []string{"", "true"} {
+		t.Setenv("DBCTL_INSIDE_DOCKER", insideDocker)
+
+		db, err := New(WithHost("alice", "s3cret", "dbctl_123", 25432))
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+
+		res := db.createDBResponse()
+		u, err := url.Parse(res.URI)
+		if err != nil {
+			t.Fatalf("parse uri failed %s", err)
+		}
+
+		pass, _ := u.User.Password()
+		if res.Host != u.Hostname() || res.Host != "localhost" {
+			t.Fatalf("expected host localhost, got %q and uri host %q", res.Host, u.Hostname())
+		}
+		if strconv.Itoa(int(res.Port)) != u.Port() || res.Port != 25432 {
+			t.Fatalf("expected port 25432, got %d and uri port %q", res.Port, u.Port())
+		}
+		if res.User != u.User.Username() || res.User != "alice" {
+			t.Fatalf("expected user alice, got %q and uri user %q", res.User, u.User.Username())
+		}
+		if res.Password != pass || res.Password != "s3cret" {
+			t.Fatalf("expected password s3cret, got %q and uri password %q", res.Password, pass)
+		}
+		if "/"+res.Database != u.Path || res.Database != "dbctl_123" {
+			t.Fatalf("expected database dbctl_123, got %q and uri path %q", res.Database, u.Path)
+		}
+	}
+}
+
+func TestCreateDBStream(t *testing.T) {
+	db := startTestPostgres(t)
+
+	const n = 5
+	results, err := db.CreateDBStream(context.Background(), &database.CreateDBRequest{}, n)
+	if err != nil {
+		t.Fatalf("CreateDBStream failed %s", err)
+	}
+
+	var got int
+	for r := range results {
+		if r.Err != nil {
+			t.Fatalf("create database failed %s", r.Err)
+		}
+		if r.Response == nil || r.Response.URI == "" {
+			t.Fatalf("expected a database uri, got %+v", r.Response)
+		}
+		got++
+	}
+
+	if got != n {
+		t.Fatalf("expected %d results, got %d", n, got)
+	}
+}
+
+// startTestPostgres starts a detached postgres instance on a free port for integration tests.
+// the test is skipped if docker is not reachable.
+func startTestPostgres(t testing.TB, options ...Option) *Postgres {
+	t.Helper()
+
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	port := uint32(utils.GetAvailablePort())
+	opts := append([]Option{WithHost(DefaultUser, DefaultPass, DefaultName, port), WithLogger(io.Discard)}, options...)
+	db, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if err := db.Start(context.Background(), true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+
+	return db
+}
+
+func TestWithWALArchivingPermissions(t *testing.T) {
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	if _, err := New(WithWALArchiving(archiveDir)); err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	info, err := os.Stat(archiveDir)
+	if err != nil {
+		t.Fatalf("stat archive dir failed %s", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o777 {
+		t.Fatalf("expected archive dir to be writable by the container user, got %s", perm)
+	}
+}
+
+// walSegmentName matches the file name of a wal segment, timeline, log and segment as hex numbers
+var walSegmentName = regexp.MustCompile(`^[0-9A-F]{24}$`)
+
+func TestCreateRestorePoint(t *testing.T) {
+	archiveDir := t.TempDir()
+	db := startTestPostgres(t, WithWALArchiving(archiveDir))
+
+	if err := db.CreateRestorePoint(context.Background(), db.URI(), "before_test"); err != nil {
+		t.Fatalf("CreateRestorePoint failed %s", err)
+	}
+
+	// archiving happens asynchronously, give the archiver some time
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		files, err := os.ReadDir(archiveDir)
+		if err != nil {
+			t.Fatalf("read archive dir failed %s", err)
+		}
+		for _, f := range files {
+			if walSegmentName.MatchString(f.Name()) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a wal segment in the archive directory, got %d files", len(files))
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestCreateWithUniqueNameRetries(t *testing.T) {
+	var names []string
+	name, err := (&Postgres{}).createWithUniqueName("", func(name string) error {
+		names = append(names, name)
+		if len(names) == 1 {
+			return fmt.Errorf("create database failed: %w", &pq.Error{Code: "42P04"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("createWithUniqueName failed %s", err)
+	}
+
+	if len(names) != 2 || names[0] == names[1] || name != names[1] {
+		t.Fatalf("expected a retry with a new name, got %v and %q", names, name)
+	}
+}
+
+func TestCreateDBParallelNamesAreUnique(t *testing.T) {
+	db := startTestPostgres(t)
+
+	const n = 20
+	uris := make([]string, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := db.CreateDB(context.Background(), &database.CreateDBRequest{})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			uris[i] = res.URI
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("CreateDB failed %s", errs[i])
+		}
+		if seen[uris[i]] {
+			t.Fatalf("duplicate database uri %q", uris[i])
+		}
+		seen[uris[i]] = true
+	}
+}
+
+func TestCreateDBAppliesFixturesOnTemplateClone(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int, name varchar(20));",
+	})
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_foo.sql": "insert into foo (id, name) values (1, 'foo'), (2, 'bar'), (3, 'baz');",
+	})
+
+	db := startTestPostgres(t, WithMigrations(migrations))
+
+	requests := []*database.CreateDBRequest{
+		{WithDefaultMigrations: true, Fixtures: fixtures},
+		// first call creates the template, the second one is cloned from it
+		{Migrations: migrations, Fixtures: fixtures},
+		{Migrations: migrations, Fixtures: fixtures},
+	}
+
+	for _, req := range requests {
+		res, err := db.CreateDB(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateDB failed %s", err)
+		}
+
+		if n := countRows(t, res.URI, "foo"); n != 3 {
+			t.Fatalf("expected 3 rows in foo, got %d", n)
+		}
+	}
+}
+
+func TestCreateDBAppliedMigrationsAndFixtures(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql":   "create table foo(id int);",
+		"0002_bar.up.sql":   "create table bar(id int);",
+		"0002_bar.down.sql": "drop table bar;",
+	})
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_foo.sql": "insert into foo values (1);",
+		"01_bar.sql": "insert into bar values (1);",
+	})
+
+	db := startTestPostgres(t, WithMigrations(migrations))
+
+	expectedFixtures := []string{filepath.Join(fixtures, "00_foo.sql"), filepath.Join(fixtures, "01_bar.sql")}
+	for _, tt := range []struct {
+		name string
+		req  *database.CreateDBRequest
+	}{
+		{"default template", &database.CreateDBRequest{WithDefaultMigrations: true, Fixtures: fixtures}},
+		{"migrations", &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures}},
+		{"template clone", &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures}},
+		{"from scratch", &database.CreateDBRequest{Migrations: migrations, Fixtures: fixtures, SkipTemplate: true}},
+	} {
+		res, err := db.CreateDB(context.Background(), tt.req)
+		if err != nil {
+			t.Fatalf("%s: CreateDB failed %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(res.AppliedMigrations, []string{"0001", "0002"}) {
+			t.Fatalf("%s: expected migrations [0001 0002], got %v", tt.name, res.AppliedMigrations)
+		}
+		if !reflect.DeepEqual(res.AppliedFixtures, expectedFixtures) {
+			t.Fatalf("%s: expected fixtures %v, got %v", tt.name, expectedFixtures, res.AppliedFixtures)
+		}
+	}
+
+	res, err := db.CreateDB(context.Background(), &database.CreateDBRequest{})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if res.AppliedMigrations != nil || res.AppliedFixtures != nil {
+		t.Fatalf("expected nothing applied to an empty database, got %v and %v", res.AppliedMigrations, res.AppliedFixtures)
+	}
+}
+
+// writeSQLFiles writes the given files into a temporary directory and returns its path
+func writeSQLFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write file %s failed %s", name, err)
+		}
+	}
+	return dir
+}
+
+func countRows(t *testing.T, uri, table string) int {
+	t.Helper()
+
+	conn, err := dbConnect(context.Background(), uri)
+	if err != nil {
+		t.Fatalf("connect to database failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var n int
+	if err := conn.QueryRow(fmt.Sprintf("select count(*) from %s", table)).Scan(&n); err != nil {
+		t.Fatalf("count rows of %s failed %s", table, err)
+	}
+	return n
+}
+
+func TestPlanStart(t *testing.T) {
+	archiveDir := t.TempDir()
+	db, err := New(
+		WithHost("alice", "s3cret", "app", 25432),
+		WithVersion("14.3.2"),
+		WithLabel("ci"),
+		WithWALArchiving(archiveDir),
+	)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+
+	if req.Image != "postgis/postgis:14-3.2-alpine" {
+		t.Fatalf("unexpected image %q", req.Image)
+	}
+
+	expectedEnv := map[string]string{"POSTGRES_USER": "alice", "POSTGRES_PASSWORD": "s3cret", "POSTGRES_DB": "app"}
+	if !reflect.DeepEqual(req.Env, expectedEnv) {
+		t.Fatalf("expected env %v, got %v", expectedEnv, req.Env)
+	}
+
+	if !reflect.DeepEqual(req.ExposedPorts, []string{"25432:5432/tcp"}) {
+		t.Fatalf("unexpected exposed ports %v", req.ExposedPorts)
+	}
+
+	if req.Labels[container.LabelType] != database.LabelPostgres || req.Labels[container.LabelCustom] != "ci" {
+		t.Fatalf("unexpected labels %v", req.Labels)
+	}
+
+	if !reflect.DeepEqual(req.Binds, []string{archiveDir + ":" + walArchivePath}) {
+		t.Fatalf("unexpected binds %v", req.Binds)
+	}
+
+	if req.Cmd[0] != "postgres" || !containsArg(req.Cmd, "archive_mode=on") {
+		t.Fatalf("unexpected cmd %v", req.Cmd)
+	}
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCreateDBSkipTemplate(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int, name varchar(20));",
+	})
+
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	// first call creates the template for migrations
+	if _, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations}); err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	// put a marker row in the template, so we can tell if a database is cloned from it
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+	template := db.withName(utils.GetListHash(files))
+	if err := applySQLStatement(ctx, template.URI(), "insert into foo (id, name) values (1, 'from-template')"); err != nil {
+		t.Fatalf("insert marker row failed %s", err)
+	}
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations, SkipTemplate: true})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if n := countRows(t, res.URI, "foo"); n != 0 {
+		t.Fatalf("expected a pristine database, got %d rows in foo", n)
+	}
+
+	res, err = db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if n := countRows(t, res.URI, "foo"); n != 1 {
+		t.Fatalf("expected database cloned from template, got %d rows in foo", n)
+	}
+}
+
+func TestCreateDBWithoutAutoTemplate(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int, name varchar(20));",
+	})
+
+	db := startTestPostgres(t, WithAutoTemplate(false))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations})
+		if err != nil {
+			t.Fatalf("CreateDB failed %s", err)
+		}
+		if n := countRows(t, res.URI, "foo"); n != 0 {
+			t.Fatalf("expected a migrated database, got %d rows in foo", n)
+		}
+	}
+
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+	where := fmt.Sprintf("pg_database where datname in ('%s', '%s')", utils.GetListHash(files), DefaultTemplate)
+	if n := countRows(t, db.URI(), where); n != 0 {
+		t.Fatalf("expected no template database, got %d", n)
+	}
+}
+
+func applySQLStatement(ctx context.Context, uri, stmt string) error {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.ExecContext(ctx, stmt)
+	return err
+}
+
+func TestCreateDBFromNamedTemplates(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	templates := map[string]string{"billing_template": "invoices", "users_template": "accounts"}
+	for template, table := range templates {
+		migrations := writeSQLFiles(t, map[string]string{
+			"0001.up.sql": fmt.Sprintf("create table %s(id int);", table),
+		})
+		fixtures := writeSQLFiles(t, map[string]string{
+			"00.sql": fmt.Sprintf("insert into %s (id) values (1);", table),
+		})
+		if err := db.CreateTemplate(ctx, template, migrations, fixtures); err != nil {
+			t.Fatalf("CreateTemplate %s failed %s", template, err)
+		}
+	}
+
+	for template, table := range templates {
+		res, err := db.CreateDB(ctx, &database.CreateDBRequest{Template: template})
+		if err != nil {
+			t.Fatalf("CreateDB from %s failed %s", template, err)
+		}
+
+		if n := countRows(t, res.URI, table); n != 1 {
+			t.Fatalf("expected 1 row in %s, got %d", table, n)
+		}
+	}
+}
+
+func TestWaitForStartTimeout(t *testing.T) {
+	// nothing is listening on this port, so the database never becomes ready
+	port := uint32(utils.GetAvailablePort())
+	db, err := New(WithHost(DefaultUser, DefaultPass, DefaultName, port), WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	err = db.WaitForStart(context.Background(), 100*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	// the last connection error should be part of the returned error
+	if !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the connection error to be wrapped, got %v", err)
+	}
+}
+
+func TestStartupOptionsValidation(t *testing.T) {
+	if _, err := New(WithStartupTimeout(0)); err == nil {
+		t.Fatal("expected an error for zero startup timeout")
+	}
+
+	if _, err := New(WithPollInterval(-time.Second)); err == nil {
+		t.Fatal("expected an error for negative poll interval")
+	}
+
+	if _, err := New(WithParallelFixtures(0)); err == nil {
+		t.Fatal("expected an error for zero parallel fixtures workers")
+	}
+}
+
+func TestStartFailsEarlyWithoutDocker(t *testing.T) {
+	fake := &fakeRunner{pingErr: fmt.Errorf("%w: dial unix /var/run/docker.sock: connect: no such file or directory", container.ErrDaemonUnreachable)}
+	db, err := New(WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = fake
+
+	err = db.Start(context.Background(), true)
+	if !errors.Is(err, container.ErrDaemonUnreachable) {
+		t.Fatalf("expected daemon unreachable error, got %v", err)
+	}
+
+	if len(fake.runs) != 0 {
+		t.Fatalf("expected no container to be created, got %d", len(fake.runs))
+	}
+}
+
+func TestStartWritesContainerLogsOnFailure(t *testing.T) {
+	var out bytes.Buffer
+	fake := &fakeRunner{logs: "FATAL:  unrecognized configuration parameter \"fsyncc\"\n"}
+
+	// the fake runner does not start anything, so waiting for the database times out
+	port := uint32(utils.GetAvailablePort())
+	db, err := New(
+		WithHost(DefaultUser, DefaultPass, DefaultName, port),
+		WithLogger(&out),
+		WithStartupTimeout(100*time.Millisecond),
+		WithPollInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = fake
+
+	if err := db.Start(context.Background(), true); err == nil {
+		t.Fatal("expected start to fail")
+	}
+
+	if !strings.Contains(out.String(), fake.logs) {
+		t.Fatalf("expected container logs in the output, got %q", out.String())
+	}
+}
+
+// fakeRunner records container operations instead of talking to docker
+type fakeRunner struct {
+	mu sync.Mutex
+
+	pingErr error
+	runErr  error
+	logs    string
+	// runErrs are returned by successive Run calls along with the created container, like a container that failed to start
+	runErrs []error
+	// imageErr is returned by EnsureImage, ensured records the images and pull policies it was called with
+	imageErr error
+	ensured  []string
+	// runBlocks makes Run wait for its context to be done, like a slow image pull
+	runBlocks bool
+
+	execCode   int
+	execStderr string
+
+	hostPort   string
+	containers []*container.Container
+
+	runs       []container.CreateRequest
+	terminated []string
+	// stopped and stopTimeouts record graceful stops, along with the time left until the deadline of their context
+	stopped      []string
+	stopTimeouts []time.Duration
+	stopLeft     []time.Duration
+	execs        [][]string
+	// lists counts List calls
+	lists int
+	// lingers is the number of List calls a terminated container is still listed by, like a slow removal,
+	// forever if negative
+	lingers   int
+	lingering map[string]int
+	// leaks makes TerminateByID only stop containers, like a failed removal, stopped containers are only listed by ListAll
+	leaks bool
+}
+
+func (f *fakeRunner) Ping(_ context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeRunner) Run(ctx context.Context, req container.CreateRequest) (*container.Container, error) {
+	if f.runBlocks {
+		<-ctx.Done()
+		f.mu.Lock()
+		f.runs = append(f.runs, req)
+		f.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.runs = append(f.runs, req)
+	if f.runErr != nil {
+		return nil, f.runErr
+	}
+	c := &container.Container{ID: fmt.Sprintf("fake-%d", len(f.runs)), Name: req.Name, Labels: req.Labels, State: "running"}
+	f.containers = append(f.containers, c)
+	if i := len(f.runs) - 1; i < len(f.runErrs) && f.runErrs[i] != nil {
+		return c, f.runErrs[i]
+	}
+	return c, nil
+}
+
+func (f *fakeRunner) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var left time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		left = time.Until(deadline)
+	}
+	f.stopped = append(f.stopped, id)
+	f.stopTimeouts = append(f.stopTimeouts, timeout)
+	f.stopLeft = append(f.stopLeft, left)
+	return nil
+}
+
+func (f *fakeRunner) EnsureImage(_ context.Context, image string, policy container.PullPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ensured = append(f.ensured, fmt.Sprintf("%s %s", image, policy))
+	return f.imageErr
+}
+
+// List returns the containers having all the given labels, containers without labels match any filter
+func (f *fakeRunner) List(_ context.Context, labels map[string]string) ([]*container.Container, error) {
+	return f.list(labels, false), nil
+}
+
+// ListAll returns the containers like List, including the ones leaked by TerminateByID
+func (f *fakeRunner) ListAll(_ context.Context, labels map[string]string) ([]*container.Container, error) {
+	return f.list(labels, true), nil
+}
+
+func (f *fakeRunner) list(labels map[string]string, all bool) []*container.Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+
+	var out []*container.Container
+	for _, c := range f.containers {
+		match := true
+		for k, v := range labels {
+			if c.Labels != nil && c.Labels[k] != v {
+				match = false
+			}
+		}
+		if match && f.listed(c.ID, all) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// listed reports if List still lists the container, terminated containers are removed after lingers List calls
+func (f *fakeRunner) listed(id string, all bool) bool {
+	var terminated bool
+	for _, t := range f.terminated {
+		terminated = terminated || t == id
+	}
+	if !terminated {
+		return true
+	}
+	if f.leaks {
+		return all
+	}
+
+	if f.lingering == nil {
+		f.lingering = make(map[string]int)
+	}
+	if f.lingers >= 0 && f.lingering[id] >= f.lingers {
+		return false
+	}
+	f.lingering[id]++
+	return true
+}
+
+func (f *fakeRunner) TerminateByID(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.terminated = append(f.terminated, id)
+	if f.leaks {
+		for _, c := range f.containers {
+			if c.ID == id {
+				c.State = "exited"
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeRunner) Logs(_ context.Context, _ string) ([]byte, error) {
+	return []byte(f.logs), nil
+}
+
+func (f *fakeRunner) FollowLogs(ctx context.Context, _ string, w io.Writer) error {
+	if _, err := io.WriteString(w, f.logs); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeRunner) HostPort(_ context.Context, _, _ string) (string, error) {
+	if f.hostPort == "" {
+		return "", errors.New("port is not published")
+	}
+	return f.hostPort, nil
+}
+
+func (f *fakeRunner) ExecStream(_ context.Context, _ string, cmd []string, _ io.Reader, _, stderr io.Writer) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.execs = append(f.execs, cmd)
+	_, _ = io.WriteString(stderr, f.execStderr)
+	return f.execCode, nil
+}
+
+func TestWithDataDir(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "pgdata")
+	db, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+
+	if !reflect.DeepEqual(req.Binds, []string{dataDir + ":" + dataPath}) {
+		t.Fatalf("unexpected binds %v", req.Binds)
+	}
+
+	if hasCluster(dataDir) {
+		t.Fatal("expected empty data dir to need initialization")
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, "PG_VERSION"), []byte("14\n"), 0o600); err != nil {
+		t.Fatalf("write PG_VERSION failed %s", err)
+	}
+
+	if !hasCluster(dataDir) {
+		t.Fatal("expected pre-populated data dir to skip migrations")
+	}
+}
+
+func TestWithDataDirSkipsMigrations(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "pgdata")
+	migrations := writeSQLFiles(t, map[string]string{"0001_foo.up.sql": "create table foo(id int);"})
+
+	db := startTestPostgres(t, WithDataDir(dataDir), WithMigrations(migrations))
+	if err := applySQLStatement(context.Background(), db.URI(), "insert into foo values (1)"); err != nil {
+		t.Fatalf("insert failed %s", err)
+	}
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+	if !hasCluster(dataDir) {
+		t.Fatal("expected the data dir to contain the cluster after stop")
+	}
+
+	// the new migration would run on an empty data dir, it is skipped as the cluster exists
+	migrations = writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int);",
+		"0002_bar.up.sql": "create table bar(id int);",
+	})
+	db = startTestPostgres(t, WithDataDir(dataDir), WithMigrations(migrations))
+
+	if n := countRows(t, db.URI(), "foo"); n != 1 {
+		t.Fatalf("expected the row of the first run to survive, got %d rows", n)
+	}
+
+	conn, err := db.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var missing bool
+	if err := conn.QueryRow("select to_regclass('public.bar') is null").Scan(&missing); err != nil {
+		t.Fatalf("query bar failed %s", err)
+	}
+	if !missing {
+		t.Fatal("expected migrations to be skipped on an initialized data dir")
+	}
+}
+
+func TestWithInitdbArgs(t *testing.T) {
+	args := "--data-checksums --encoding=UTF8 --locale=C"
+	dataDir := filepath.Join(t.TempDir(), "pgdata")
+	db, err := New(WithDataDir(dataDir), WithInitdbArgs(args))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+	if got := req.Env["POSTGRES_INITDB_ARGS"]; got != args {
+		t.Fatalf("expected POSTGRES_INITDB_ARGS %q, got %q", args, got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, "PG_VERSION"), []byte("14\n"), 0o600); err != nil {
+		t.Fatalf("write PG_VERSION failed %s", err)
+	}
+	if _, err := db.PlanStart(); err == nil {
+		t.Fatal("expected initdb args on an initialized data dir to fail")
+	}
+}
+
+func TestReadyCallback(t *testing.T) {
+	var mu sync.Mutex
+	var uris []string
+	db := startTestPostgres(t, WithReadyCallback(func(uri string) {
+		mu.Lock()
+		defer mu.Unlock()
+		uris = append(uris, uri)
+	}))
+
+	select {
+	case <-db.Started():
+	default:
+		t.Fatal("expected started channel to be closed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(uris, []string{db.URI()}) {
+		t.Fatalf("expected callback to be called once with %q, got %v", db.URI(), uris)
+	}
+}
+
+func TestStartedNotClosedOnFailure(t *testing.T) {
+	var called bool
+	db, err := New(
+		WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())),
+		WithLogger(io.Discard),
+		WithStartupTimeout(50*time.Millisecond),
+		WithPollInterval(10*time.Millisecond),
+		WithReadyCallback(func(string) { called = true }),
+	)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	db.runner = &fakeRunner{}
+
+	if err := db.Start(context.Background(), true); err == nil {
+		t.Fatal("expected start to fail")
+	}
+
+	select {
+	case <-db.Started():
+		t.Fatal("expected started channel to stay open")
+	default:
+	}
+
+	if called {
+		t.Fatal("expected ready callback not to be called")
+	}
+}
+
+func TestURISSLParams(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	u, _ := url.Parse(db.URI())
+	if u.Query().Get("sslmode") != "disable" {
+		t.Fatalf("expected sslmode disable by default, got %q", db.URI())
+	}
+
+	db, err = New(WithSSLMode("verify-full"), WithSSLRootCert("/certs/root.crt"), WithSSLCert("/certs/client.crt", "/certs/client.key"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	for _, uri := range []string{db.URI(), db.withName("other").URI()} {
+		u, _ = url.Parse(uri)
+		expected := url.Values{
+			"application_name": []string{DefaultAppName},
+			"sslmode":          []string{"verify-full"},
+			"sslrootcert":      []string{"/certs/root.crt"},
+			"sslcert":          []string{"/certs/client.crt"},
+			"sslkey":           []string{"/certs/client.key"},
+		}
+		if !reflect.DeepEqual(u.Query(), expected) {
+			t.Fatalf("expected ssl params %v, got %v", expected, u.Query())
+		}
+	}
+
+	db, err = New(WithSSLRootCert("/certs/root.crt"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if u, _ = url.Parse(db.URI()); u.Query().Has("sslrootcert") {
+		t.Fatalf("expected no certificates when ssl is disabled, got %q", db.URI())
+	}
+
+	if _, err := New(WithSSLMode("enabled")); err == nil {
+		t.Fatal("expected an error for unknown ssl mode")
+	}
+}
+
+func TestSanitizePrefix(t *testing.T) {
+	cases := map[string]string{
+		"":                       "dbctl",
+		"billing":                "billing",
+		"TestCreateDB/with-name": "testcreatedb_with_name",
+		"2fa":                    "_2fa",
+		strings.Repeat("a", 40):  strings.Repeat("a", maxDBPrefixLen),
+	}
+
+	for prefix, expected := range cases {
+		if got := sanitizePrefix(prefix); got != expected {
+			t.Fatalf("sanitizePrefix(%q): expected %q, got %q", prefix, expected, got)
+		}
+	}
+}
+
+func TestCreateDBWithPrefix(t *testing.T) {
+	db := startTestPostgres(t)
+
+	res, err := db.CreateDB(context.Background(), &database.CreateDBRequest{Prefix: t.Name()})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	u, err := url.Parse(res.URI)
+	if err != nil {
+		t.Fatalf("parse uri failed %s", err)
+	}
+
+	if !strings.HasPrefix(u.Path, "/testcreatedbwithprefix_") {
+		t.Fatalf("expected database name to start with the prefix, got %q", u.Path)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{"0002.up.sql": "select 2;", "0001.up.sql": "select 1;"})
+	fixtures := writeSQLFiles(t, map[string]string{"users.sql": "select 3;"})
+
+	var out bytes.Buffer
+	db, err := New(
+		WithHost(DefaultUser, DefaultPass, DefaultName, 25432),
+		WithVersion("14.3.2"),
+		WithMigrations(migrations),
+		WithFixtures(fixtures),
+		WithLogger(&out),
+		WithLogFormat(LogJSON),
+		WithDryRun(true),
+	)
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	// docker must not be touched at all
+	fake := &fakeRunner{pingErr: container.ErrDaemonUnreachable}
+	db.runner = fake
+
+	if err := db.Start(context.Background(), false); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+
+	if len(fake.runs) != 0 {
+		t.Fatalf("expected no container to be created, got %d", len(fake.runs))
+	}
+
+	events := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected json log line, got %q", line)
+		}
+		events[entry["event"].(string)] = entry
+	}
+
+	if c := events["dry_run_container"]; c["image"] != "postgis/postgis:14-3.2-alpine" || !reflect.DeepEqual(c["ports"], []any{"25432:5432/tcp"}) {
+		t.Fatalf("unexpected dry run container event %v", c)
+	}
+
+	expected := []any{filepath.Join(migrations, "0001.up.sql"), filepath.Join(migrations, "0002.up.sql")}
+	if files := events["dry_run_migrations"]["files"]; !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected ordered migrations %v, got %v", expected, files)
+	}
+
+	if files := events["dry_run_fixtures"]["files"]; !reflect.DeepEqual(files, []any{filepath.Join(fixtures, "users.sql")}) {
+		t.Fatalf("unexpected fixtures %v", files)
+	}
+}
+
+func TestURIWithUnixSocket(t *testing.T) {
+	socketDir := filepath.Join(t.TempDir(), "socket")
+	db, err := New(WithHost("alice", "s3cret", "app", 25432), WithUnixSocket(socketDir))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	expected := "postgres://alice:s3cret@/app?application_name=dbctl&host=" + url.QueryEscape(socketDir) + "&port=5432&sslmode=disable"
+	if db.URI() != expected {
+		t.Fatalf("expected uri %q, got %q", expected, db.URI())
+	}
+
+	dsn, err := pq.ParseURL(db.URI())
+	if err != nil || !strings.Contains(dsn, "host='"+socketDir+"'") {
+		t.Fatalf("expected lib/pq to connect using the socket directory, got %q: %v", dsn, err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+
+	if !reflect.DeepEqual(req.Binds, []string{socketDir + ":" + socketPath}) {
+		t.Fatalf("unexpected binds %v", req.Binds)
+	}
+}
+
+func TestURIApplicationName(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	u, _ := url.Parse(db.URI())
+	if u.Query().Get("application_name") != "dbctl" {
+		t.Fatalf("expected default application name dbctl, got %q", db.URI())
+	}
+
+	db, err = New(WithAppName("billing-tests"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	for _, uri := range []string{db.URI(), db.withName("other").URI(), db.createDBResponse().URI} {
+		if u, _ = url.Parse(uri); u.Query().Get("application_name") != "billing-tests" {
+			t.Fatalf("expected application name billing-tests, got %q", uri)
+		}
+	}
+}
+
+func TestTerminateBackendsQuery(t *testing.T) {
+	stmt, args := terminateBackendsQuery("dbctl_1", "dbctl", false)
+	if !strings.Contains(stmt, "application_name = $2") || !reflect.DeepEqual(args, []any{"dbctl_1", "dbctl"}) {
+		t.Fatalf("expected only dbctl connections to be targeted, got %q %v", stmt, args)
+	}
+
+	stmt, args = terminateBackendsQuery("dbctl_1", "dbctl", true)
+	if strings.Contains(stmt, "application_name") || !reflect.DeepEqual(args, []any{"dbctl_1"}) {
+		t.Fatalf("expected all connections to be targeted, got %q %v", stmt, args)
+	}
+}
+
+func TestIsDatabaseInUse(t *testing.T) {
+	if !isDatabaseInUse(fmt.Errorf("drop failed: %w", &pq.Error{Code: "55006"})) {
+		t.Fatal("expected object in use error to be detected")
+	}
+	if isDatabaseInUse(&pq.Error{Code: "42P04"}) || isDatabaseInUse(errors.New("55006")) {
+		t.Fatal("expected other errors not to be detected")
+	}
+}
+
+func TestRemoveDBWithLingeringConnections(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	// a pool keeping a connection open and reconnecting as soon as it is terminated
+	lingering, err := dbConnect(ctx, res.URI)
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	t.Cleanup(func() { _ = lingering.Close() })
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = lingering.PingContext(ctx)
+			}
+		}
+	}()
+
+	err = db.RemoveDB(ctx, res.URI)
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("RemoveDB failed %s", err)
+	}
+
+	names, err := db.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed %s", err)
+	}
+	for _, name := range names {
+		if name == res.Database {
+			t.Fatalf("expected %s to be dropped", name)
+		}
+	}
+}
+
+func TestDropDBTerminatesOnlyDbctlConnections(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	connect := func(appName string) *sql.DB {
+		u, _ := url.Parse(res.URI)
+		q := u.Query()
+		q.Set("application_name", appName)
+		u.RawQuery = q.Encode()
+
+		conn, err := dbConnect(ctx, u.String())
+		if err != nil {
+			t.Fatalf("connect as %s failed %s", appName, err)
+		}
+		conn.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	dbctlConn, psqlConn := connect(DefaultAppName), connect("psql")
+
+	var dbctlPid, psqlPid int
+	if err := dbctlConn.QueryRowContext(ctx, "select pg_backend_pid()").Scan(&dbctlPid); err != nil {
+		t.Fatalf("get backend pid failed %s", err)
+	}
+	if err := psqlConn.QueryRowContext(ctx, "select pg_backend_pid()").Scan(&psqlPid); err != nil {
+		t.Fatalf("get backend pid failed %s", err)
+	}
+
+	if err := db.RemoveDB(ctx, res.URI); err == nil {
+		t.Fatal("expected drop to fail while a psql session is connected")
+	}
+
+	var alive []int
+	rows, err := psqlConn.QueryContext(ctx, "select pid from pg_stat_activity where pid in ($1, $2)", dbctlPid, psqlPid)
+	if err != nil {
+		t.Fatalf("list backends failed %s", err)
+	}
+	for rows.Next() {
+		var pid int
+		_ = rows.Scan(&pid)
+		alive = append(alive, pid)
+	}
+	_ = rows.Close()
+
+	if !reflect.DeepEqual(alive, []int{psqlPid}) {
+		t.Fatalf("expected only the psql backend %d to survive, got %v", psqlPid, alive)
+	}
+
+	if err := db.DropDB(ctx, res.URI, true); err != nil {
+		t.Fatalf("forced DropDB failed %s", err)
+	}
+}
+
+func TestCreateTemplateQuotesName(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	name := `tmpl "quoted" \ name`
+	if err := db.CreateTemplate(ctx, name, "", ""); err != nil {
+		t.Fatalf("CreateTemplate failed %s", err)
+	}
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var n int
+	if err := conn.QueryRow("select count(*) from pg_database where datname = $1", name).Scan(&n); err != nil {
+		t.Fatalf("query pg_database failed %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected a database named %q, got %d", name, n)
+	}
+}
+
+func TestListDatabases(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	// migrations of a request save a template named after their hash, it is not listed
+	migrations := writeSQLFiles(t, map[string]string{"0001.up.sql": "create table foo(id int);"})
+	var created []string
+	for i := 0; i < 2; i++ {
+		res, err := db.CreateDB(ctx, &database.CreateDBRequest{Prefix: "list", Migrations: migrations})
+		if err != nil {
+			t.Fatalf("CreateDB failed %s", err)
+		}
+		created = append(created, res.Database)
+	}
+
+	names, err := db.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed %s", err)
+	}
+
+	sort.Strings(created)
+	if !reflect.DeepEqual(names, created) {
+		t.Fatalf("expected databases %v, got %v", created, names)
+	}
+}
+
+func TestCreateDBOnNewerVersions(t *testing.T) {
+	// postgres 15 revoked create on the public schema from public, migrations and template clones must still work
+	for _, version := range []string{"15.3.4", "16.3.4"} {
+		t.Run(version, func(t *testing.T) {
+			migrations := writeSQLFiles(t, map[string]string{"0001.up.sql": "create table foo(id int);"})
+			db := startTestPostgres(t, WithVersion(version), WithMigrations(migrations))
+
+			for i := 0; i < 2; i++ {
+				res, err := db.CreateDB(context.Background(), &database.CreateDBRequest{Migrations: migrations})
+				if err != nil {
+					t.Fatalf("CreateDB failed %s", err)
+				}
+
+				if n := countRows(t, res.URI, "foo"); n != 0 {
+					t.Fatalf("expected empty table foo, got %d rows", n)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMigrationsCancelled(t *testing.T) {
+	db := startTestPostgres(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "0001_slow.sql")
+	if err := os.WriteFile(file, []byte("SELECT pg_sleep(30);"), 0o644); err != nil {
+		t.Fatalf("write migration failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := RunMigrations(ctx, nil, []string{file}, db.URI())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("RunMigrations returned after %s, expected prompt cancellation", elapsed)
+	}
+}
+
+func TestAutoPort(t *testing.T) {
+	db, err := New(WithAutoPort())
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+	if !reflect.DeepEqual(req.ExposedPorts, []string{":5432/tcp"}) {
+		t.Fatalf("expected port published without a host port, got %v", req.ExposedPorts)
+	}
+
+	db.runner = &fakeRunner{hostPort: "49153"}
+	if err := db.resolvePort(context.Background()); err != nil {
+		t.Fatalf("resolvePort failed %s", err)
+	}
+	if db.Port() != 49153 {
+		t.Fatalf("expected resolved port 49153, got %d", db.Port())
+	}
+	if u, _ := url.Parse(db.URI()); u.Port() != "49153" {
+		t.Fatalf("expected uri to use resolved port, got %s", db.URI())
+	}
+}
+
+func TestAutoPortStart(t *testing.T) {
+	db := startTestPostgres(t, WithAutoPort())
+
+	if db.Port() == 0 || db.Port() == DefaultPort {
+		t.Fatalf("expected an ephemeral port, got %d", db.Port())
+	}
+	if u, _ := url.Parse(db.URI()); u.Port() != strconv.Itoa(int(db.Port())) {
+		t.Fatalf("expected uri port %d, got %s", db.Port(), db.URI())
+	}
+
+	conn, err := db.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect on resolved port failed %s", err)
+	}
+	_ = conn.Close()
+}
+
+func TestWithExtensions(t *testing.T) {
+	if _, err := New(WithExtensions("pgcrypto", " ")); err == nil {
+		t.Fatal("expected empty extension name to fail")
+	}
+
+	db := startTestPostgres(t, WithExtensions("pgcrypto", "citext"))
+
+	conn, err := db.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed %s", err)
+	}
+	defer conn.Close()
+
+	var id string
+	if err := conn.QueryRowContext(context.Background(), "select gen_random_uuid()::text").Scan(&id); err != nil {
+		t.Fatalf("gen_random_uuid failed %s", err)
+	}
+
+	var digest string
+	if err := conn.QueryRowContext(context.Background(), "select encode(digest('dbctl', 'sha256'), 'hex')").Scan(&digest); err != nil {
+		t.Fatalf("pgcrypto digest is not available %s", err)
+	}
+
+	var equal bool
+	if err := conn.QueryRowContext(context.Background(), "select 'DBCTL'::citext = 'dbctl'::citext").Scan(&equal); err != nil || !equal {
+		t.Fatalf("citext is not available, equal=%t err=%v", equal, err)
+	}
+
+	// databases which are not cloned from the instance database get the extensions before their migrations
+	migrations := writeSQLFiles(t, map[string]string{"0001.up.sql": "create table users(email citext primary key);"})
+	for _, req := range []*database.CreateDBRequest{{}, {Migrations: migrations}, {Migrations: migrations, SkipTemplate: true}} {
+		res, err := db.CreateDB(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateDB %+v failed %s", req, err)
+		}
+		if err := applySQLStatement(context.Background(), res.URI, "select 'DBCTL'::citext"); err != nil {
+			t.Fatalf("citext is not available in database %s: %s", res.Database, err)
+		}
+	}
+}
+
+func TestWithInitSQL(t *testing.T) {
+	db := startTestPostgres(t, WithInitSQL(
+		"create role app_reader login password 'secret'",
+		"alter role app_reader set statement_timeout = '5s'",
+	))
+
+	conn, err := db.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed %s", err)
+	}
+	defer conn.Close()
+
+	var exists bool
+	if err := conn.QueryRowContext(context.Background(), "select exists(select 1 from pg_roles where rolname = 'app_reader')").Scan(&exists); err != nil {
+		t.Fatalf("query roles failed %s", err)
+	}
+	if !exists {
+		t.Fatal("expected role created by init sql to exist")
+	}
+}
+
+func TestClone(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "create database clone_source"); err != nil {
+		t.Fatalf("create source database failed %s", err)
+	}
+
+	// keep a connection to the source open, Clone must terminate it
+	source, err := db.withName("clone_source").Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect to source failed %s", err)
+	}
+	defer source.Close()
+	if _, err := source.ExecContext(ctx, "create table users (id int primary key, name text); insert into users values (1, 'alice'), (2, 'bob')"); err != nil {
+		t.Fatalf("populate source failed %s", err)
+	}
+
+	if err := db.Clone(ctx, "clone_source", "clone_target"); err != nil {
+		t.Fatalf("Clone failed %s", err)
+	}
+
+	target, err := db.withName("clone_target").Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect to target failed %s", err)
+	}
+	defer target.Close()
+
+	rows, err := target.QueryContext(ctx, "select name from users order by id")
+	if err != nil {
+		t.Fatalf("query target failed %s", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan failed %s", err)
+		}
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Fatalf("expected cloned rows [alice bob], got %v", names)
+	}
+
+	if err := db.Clone(ctx, "missing_source", "clone_other"); !errors.Is(err, errDatabaseNotExists) {
+		t.Fatalf("expected missing source error, got %v", err)
+	}
+}
+
+func TestInstanceLabels(t *testing.T) {
+	db, err := New(WithHost(DefaultUser, DefaultPass, DefaultName, 25432), WithVersion("16"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+	if req.Labels[container.LabelDBVersion] != "16" || req.Labels[container.LabelPort] != "25432" {
+		t.Fatalf("unexpected labels %v", req.Labels)
+	}
+
+	info := containerInfo(&container.Container{ID: "id", Name: req.Name, Labels: req.Labels})
+	if info.Port != 25432 || info.Version != "16" {
+		t.Fatalf("expected port 25432 and version 16, got %+v", info)
+	}
+
+	auto, err := New(WithAutoPort())
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	req, err = auto.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+	if _, ok := req.Labels[container.LabelPort]; ok {
+		t.Fatalf("expected no port label with auto port, got %v", req.Labels)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	db := startTestPostgres(t, WithVersion("14.3.2"))
+
+	instances, err := Instances(context.Background())
+	if err != nil {
+		t.Fatalf("Instances failed %s", err)
+	}
+
+	for _, info := range instances {
+		if info.ID != db.ContainerID() {
+			continue
+		}
+		if info.Port != db.Port() || info.Version != "14.3.2" {
+			t.Fatalf("expected port %d and version 14.3.2, got %+v", db.Port(), info)
+		}
+		return
+	}
+	t.Fatalf("container %s not found in instances %+v", db.ContainerID(), instances)
+}
+
+func TestInstancesStatus(t *testing.T) {
+	r := &fakeRunner{containers: []*container.Container{
+		{ID: "running", State: "running"},
+		{ID: "paused", State: "paused"},
+		{ID: "restarting", State: "restarting"},
+		{ID: "exited", State: "exited"},
+		{ID: "created", State: "created"},
+		{ID: "unknown", State: "bogus"},
+	}}
+
+	infos, err := instances(context.Background(), r, nil)
+	if err != nil {
+		t.Fatalf("instances failed %s", err)
+	}
+
+	expected := map[string]database.Status{
+		"running":    database.Running,
+		"paused":     database.Paused,
+		"restarting": database.Restarting,
+		"exited":     database.Stoped,
+		"created":    database.Stoped,
+		"unknown":    database.Unknown,
+	}
+	if len(infos) != len(expected) {
+		t.Fatalf("expected %d instances, got %d", len(expected), len(infos))
+	}
+	for _, info := range infos {
+		if info.Status != expected[info.ID] {
+			t.Fatalf("container %s: expected status %d, got %d", info.ID, expected[info.ID], info.Status)
+		}
+	}
+}
+
+func TestWithAppUser(t *testing.T) {
+	if _, err := New(WithAppUser("", "secret")); err == nil {
+		t.Fatal("expected empty app user to fail")
+	}
+
+	db := startTestPostgres(t, WithAppUser("app", "secret"))
+	ctx := context.Background()
+
+	u, err := url.Parse(db.AppURI())
+	if err != nil {
+		t.Fatalf("parse app uri failed %s", err)
+	}
+	if u.User.Username() != "app" || u.Path != "/app" {
+		t.Fatalf("expected app uri to use the app user and database, got %s", db.AppURI())
+	}
+
+	conn, err := dbConnect(ctx, db.AppURI())
+	if err != nil {
+		t.Fatalf("connect as app user failed %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "create database app_other"); err == nil {
+		t.Fatal("expected app user to not be allowed to create databases")
+	}
+
+	if _, err := conn.ExecContext(ctx, "create table notes(id int); insert into notes values (1)"); err != nil {
+		t.Fatalf("expected app user to use its own database, got %s", err)
+	}
+}
+
+func TestWithLabels(t *testing.T) {
+	if _, err := New(WithLabels(map[string]string{container.LabelType: "redis"})); err == nil {
+		t.Fatal("expected reserved label to fail")
+	}
+	if _, err := New(WithNamePrefix("-bad name")); err == nil {
+		t.Fatal("expected invalid name prefix to fail")
+	}
+
+	labels := map[string]string{"pipeline": "42"}
+	db, err := New(WithNamePrefix("ci_42"), WithLabels(labels))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := db.PlanStart()
+	if err != nil {
+		t.Fatalf("PlanStart failed %s", err)
+	}
+	if !strings.HasPrefix(req.Name, "ci_42_") {
+		t.Fatalf("expected container name prefix ci_42_, got %s", req.Name)
+	}
+	if req.Labels["pipeline"] != "42" || req.Labels[container.LabelType] != database.LabelPostgres {
+		t.Fatalf("expected user labels merged into %v", req.Labels)
+	}
+
+	other := map[string]string{container.LabelType: database.LabelPostgres, "pipeline": "43"}
+	r := &fakeRunner{containers: []*container.Container{
+		{ID: "ours", Labels: req.Labels, State: "running"},
+		{ID: "theirs", Labels: other, State: "running"},
+	}}
+	db.runner = r
+
+	infos, err := instances(context.Background(), r, labels)
+	if err != nil {
+		t.Fatalf("instances failed %s", err)
+	}
+	if len(infos) != 1 || infos[0].ID != "ours" {
+		t.Fatalf("expected only our instance, got %+v", infos)
+	}
+
+	if err := db.StopAll(context.Background()); err != nil {
+		t.Fatalf("StopAll failed %s", err)
+	}
+	if !reflect.DeepEqual(r.terminated, []string{"ours"}) {
+		t.Fatalf("expected only our instance to be stopped, got %v", r.terminated)
+	}
+}
+
+func TestCreateDBWithDatabaseEncoding(t *testing.T) {
+	db := startTestPostgres(t, WithDatabaseEncoding("UTF8", "C", "C"))
+	ctx := context.Background()
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var encoding, collate, ctype string
+	stmt := "select pg_encoding_to_char(encoding), datcollate, datctype from pg_database where datname = $1"
+	if err := conn.QueryRowContext(ctx, stmt, res.Database).Scan(&encoding, &collate, &ctype); err != nil {
+		t.Fatalf("read database encoding failed %s", err)
+	}
+
+	if encoding != "UTF8" || collate != "C" || ctype != "C" {
+		t.Fatalf("expected UTF8 with C locale, got %s %s %s", encoding, collate, ctype)
+	}
+}
+
+func TestCreateDBAfterCreate(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{
+		AfterCreate: func(ctx context.Context, conn *sql.DB) error {
+			_, err := conn.ExecContext(ctx, "create table seeded(id int); insert into seeded values (1)")
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if n := countRows(t, res.URI, "seeded"); n != 1 {
+		t.Fatalf("expected the callback to insert a row, got %d rows", n)
+	}
+
+	errSeed := errors.New("seed failed")
+	_, err = db.CreateDB(ctx, &database.CreateDBRequest{
+		Prefix: "after_create_fail",
+		AfterCreate: func(context.Context, *sql.DB) error {
+			return errSeed
+		},
+	})
+	if !errors.Is(err, errSeed) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if n := countRows(t, db.URI(), "pg_database where datname like 'after_create_fail%'"); n != 0 {
+		t.Fatalf("expected the database to be removed after a failed callback, got %d", n)
+	}
+}
+
+func TestExternalPostgres(t *testing.T) {
+	server := startTestPostgres(t)
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int);",
+	})
+
+	db, err := New(WithExternalURI(server.URI()), WithMigrations(migrations), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	// docker must not be used for an external postgres
+	fake := &fakeRunner{pingErr: errors.New("docker is not available")}
+	db.runner = fake
+
+	ctx := context.Background()
+	if err := db.Start(ctx, true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+	if n := countRows(t, server.URI(), "foo"); n != 0 {
+		t.Fatalf("expected migrations applied to the external postgres, got %d rows", n)
+	}
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if n := countRows(t, res.URI, "foo"); n != 0 {
+		t.Fatalf("expected a migrated database, got %d rows", n)
+	}
+	if err := db.RemoveDB(ctx, res.URI); err != nil {
+		t.Fatalf("RemoveDB failed %s", err)
+	}
+
+	if err := db.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+	if len(fake.runs) != 0 || len(fake.terminated) != 0 {
+		t.Fatalf("expected no container to be managed, got runs %v and terminated %v", fake.runs, fake.terminated)
+	}
+	if err := server.ready(ctx); err != nil {
+		t.Fatalf("expected the external postgres to keep running, got %s", err)
+	}
+}
+
+func TestWithInitScriptsRunOnStart(t *testing.T) {
+	scripts := writeSQLFiles(t, map[string]string{"schema.sql": "create table from_init_script(id int);"})
+	db := startTestPostgres(t, WithInitScripts(scripts))
+
+	if n := countRows(t, db.URI(), "from_init_script"); n != 0 {
+		t.Fatalf("expected empty table created by the init script, got %d rows", n)
+	}
+}
+
+func TestWithTimezoneShowTimezone(t *testing.T) {
+	db := startTestPostgres(t, WithTimezone("America/New_York"))
+
+	conn, err := db.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var tz string
+	if err := conn.QueryRow("show timezone").Scan(&tz); err != nil {
+		t.Fatalf("show timezone failed %s", err)
+	}
+	if tz != "America/New_York" {
+		t.Fatalf("expected America/New_York, got %s", tz)
+	}
+}
+
+// sendSignal sends sig to the test process, which must be listening to it
+func sendSignal(t *testing.T, sig os.Signal) {
+	t.Helper()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("find process failed %s", err)
+	}
+	if err := process.Signal(sig); err != nil {
+		t.Fatalf("send signal failed %s", err)
+	}
+}
+
+func TestSignalContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can not be sent to a process on windows")
+	}
+
+	db, err := New()
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	parent := context.Background()
+	if ctx, stop := db.signalContext(parent); ctx != parent {
+		stop()
+		t.Fatal("expected the context to be kept without signal handling")
+	}
+
+	db, err = New(WithSignalHandling(true))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	ctx, stop := db.signalContext(parent)
+	defer stop()
+
+	sendSignal(t, syscall.SIGTERM)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the context to be canceled by the signal")
+	}
+}
+
+func TestStartStopsOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can not be sent to a process on windows")
+	}
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	db, err := New(WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())), WithLogger(io.Discard), WithSignalHandling(true))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Start(context.Background(), false)
+	}()
+
+	select {
+	case <-db.Started():
+	case err := <-done:
+		t.Fatalf("Start failed %s", err)
+	}
+
+	sendSignal(t, syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %s", err)
+		}
+	case <-time.After(30 * time.Second):
+		_ = db.Stop(context.Background())
+		t.Fatal("expected Start to return after the signal")
+	}
+}
+
+func TestKeepOnFailure(t *testing.T) {
+	start := func(runner *fakeRunner, options ...Option) error {
+		t.Helper()
+		opts := append([]Option{
+			WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())),
+			WithLogger(io.Discard),
+			WithStartupTimeout(50 * time.Millisecond),
+			WithPollInterval(10 * time.Millisecond),
+		}, options...)
+		db, err := New(opts...)
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		db.runner = runner
+		// the fake container never accepts connections, so Start fails once it runs
+		return db.Start(context.Background(), true)
+	}
+
+	removed := &fakeRunner{}
+	if err := start(removed); err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	if !reflect.DeepEqual(removed.terminated, []string{"fake-1"}) {
+		t.Fatalf("expected the failed container to be removed, got %v", removed.terminated)
+	}
+
+	var logs bytes.Buffer
+	kept := &fakeRunner{}
+	if err := start(kept, WithKeepOnFailure(true), WithLogFormat(LogJSON), WithLogger(&logs)); err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	if len(kept.terminated) != 0 {
+		t.Fatalf("expected the failed container to be kept, got terminated %v", kept.terminated)
+	}
+	if !strings.Contains(logs.String(), `"event":"kept_on_failure"`) || !strings.Contains(logs.String(), `"container_id":"fake-1"`) {
+		t.Fatalf("expected container id and uri to be logged, got %q", logs.String())
+	}
+}
+
+func TestWithReuse(t *testing.T) {
+	port := uint32(utils.GetAvailablePort())
+	start := func(runner *fakeRunner, options ...Option) (*Postgres, error) {
+		t.Helper()
+		opts := append([]Option{
+			WithHost(DefaultUser, DefaultPass, DefaultName, port),
+			WithLogger(io.Discard),
+			WithStartupTimeout(50 * time.Millisecond),
+			WithPollInterval(10 * time.Millisecond),
+		}, options...)
+		db, err := New(opts...)
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		db.runner = runner
+		return db, db.Start(context.Background(), true)
+	}
+
+	// the fake container never accepts connections, keep the first one running for the second Start
+	runner := &fakeRunner{}
+	if _, err := start(runner, WithKeepOnFailure(true)); err == nil {
+		t.Fatal("expected Start to fail")
+	}
+
+	db, err := start(runner, WithReuse(true))
+	var startErr *StartError
+	if !errors.As(err, &startErr) || startErr.Phase != PhaseWait {
+		t.Fatalf("expected wait error of the reused container, got %v", err)
+	}
+	if len(runner.runs) != 1 {
+		t.Fatalf("expected the running container to be reused, got %d runs", len(runner.runs))
+	}
+	if db.containerID != "fake-1" || !db.reused {
+		t.Fatalf("expected Start to attach to fake-1, got %q", db.containerID)
+	}
+
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+	if len(runner.terminated) != 0 {
+		t.Fatalf("expected the reused container to keep running, got terminated %v", runner.terminated)
+	}
+
+	// other credentials, database or server settings do not match the running container
+	for _, opt := range []Option{
+		WithHost(DefaultUser, "other", DefaultName, port),
+		WithHost(DefaultUser, DefaultPass, "other", port),
+		WithExtensions("citext"),
+		WithDatabaseEncoding("UTF8", "C", "C"),
+	} {
+		other := &fakeRunner{containers: runner.containers}
+		if _, err := start(other, WithReuse(true), opt); err == nil {
+			t.Fatal("expected Start to fail")
+		}
+		if len(other.runs) != 1 {
+			t.Fatalf("expected a new container for different settings, got %d runs", len(other.runs))
+		}
+	}
+
+	// a different port does not match the running container
+	other := &fakeRunner{containers: runner.containers}
+	port = uint32(utils.GetAvailablePort())
+	if _, err := start(other, WithReuse(true)); err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	if len(other.runs) != 1 {
+		t.Fatalf("expected a new container for a different port, got %d runs", len(other.runs))
+	}
+}
+
+func TestStopWaitsForRemoval(t *testing.T) {
+	newStopped := func(t *testing.T, lingers int) (*Postgres, *fakeRunner) {
+		db, err := New(WithPollInterval(time.Millisecond), WithLogger(io.Discard))
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		fake := &fakeRunner{lingers: lingers}
+		c, _ := fake.Run(context.Background(), container.CreateRequest{})
+		db.runner, db.containerID = fake, c.ID
+		return db, fake
+	}
+
+	db, fake := newStopped(t, 3)
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+	if fake.lists != 4 {
+		t.Fatalf("expected Stop to poll until the container is gone, got %d listings", fake.lists)
+	}
+
+	db, _ = newStopped(t, -1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := db.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "container fake-1 is not removed") {
+		t.Fatalf("expected an error for a lingering container, got %v", err)
+	}
+
+	// a container which is stopped but not removed is not listed as running anymore
+	db, fake = newStopped(t, 0)
+	fake.leaks = true
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = db.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "container fake-1 is not removed") {
+		t.Fatalf("expected an error for a stopped container which is not removed, got %v", err)
+	}
+	if running, _ := fake.List(context.Background(), nil); len(running) != 0 {
+		t.Fatalf("expected the leaked container to be listed as stopped only, got %d running", len(running))
+	}
+}
+
+func TestWithShutdownTimeout(t *testing.T) {
+	db, err := New(WithShutdownTimeout(time.Minute), WithPollInterval(time.Millisecond), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	fake := &fakeRunner{}
+	c, _ := fake.Run(context.Background(), container.CreateRequest{})
+	db.runner, db.containerID = fake, c.ID
+
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+	if !reflect.DeepEqual(fake.stopped, []string{c.ID}) || !reflect.DeepEqual(fake.terminated, []string{c.ID}) {
+		t.Fatalf("expected container to be stopped and removed, got stopped %v removed %v", fake.stopped, fake.terminated)
+	}
+	if fake.stopTimeouts[0] != time.Minute {
+		t.Fatalf("expected the configured shutdown timeout, got %s", fake.stopTimeouts[0])
+	}
+
+	// the shutdown context leaves postgres the shutdown timeout and time to remove the container
+	c, _ = fake.Run(context.Background(), container.CreateRequest{})
+	db.abortStart(func(ctx context.Context) error { return db.terminate(ctx, c.ID) })
+	if left := fake.stopLeft[1]; left <= time.Minute || left > time.Minute+removeGrace {
+		t.Fatalf("expected shutdown context of %s, got %s left", time.Minute+removeGrace, left)
+	}
+
+	if _, err := New(WithShutdownTimeout(0)); err == nil {
+		t.Fatal("expected zero shutdown timeout to fail")
+	}
+}
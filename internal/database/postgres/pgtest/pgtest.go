@@ -0,0 +1,126 @@
+package pgtest
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+// shared is the postgres instance used by NewDB, started once per test binary
+var shared struct {
+	once sync.Once
+	db   *pg.Postgres
+	err  error
+}
+
+// MustStartForTest starts a postgres container on a free port and returns a connection to its database,
+// the connection is closed and the container is removed when the test finishes.
+// Given options are applied on top of the defaults, so migrations and fixtures can be passed as well.
+func MustStartForTest(t testing.TB, opts ...pg.Option) *sql.DB {
+	t.Helper()
+
+	ctx := context.Background()
+	port := uint32(utils.GetAvailablePort())
+	options := append([]pg.Option{
+		pg.WithHost(pg.DefaultUser, pg.DefaultPass, pg.DefaultName, port),
+		pg.WithLogger(io.Discard),
+	}, opts...)
+
+	db, err := pg.New(options...)
+	if err != nil {
+		t.Fatalf("create postgres failed: %v", err)
+	}
+
+	if err := db.Start(ctx, true); err != nil {
+		_ = db.Stop(ctx)
+		t.Fatalf("start postgres failed: %v", err)
+	}
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		_ = db.Stop(ctx)
+		t.Fatalf("connect to postgres failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		if err := db.Stop(context.Background()); err != nil {
+			t.Errorf("stop postgres failed: %v", err)
+		}
+	})
+
+	return conn
+}
+
+// NewDB creates an isolated database on a postgres instance shared by the whole test binary
+// and returns a connection to it. The database is removed when the test finishes.
+// The shared instance is started on the first call, it's safe to call NewDB from parallel tests.
+// Use Main in TestMain to remove the shared instance after all tests are done:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(pgtest.Main(m))
+//	}
+//
+//	func TestFoo(t *testing.T) {
+//		t.Parallel()
+//		conn := pgtest.NewDB(t)
+//		...
+//	}
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	shared.once.Do(func() {
+		port := uint32(utils.GetAvailablePort())
+		shared.db, shared.err = pg.New(
+			pg.WithHost(pg.DefaultUser, pg.DefaultPass, pg.DefaultName, port),
+			pg.WithLogger(io.Discard),
+		)
+		if shared.err != nil {
+			return
+		}
+		shared.err = shared.db.Start(context.Background(), true)
+	})
+	if shared.err != nil {
+		t.Fatalf("start shared postgres failed: %v", shared.err)
+	}
+
+	ctx := context.Background()
+	// name databases after the test, so leftovers are easy to trace back
+	res, err := shared.db.CreateDB(ctx, &database.CreateDBRequest{Prefix: t.Name()})
+	if err != nil {
+		t.Fatalf("create database failed: %v", err)
+	}
+
+	conn, err := sql.Open("postgres", res.URI)
+	if err == nil {
+		err = conn.PingContext(ctx)
+	}
+	if err != nil {
+		_ = shared.db.RemoveDB(ctx, res.URI)
+		t.Fatalf("connect to database failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		if err := shared.db.RemoveDB(context.Background(), res.URI); err != nil {
+			t.Errorf("remove database failed: %v", err)
+		}
+	})
+
+	return conn
+}
+
+// Main runs the tests and stops the shared instance started by NewDB, if any
+func Main(m *testing.M) int {
+	code := m.Run()
+	if shared.db != nil {
+		_ = shared.db.Stop(context.Background())
+	}
+	return code
+}
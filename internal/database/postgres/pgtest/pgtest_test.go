@@ -0,0 +1,70 @@
+package pgtest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(Main(m))
+}
+
+func TestMustStartForTest(t *testing.T) {
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	conn := MustStartForTest(t)
+
+	if _, err := conn.Exec("create table foo(id int, name varchar(20))"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Exec("insert into foo (id, name) values (1, 'foo')"); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	if err := conn.QueryRow("select name from foo where id = 1").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "foo" {
+		t.Fatalf("expected name to be foo, got %s", name)
+	}
+}
+
+func TestNewDB(t *testing.T) {
+	if err := container.Ping(context.Background()); err != nil {
+		t.Skipf("docker is not reachable: %s", err)
+	}
+
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := NewDB(t)
+			// every test gets its own database, so the same table can be created in each of them
+			if _, err := conn.Exec("create table foo(name varchar(20))"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := conn.Exec("insert into foo (name) values ($1)", name); err != nil {
+				t.Fatal(err)
+			}
+
+			var count int
+			if err := conn.QueryRow("select count(*) from foo").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 1 {
+				t.Fatalf("expected 1 row, got %d", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithMigrationsRemote(t *testing.T) {
+	// downloads are cached in the temporary directory
+	t.Setenv("TMPDIR", t.TempDir())
+
+	const migration = "create table foo(id int);"
+	dir := t.TempDir()
+	writeTarGz(t, filepath.Join(dir, "bundle.tar.gz"), [][2]string{{"001_foo.up.sql", migration}, {"001_foo.down.sql", "drop table foo;"}})
+
+	var downloads int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/001_foo.sql", func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		_, _ = w.Write([]byte(migration))
+	})
+	mux.HandleFunc("/bundle.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(dir, "bundle.tar.gz"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(migration))
+	db, err := New(WithMigrations(srv.URL + "/001_foo.sql#sha256=" + hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	files := db.cfg.migrationsFiles
+	if len(files) != 1 || filepath.Base(files[0]) != "001_foo.sql" {
+		t.Fatalf("expected the downloaded migration, got %v", files)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil || string(b) != migration {
+		t.Fatalf("unexpected downloaded migration %q, %v", b, err)
+	}
+	if err := ValidateMigrations(files); err != nil {
+		t.Fatalf("ValidateMigrations failed %s", err)
+	}
+
+	// a download matching the checksum is reused instead of creating a new one
+	again, err := New(WithMigrations(srv.URL + "/001_foo.sql#sha256=" + hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	if again.cfg.migrationsFiles[0] != files[0] || downloads != 1 {
+		t.Fatalf("expected the cached download %s to be reused, got %v after %d downloads", files[0], again.cfg.migrationsFiles, downloads)
+	}
+
+	db, err = New(WithMigrations(srv.URL + "/bundle.tar.gz"))
+	if err != nil {
+		t.Fatalf("New with remote archive failed %s", err)
+	}
+	sources, err := readSQLSources(db.cfg.migrationsFiles[0])
+	if err != nil {
+		t.Fatalf("read remote archive failed %s", err)
+	}
+	if len(sources) != 1 || sources[0].sql != migration {
+		t.Fatalf("expected the up migration of the archive, got %+v", sources)
+	}
+
+	if _, err := New(WithMigrations(srv.URL + "/001_foo.sql#sha256=deadbeef")); err == nil || !strings.Contains(err.Error(), "checksum does not match") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	// a download failing the checksum leaves the cached one alone
+	entries, err := os.ReadDir(filepath.Dir(files[0]))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "001_foo.sql" {
+		t.Fatalf("expected only the verified download to be kept, got %v, %v", entries, err)
+	}
+
+	if _, err := New(WithMigrations(srv.URL + "/001_foo.sql#md5=deadbeef")); err == nil || !strings.Contains(err.Error(), "unsupported checksum") {
+		t.Fatalf("expected unsupported checksum error, got %v", err)
+	}
+
+	if _, err := New(WithMigrations(srv.URL + "/missing.sql")); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
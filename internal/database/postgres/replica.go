@@ -0,0 +1,209 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/database"
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+const (
+	// replicaSlot is the physical replication slot of the read replica on the primary
+	replicaSlot = "dbctl_replica"
+	// replicaHBA allows replication connections from other containers, the default pg_hba only allows local ones
+	replicaHBA = "host replication all all md5"
+)
+
+// startReplica allows replication connections on the primary and starts a replica container cloning it with
+// pg_basebackup, then waits for the replica to stream from the primary
+func (p *Postgres) startReplica(ctx context.Context) (database.CloseFunc, error) {
+	p.log(logger.LevelInfo, "replica_starting", "Starting read replica ...", fields{"port": p.cfg.replicaPort})
+
+	if err := p.allowReplication(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := buildReplicaRequest(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	replica, err := p.runWithTimeout(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	p.replicaID = replica.ID
+
+	closeFunc := func(ctx context.Context) error {
+		return p.terminate(ctx, replica.ID)
+	}
+
+	p.replicaPort = p.cfg.replicaPort
+	if p.replicaPort == 0 {
+		hostPort, err := p.runner.HostPort(ctx, replica.ID, "5432/tcp")
+		if err != nil {
+			return closeFunc, fmt.Errorf("resolve replica port failed: %w", err)
+		}
+		port, err := strconv.ParseUint(hostPort, 10, 32)
+		if err != nil {
+			return closeFunc, fmt.Errorf("invalid replica host port %q: %w", hostPort, err)
+		}
+		p.replicaPort = uint32(port)
+	}
+
+	if err := p.WaitForStart(ctx, p.cfg.startupTimeout); err != nil {
+		return closeFunc, fmt.Errorf("replica is not streaming: %w", err)
+	}
+
+	p.log(logger.LevelInfo, "replica_ready", fmt.Sprintf("Replica uri is: %q", p.ReplicaURI()),
+		fields{"uri": p.ReplicaURI(), "port": p.replicaPort, "container_id": p.replicaID})
+	return closeFunc, nil
+}
+
+// allowReplication adds the replication pg_hba entry to the primary once and drops a stale replication
+// slot left by a previous replica of a reused data directory
+func (p *Postgres) allowReplication(ctx context.Context) error {
+	script := fmt.Sprintf(`grep -qx '%[1]s' "$PGDATA/pg_hba.conf" || echo '%[1]s' >> "$PGDATA/pg_hba.conf"`, replicaHBA)
+	var stderr bytes.Buffer
+	code, err := p.runner.ExecStream(ctx, p.containerID, []string{"sh", "-c", script}, nil, nil, &stderr)
+	if err != nil {
+		return fmt.Errorf("allow replication failed: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("allow replication failed with exit code %d: %s", code, stderr.String())
+	}
+
+	conn, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(ctx, "select pg_reload_conf()"); err != nil {
+		return fmt.Errorf("reload config failed: %w", err)
+	}
+
+	stmt := "select pg_drop_replication_slot(slot_name) from pg_replication_slots where slot_name = $1 and not active"
+	if _, err := conn.ExecContext(ctx, stmt, replicaSlot); err != nil {
+		return fmt.Errorf("drop stale replication slot failed: %w", err)
+	}
+	return nil
+}
+
+// buildReplicaRequest returns the container create request of a replica of the primary. The replica reaches
+// the primary through its host port, clones it with pg_basebackup into an empty data directory and starts
+// postgres as a hot standby streaming over a replication slot.
+func buildReplicaRequest(cfg config) (container.CreateRequest, error) {
+	// the replica gets its data from the primary, not from initdb or the data directory
+	primaryCfg := cfg
+	primaryCfg.initdbArgs, primaryCfg.dataDir = "", ""
+	primary, err := buildCreateRequest(primaryCfg)
+	if err != nil {
+		return container.CreateRequest{}, err
+	}
+
+	port := ""
+	if cfg.replicaPort != 0 {
+		port = strconv.Itoa(int(cfg.replicaPort))
+	}
+
+	labels := make(map[string]string, len(primary.Labels))
+	for k, v := range primary.Labels {
+		labels[k] = v
+	}
+	delete(labels, container.LabelPort)
+	if port != "" {
+		labels[container.LabelPort] = port
+	}
+
+	// primary.Cmd starts with postgres and holds the same server settings
+	script := fmt.Sprintf(`chmod 700 "$PGDATA" && pg_basebackup -D "$PGDATA" -R -X stream -C -S %s && exec %s -c hot_standby=on`,
+		replicaSlot, shellJoin(primary.Cmd))
+
+	return container.CreateRequest{
+		Image: primary.Image,
+		Env: map[string]string{
+			"PGHOST":     dockerHost,
+			"PGPORT":     strconv.Itoa(int(cfg.port)),
+			"PGUSER":     cfg.user,
+			"PGPASSWORD": cfg.pass,
+		},
+		// pg_basebackup and postgres must run as the owner of the data directory
+		User:         "postgres",
+		Cmd:          []string{"sh", "-c", script},
+		ExposedPorts: []string{fmt.Sprintf("%s:5432/tcp", port)},
+		Name:         fmt.Sprintf("%s_replica_%d", cfg.namePrefix, time.Now().UnixNano()),
+		Labels:       labels,
+		ExtraHosts:   dockerHostMapping(runtime.GOOS),
+		Memory:       primary.Memory,
+		NanoCPUs:     primary.NanoCPUs,
+		PullPolicy:   primary.PullPolicy,
+	}, nil
+}
+
+// replicaStreaming reports if the replica accepts queries and streams from the primary over its slot
+func (p *Postgres) replicaStreaming(ctx context.Context) error {
+	replica, err := dbConnect(ctx, p.ReplicaURI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = replica.Close()
+	}()
+
+	if _, err := replica.ExecContext(ctx, "select 1"); err != nil {
+		return err
+	}
+
+	primary, err := dbConnect(ctx, p.URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = primary.Close()
+	}()
+
+	var streaming bool
+	stmt := `select exists(select 1 from pg_stat_replication r join pg_replication_slots s on s.active_pid = r.pid
+		where s.slot_name = $1 and r.state = 'streaming')`
+	if err := primary.QueryRowContext(ctx, stmt, replicaSlot).Scan(&streaming); err != nil {
+		return err
+	}
+	if !streaming {
+		return fmt.Errorf("replica is not streaming yet")
+	}
+	return nil
+}
+
+// ReplicaURI returns the connection uri of the read replica, empty without WithReadReplica
+func (p *Postgres) ReplicaURI() string {
+	if !p.cfg.replica {
+		return ""
+	}
+
+	cfg := p.cfg
+	cfg.port = p.replicaPort
+	cfg.unixSocketDir = ""
+	return (&Postgres{cfg: cfg}).URI()
+}
+
+// shellJoin joins args into a shell command line, quoting every argument
+func shellJoin(args []string) string {
+	var b strings.Builder
+	for i, a := range args {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString("'" + strings.ReplaceAll(a, "'", `'\''`) + "'")
+	}
+	return b.String()
+}
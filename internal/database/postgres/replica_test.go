@@ -0,0 +1,91 @@
+package pg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestBuildReplicaRequest(t *testing.T) {
+	db, err := New(WithHost("app", "secret", "appdb", 25432), WithReadReplica(25433), WithLabels(map[string]string{"team": "core"}))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	req, err := buildReplicaRequest(db.cfg)
+	if err != nil {
+		t.Fatalf("buildReplicaRequest failed %s", err)
+	}
+
+	if req.User != "postgres" || len(req.ExposedPorts) != 1 || req.ExposedPorts[0] != "25433:5432/tcp" {
+		t.Fatalf("unexpected replica request %+v", req)
+	}
+
+	if req.Env["PGHOST"] != dockerHost || req.Env["PGPORT"] != "25432" || req.Env["PGUSER"] != "app" || req.Env["PGPASSWORD"] != "secret" {
+		t.Fatalf("expected replica to connect to the primary, got env %v", req.Env)
+	}
+
+	script := req.Cmd[len(req.Cmd)-1]
+	if !strings.Contains(script, "pg_basebackup") || !strings.Contains(script, "-S "+replicaSlot) || !strings.Contains(script, "hot_standby=on") {
+		t.Fatalf("unexpected replica script %q", script)
+	}
+
+	if req.Labels[container.LabelPort] != "25433" || req.Labels["team"] != "core" {
+		t.Fatalf("unexpected replica labels %v", req.Labels)
+	}
+
+	if db.ReplicaURI() == "" {
+		t.Fatalf("expected a replica uri")
+	}
+}
+
+func TestReplicaURIWithoutReplica(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if uri := db.ReplicaURI(); uri != "" {
+		t.Fatalf("expected empty replica uri, got %q", uri)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	if got := shellJoin([]string{"postgres", "-c", "archive_command=test ! -f it's"}); got != `'postgres' '-c' 'archive_command=test ! -f it'\''s'` {
+		t.Fatalf("unexpected command line %s", got)
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	db := startTestPostgres(t, WithReadReplica(0))
+	ctx := context.Background()
+
+	if err := applySQLStatement(ctx, db.URI(), "create table foo(id int); insert into foo values (42);"); err != nil {
+		t.Fatalf("write to primary failed %s", err)
+	}
+
+	// the replica catches up asynchronously
+	err := utils.WaitFor(ctx, 10*time.Second, 50*time.Millisecond, time.Second, func(ctx context.Context) error {
+		conn, err := dbConnect(ctx, db.ReplicaURI())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		var id int
+		return conn.QueryRowContext(ctx, "select id from foo").Scan(&id)
+	})
+	if err != nil {
+		t.Fatalf("read from replica failed %s", err)
+	}
+
+	if err := applySQLStatement(ctx, db.ReplicaURI(), "insert into foo values (1);"); err == nil {
+		t.Fatalf("expected replica to be read only")
+	}
+}
@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// Reset drops the configured database, terminating all its connections, recreates it and applies
+// extensions, migrations and fixtures again. It is a faster alternative to restarting the container.
+// Init statements and the app user are not applied again, as they usually change the whole cluster.
+// WithSkipMigrations and WithSkipFixtures are respected the same as on Start.
+func (p *Postgres) Reset(ctx context.Context) error {
+	// the configured database can't be dropped while connected to it, so use a maintenance database
+	maintenance := "postgres"
+	if p.cfg.name == maintenance {
+		maintenance = "template1"
+	}
+
+	conn, err := dbConnect(ctx, p.withName(maintenance).URI())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p.log(logger.LevelInfo, "resetting", fmt.Sprintf("Resetting database %q ...", p.cfg.name), fields{"database": p.cfg.name})
+
+	stmt, args := terminateBackendsQuery(p.cfg.name, p.cfg.appName, true)
+	if _, err := conn.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("terminate database connections failed: %w", err)
+	}
+
+	name := pq.QuoteIdentifier(p.cfg.name)
+	if _, err := conn.ExecContext(ctx, "drop database if exists "+name); err != nil {
+		return fmt.Errorf("drop database failed: %w", err)
+	}
+	if err := createDatabase(ctx, conn, p.cfg.name, p.cfg.encoding); err != nil {
+		return err
+	}
+
+	if err := p.createExtensions(ctx, p.URI()); err != nil {
+		return err
+	}
+
+	if !p.cfg.skipMigrations {
+		if err := p.runMigrations(ctx, p.cfg.migrationsFiles, p.URI(), p.cfg.goMigrations...); err != nil {
+			return err
+		}
+	}
+
+	// fixtures are applied along with migrations, same as on Start
+	if p.cfg.seedsOnStart() {
+		return p.applyFixtures(ctx, p.cfg.fixtureFiles, p.URI())
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package pg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_users.up.sql": "create table users(id serial primary key, name text);",
+	})
+	db := startTestPostgres(t, WithMigrations(migrations))
+	ctx := context.Background()
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed %s", err)
+	}
+	if _, err := conn.ExecContext(ctx, "insert into users(name) values ('alice'), ('bob'); create table scratch(id int)"); err != nil {
+		t.Fatalf("insert failed %s", err)
+	}
+
+	// the open connection must not block the reset
+	if err := db.Reset(ctx); err != nil {
+		t.Fatalf("Reset failed %s", err)
+	}
+	_ = conn.Close()
+
+	if n := countRows(t, db.URI(), "users"); n != 0 {
+		t.Fatalf("expected no users after reset, got %d", n)
+	}
+	if n := countRows(t, db.URI(), "information_schema.tables where table_name = 'scratch'"); n != 0 {
+		t.Fatal("expected tables created after migrations to be gone")
+	}
+}
+
+func TestResetKeepsDatabaseEncoding(t *testing.T) {
+	db := startTestPostgres(t, WithDatabaseEncoding("UTF8", "C", "C"))
+	ctx := context.Background()
+
+	if err := db.Reset(ctx); err != nil {
+		t.Fatalf("Reset failed %s", err)
+	}
+
+	conn, err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed %s", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var encoding, collate, ctype string
+	stmt := "select pg_encoding_to_char(encoding), datcollate, datctype from pg_database where datname = current_database()"
+	if err := conn.QueryRowContext(ctx, stmt).Scan(&encoding, &collate, &ctype); err != nil {
+		t.Fatalf("read database encoding failed %s", err)
+	}
+	if encoding != "UTF8" || collate != "C" || ctype != "C" {
+		t.Fatalf("expected UTF8 with C collation after reset, got %s %s %s", encoding, collate, ctype)
+	}
+}
@@ -0,0 +1,123 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+const (
+	// startRetryBaseDelay is the delay before the first start retry, it doubles on each retry
+	startRetryBaseDelay = 500 * time.Millisecond
+	// startRetryMaxDelay is the longest delay between two start retries
+	startRetryMaxDelay = 10 * time.Second
+)
+
+// transientStartErrors are docker error messages of failures that usually go away on their own
+var transientStartErrors = []string{
+	"port is already allocated",
+	"address already in use",
+	"toomanyrequests",
+	"too many requests",
+	"tls handshake timeout",
+	"i/o timeout",
+	"connection reset by peer",
+}
+
+// runContainer runs the postgres container, transient failures are retried up to the configured start retries.
+// A container left behind by a failed attempt is removed before the next one, so its name and port are free again.
+func (p *Postgres) runContainer(ctx context.Context, req container.CreateRequest) (*container.Container, error) {
+	delay := startRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		c, err := p.runWithTimeout(ctx, req)
+		if err == nil {
+			return c, nil
+		}
+
+		if c != nil && c.ID != "" {
+			p.removeFailedContainer(ctx, c.ID)
+		}
+
+		if attempt >= p.cfg.startRetries || !isTransientStartError(err) {
+			if attempt > 0 {
+				return nil, fmt.Errorf("start container failed after %d attempts: %w", attempt+1, err)
+			}
+			return nil, err
+		}
+
+		p.log(logger.LevelWarn, "start_retry", fmt.Sprintf("start container failed: %s, retrying in %s", err, delay),
+			fields{"attempt": attempt + 1, "delay_ms": delay.Milliseconds(), "error": err.Error()})
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.clock.After(delay):
+		}
+
+		delay *= 2
+		if delay > startRetryMaxDelay {
+			delay = startRetryMaxDelay
+		}
+	}
+}
+
+// runWithTimeout runs the container within the pull timeout, so a slow registry can't hang Start
+func (p *Postgres) runWithTimeout(ctx context.Context, req container.CreateRequest) (*container.Container, error) {
+	runCtx, cancel := context.WithTimeout(ctx, p.cfg.pullTimeout)
+	defer cancel()
+
+	c, err := p.runner.Run(runCtx, req)
+	if err != nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		// the container may be created before the request timed out, docker accepts the name as id
+		id := req.Name
+		if c != nil && c.ID != "" {
+			id = c.ID
+		}
+		if id != "" {
+			p.removeFailedContainer(ctx, id)
+		}
+		return nil, fmt.Errorf("run container timed out after %s, is pulling image %s slow? see WithPullTimeout: %w",
+			p.cfg.pullTimeout, req.Image, context.DeadlineExceeded)
+	}
+	return c, err
+}
+
+// removeFailedContainer removes a container left behind by a failed run, a container which was never created
+// is not reported
+func (p *Postgres) removeFailedContainer(ctx context.Context, id string) {
+	err := p.runner.TerminateByID(ctx, id)
+	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "no such container") {
+		p.log(logger.LevelWarn, "container_cleanup_failed", fmt.Sprintf("remove failed container failed: %s", err),
+			fields{"container_id": id, "error": err.Error()})
+	}
+}
+
+// isTransientStartError reports whether a container start failure is worth retrying
+func isTransientStartError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientStartErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
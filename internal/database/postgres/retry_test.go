@@ -0,0 +1,120 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+// instantClock fires After immediately and records the requested delays
+type instantClock struct {
+	delays []time.Duration
+}
+
+func (c *instantClock) Now() time.Time { return time.Unix(0, 0) }
+
+func (c *instantClock) After(d time.Duration) <-chan time.Time {
+	c.delays = append(c.delays, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Unix(0, 0)
+	return ch
+}
+
+func TestRunContainerRetriesTransientErrors(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithStartRetries(3))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	fake := &fakeRunner{runErrs: []error{
+		errors.New("driver failed programming external connectivity: Bind for 0.0.0.0:15432 failed: port is already allocated"),
+		errors.New("toomanyrequests: You have reached your pull rate limit"),
+	}}
+	clk := &instantClock{}
+	db.runner, db.clock = fake, clk
+
+	c, err := db.runContainer(context.Background(), container.CreateRequest{Name: "pg"})
+	if err != nil {
+		t.Fatalf("runContainer failed %s", err)
+	}
+	if c.ID != "fake-3" {
+		t.Fatalf("expected container of the third attempt, got %q", c.ID)
+	}
+	if !reflect.DeepEqual(fake.terminated, []string{"fake-1", "fake-2"}) {
+		t.Fatalf("expected failed containers to be removed, got %v", fake.terminated)
+	}
+	if want := []time.Duration{startRetryBaseDelay, 2 * startRetryBaseDelay}; !reflect.DeepEqual(clk.delays, want) {
+		t.Fatalf("expected backoff %v, got %v", want, clk.delays)
+	}
+}
+
+func TestRunContainerPermanentError(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithStartRetries(3))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	fake := &fakeRunner{runErrs: []error{errors.New("manifest for postgres:99 not found")}}
+	clk := &instantClock{}
+	db.runner, db.clock = fake, clk
+
+	if _, err := db.runContainer(context.Background(), container.CreateRequest{Name: "pg"}); err == nil {
+		t.Fatal("expected permanent error to be returned")
+	}
+	if len(fake.runs) != 1 || len(clk.delays) != 0 {
+		t.Fatalf("expected no retries, got %d runs", len(fake.runs))
+	}
+}
+
+func TestRunContainerRetriesExhausted(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithStartRetries(1))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	transient := errors.New("port is already allocated")
+	db.runner, db.clock = &fakeRunner{runErrs: []error{transient, transient}}, &instantClock{}
+
+	_, err = db.runContainer(context.Background(), container.CreateRequest{Name: "pg"})
+	if !errors.Is(err, transient) || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Fatalf("expected error after 2 attempts, got %v", err)
+	}
+}
+
+func TestWithStartRetries(t *testing.T) {
+	if _, err := New(WithStartRetries(-1)); err == nil {
+		t.Fatal("expected negative retries to fail")
+	}
+}
+
+func TestRunContainerPullTimeout(t *testing.T) {
+	db, err := New(WithLogger(io.Discard), WithStartRetries(3), WithPullTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	fake := &fakeRunner{runBlocks: true}
+	clk := &instantClock{}
+	db.runner, db.clock = fake, clk
+
+	start := time.Now()
+	_, err = db.runContainer(context.Background(), container.CreateRequest{Name: "pg", Image: "postgres:16"})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "WithPullTimeout") {
+		t.Fatalf("expected pull timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected run to be bounded by the pull timeout, took %s", elapsed)
+	}
+	if len(fake.runs) != 1 || len(clk.delays) != 0 {
+		t.Fatalf("expected timeout not to be retried, got %d runs", len(fake.runs))
+	}
+	if !reflect.DeepEqual(fake.terminated, []string{"pg"}) {
+		t.Fatalf("expected partially created container to be removed, got %v", fake.terminated)
+	}
+
+	if _, err := New(WithPullTimeout(0)); err == nil {
+		t.Fatal("expected zero pull timeout to fail")
+	}
+}
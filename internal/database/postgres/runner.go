@@ -0,0 +1,75 @@
+package pg
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/container"
+)
+
+// runner is the container runtime used to manage postgres containers,
+// docker is used by default and tests can replace it with a fake one
+type runner interface {
+	Ping(ctx context.Context) error
+	Run(ctx context.Context, req container.CreateRequest) (*container.Container, error)
+	// EnsureImage makes sure an image is present locally according to the pull policy
+	EnsureImage(ctx context.Context, image string, policy container.PullPolicy) error
+	List(ctx context.Context, labels map[string]string) ([]*container.Container, error)
+	// ListAll lists containers like List, including stopped ones
+	ListAll(ctx context.Context, labels map[string]string) ([]*container.Container, error)
+	// Stop stops a container gracefully, it is killed if it is still running after timeout
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	TerminateByID(ctx context.Context, id string) error
+	Logs(ctx context.Context, id string) ([]byte, error)
+	FollowLogs(ctx context.Context, id string, w io.Writer) error
+	HostPort(ctx context.Context, id, port string) (string, error)
+	ExecStream(ctx context.Context, id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+}
+
+// dockerRunner manages containers using the docker engine api
+type dockerRunner struct{}
+
+func (dockerRunner) Ping(ctx context.Context) error {
+	return container.Ping(ctx)
+}
+
+func (dockerRunner) Run(ctx context.Context, req container.CreateRequest) (*container.Container, error) {
+	return container.Run(ctx, req)
+}
+
+func (dockerRunner) EnsureImage(ctx context.Context, image string, policy container.PullPolicy) error {
+	return container.EnsureImage(ctx, image, policy)
+}
+
+func (dockerRunner) List(ctx context.Context, labels map[string]string) ([]*container.Container, error) {
+	return container.List(ctx, labels)
+}
+
+func (dockerRunner) ListAll(ctx context.Context, labels map[string]string) ([]*container.Container, error) {
+	return container.ListAll(ctx, labels)
+}
+
+func (dockerRunner) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	return container.StopContainer(ctx, id, timeout)
+}
+
+func (dockerRunner) TerminateByID(ctx context.Context, id string) error {
+	return container.TerminateByID(ctx, id)
+}
+
+func (dockerRunner) Logs(ctx context.Context, id string) ([]byte, error) {
+	return container.Logs(ctx, id)
+}
+
+func (dockerRunner) FollowLogs(ctx context.Context, id string, w io.Writer) error {
+	return container.FollowLogs(ctx, id, w, w)
+}
+
+func (dockerRunner) HostPort(ctx context.Context, id, port string) (string, error) {
+	return container.HostPort(ctx, id, port)
+}
+
+func (dockerRunner) ExecStream(ctx context.Context, id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return container.ExecStream(ctx, id, cmd, stdin, stdout, stderr)
+}
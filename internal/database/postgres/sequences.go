@@ -0,0 +1,69 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ownedSequencesQuery lists the sequences of serial and identity columns of user tables, along with their table and column
+const ownedSequencesQuery = `select s.oid::regclass::text, n.nspname, t.relname, a.attname
+	from pg_class s
+	join pg_depend d on d.objid = s.oid and d.classid = 'pg_class'::regclass and d.refclassid = 'pg_class'::regclass
+		and d.deptype in ('a', 'i')
+	join pg_class t on t.oid = d.refobjid
+	join pg_namespace n on n.oid = t.relnamespace
+	join pg_attribute a on a.attrelid = t.oid and a.attnum = d.refobjsubid
+	where s.relkind = 'S' and n.nspname not in ('pg_catalog', 'information_schema')
+	order by 1`
+
+type ownedSequence struct {
+	name, schema, table, column string
+}
+
+// ResetSequences advances the sequence of every serial and identity column to the largest value of its column,
+// so inserts without an id don't conflict with rows inserted with explicit ids, like by fixtures.
+// Sequences of empty tables are left untouched.
+func ResetSequences(ctx context.Context, uri string) error {
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	rows, err := conn.QueryContext(ctx, ownedSequencesQuery)
+	if err != nil {
+		return fmt.Errorf("list sequences failed: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var sequences []ownedSequence
+	for rows.Next() {
+		var s ownedSequence
+		if err := rows.Scan(&s.name, &s.schema, &s.table, &s.column); err != nil {
+			return fmt.Errorf("list sequences failed: %w", err)
+		}
+		sequences = append(sequences, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list sequences failed: %w", err)
+	}
+
+	for _, s := range sequences {
+		if _, err := conn.ExecContext(ctx, resetSequenceStatement(s), s.name); err != nil {
+			return fmt.Errorf("reset sequence %s failed: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// resetSequenceStatement returns the statement setting the sequence, given as $1, to the largest value of its column
+func resetSequenceStatement(s ownedSequence) string {
+	return fmt.Sprintf("select setval($1, max(%s)) from %s.%s having max(%[1]s) is not null",
+		pq.QuoteIdentifier(s.column), pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(s.table))
+}
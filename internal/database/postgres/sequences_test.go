@@ -0,0 +1,39 @@
+package pg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResetSequenceStatement(t *testing.T) {
+	stmt := resetSequenceStatement(ownedSequence{name: "billing.invoices_id_seq", schema: "billing", table: "invoices", column: "id"})
+	expected := `select setval($1, max("id")) from "billing"."invoices" having max("id") is not null`
+	if stmt != expected {
+		t.Fatalf("expected %q, got %q", expected, stmt)
+	}
+}
+
+func TestWithResetSequences(t *testing.T) {
+	fixtures := writeSQLFiles(t, map[string]string{
+		"00_schema.sql": `create table users(id serial primary key, name text);
+			create table orders(id int generated by default as identity primary key, note text);
+			create table empty(id serial primary key);`,
+		"01_rows.sql": `insert into users(id, name) values (1, 'foo'), (7, 'bar');
+			insert into orders(id, note) values (42, 'foo');`,
+	})
+	db := startTestPostgres(t, WithFixtures(fixtures), WithResetSequences(true))
+	ctx := context.Background()
+
+	stmt := `insert into users(name) values ('baz');
+		insert into orders(note) values ('bar');
+		insert into empty default values;`
+	if err := applySQLStatement(ctx, db.URI(), stmt); err != nil {
+		t.Fatalf("insert without id failed %s", err)
+	}
+
+	for table, want := range map[string]int{"users where id = 8": 1, "orders where id = 43": 1, "empty where id = 1": 1} {
+		if n := countRows(t, db.URI(), table); n != want {
+			t.Fatalf("expected %d rows in %s, got %d", want, table, n)
+		}
+	}
+}
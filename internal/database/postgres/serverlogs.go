@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// StreamLogs writes the logs the postgres server writes from now on to w, until ctx is cancelled. If severities
+// like ERROR or WARNING are given, only lines of these severities are written. Statements are logged by postgres
+// only if log_statement is enabled, like by running "alter system set log_statement = 'all'" and reloading.
+func (p *Postgres) StreamLogs(ctx context.Context, w io.Writer, severities ...string) error {
+	if p.containerID == "" {
+		return errors.New("logs can only be streamed from a started container")
+	}
+
+	if len(severities) == 0 {
+		return p.runner.FollowLogs(ctx, p.containerID, w)
+	}
+
+	f := &severityFilter{w: w, severities: severities}
+	if err := p.runner.FollowLogs(ctx, p.containerID, f); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// severityFilter writes the lines of the given severities to w, a line has the severity of the
+// first "SEVERITY:  " marker postgres writes after the log line prefix
+type severityFilter struct {
+	w          io.Writer
+	severities []string
+	buf        []byte
+}
+
+func (f *severityFilter) Write(b []byte) (int, error) {
+	f.buf = append(f.buf, b...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := f.writeLine(f.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		f.buf = f.buf[i+1:]
+	}
+}
+
+// flush writes a last line without line break
+func (f *severityFilter) flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+	line := f.buf
+	f.buf = nil
+	return f.writeLine(line)
+}
+
+func (f *severityFilter) writeLine(line []byte) error {
+	if !f.matches(string(line)) {
+		return nil
+	}
+	_, err := f.w.Write(line)
+	return err
+}
+
+func (f *severityFilter) matches(line string) bool {
+	for _, sev := range f.severities {
+		if strings.Contains(line, strings.ToUpper(sev)+":  ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamLogsSeverities(t *testing.T) {
+	logs := "2024-01-01 10:00:00.000 UTC [1] LOG:  database system is ready to accept connections\n" +
+		"2024-01-01 10:00:01.000 UTC [2] ERROR:  relation \"missing\" does not exist at character 15\n" +
+		"2024-01-01 10:00:01.000 UTC [2] STATEMENT:  select * from missing\n" +
+		"2024-01-01 10:00:02.000 UTC [3] WARNING:  there is no transaction in progress"
+
+	db := &Postgres{containerID: "fake-1", runner: &fakeRunner{logs: logs}}
+
+	stream := func(severities ...string) string {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var out bytes.Buffer
+		if err := db.StreamLogs(ctx, &out, severities...); err != nil {
+			t.Fatalf("StreamLogs failed %s", err)
+		}
+		return out.String()
+	}
+
+	if got := stream(); got != logs {
+		t.Fatalf("expected all logs without severities, got %q", got)
+	}
+
+	expected := "2024-01-01 10:00:01.000 UTC [2] ERROR:  relation \"missing\" does not exist at character 15\n" +
+		"2024-01-01 10:00:02.000 UTC [3] WARNING:  there is no transaction in progress"
+	if got := stream("error", "WARNING"); got != expected {
+		t.Fatalf("expected error and warning lines, got %q", got)
+	}
+
+	if err := (&Postgres{}).StreamLogs(context.Background(), &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error without a container")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStreamLogs(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	// alter system can't run in a transaction, so the statements are sent one by one
+	for _, stmt := range []string{"alter system set log_statement = 'all'", "select pg_reload_conf()"} {
+		if err := applySQLStatement(ctx, db.URI(), stmt); err != nil {
+			t.Fatalf("enable statement logging failed %s", err)
+		}
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	var logs syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- db.StreamLogs(streamCtx, &logs)
+	}()
+
+	// only logs written after streaming started are streamed, so the statement is repeated until it shows up
+	const marker = "select 'dbctl_stream_logs_marker'"
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(logs.String(), marker) {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("expected statement in streamed logs, got %q", logs.String())
+		}
+		if err := applySQLStatement(ctx, db.URI(), marker); err != nil {
+			t.Fatalf("run statement failed %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StreamLogs failed %s", err)
+	}
+}
@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mirzakhany/dbctl/internal/logger"
+)
+
+// templateHashPrefix starts the comment of a template database holding the hash of the migrations it is built from
+const templateHashPrefix = "dbctl_migrations_sha256="
+
+// migrationsHash returns the sha256 of the sql of migration files in the order they are applied, so editing,
+// adding or reordering migrations changes it, while moving the files does not. Go migrations are hashed by
+// version and the checksum of a ChecksumMigrator, as their code is not known.
+func migrationsHash(files []string, migrators ...Migrator) (string, error) {
+	h := sha256.New()
+	for _, f := range files {
+		sources, err := readSQLSources(f)
+		if err != nil {
+			return "", fmt.Errorf("read file (%s) failed: %w", f, err)
+		}
+		for _, src := range sources {
+			// the length keeps the boundaries between sources
+			fmt.Fprintf(h, "%d:%s", len(src.sql), src.sql)
+		}
+	}
+	for _, m := range migrators {
+		fmt.Fprintf(h, "go:%d:%s", len(m.Version()), m.Version())
+		if cm, ok := m.(ChecksumMigrator); ok {
+			fmt.Fprintf(h, ":%d:%s", len(cm.Checksum()), cm.Checksum())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// templateHash returns the migrations hash stored on the template database, empty if the template has none.
+// exists reports whether the template database exists.
+func templateHash(ctx context.Context, conn *sql.DB, name string) (hash string, exists bool, err error) {
+	var comment sql.NullString
+	stmt := "select shobj_description(oid, 'pg_database') from pg_database where datname = $1"
+	if err := conn.QueryRowContext(ctx, stmt, name).Scan(&comment); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("read template hash failed: %w", err)
+	}
+	hash, _ = strings.CutPrefix(comment.String, templateHashPrefix)
+	if hash == comment.String {
+		// not a comment of dbctl
+		hash = ""
+	}
+	return hash, true, nil
+}
+
+// dropStaleTemplate drops the template database if it is built from migrations other than the ones of hash
+// and reports whether an up to date template exists. A template without hash is kept, it may be in the
+// middle of being saved by a concurrent CreateDB.
+func (p *Postgres) dropStaleTemplate(ctx context.Context, conn *sql.DB, name, hash string) (bool, error) {
+	current, exists, err := templateHash(ctx, conn, name)
+	if err != nil || !exists || current == "" {
+		return false, err
+	}
+	if current == hash {
+		return true, nil
+	}
+
+	p.log(logger.LevelInfo, "template_drift", fmt.Sprintf("Migrations of template %s changed, rebuilding it ...", name),
+		fields{"template": name, "hash": hash, "previous_hash": current})
+	return false, p.DropDB(ctx, p.withName(name).URI(), true)
+}
+
+// saveTemplate clones the source database into the template database built from the migrations of hash and
+// stores the hash on it. A stale template is rebuilt, so databases cloned from it get the current schema.
+func (p *Postgres) saveTemplate(ctx context.Context, conn *sql.DB, source, name, hash string) error {
+	fresh, err := p.dropStaleTemplate(ctx, conn, name, hash)
+	if err != nil || fresh {
+		return err
+	}
+
+	if err := p.clone(ctx, conn, source, name); err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf("comment on database %s is %s", pq.QuoteIdentifier(name), pq.QuoteLiteral(templateHashPrefix+hash))
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("store template hash failed: %w", err)
+	}
+	return nil
+}
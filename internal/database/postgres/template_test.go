@@ -0,0 +1,155 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+	"github.com/mirzakhany/dbctl/internal/utils"
+)
+
+func TestMigrationsHash(t *testing.T) {
+	dir := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int);",
+		"0002_bar.up.sql": "create table bar(id int);",
+	})
+	files, err := GetFiles(dir)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+
+	hash, err := migrationsHash(files)
+	if err != nil {
+		t.Fatalf("migrationsHash failed %s", err)
+	}
+
+	// the same sql in another directory has the same hash
+	moved, err := GetFiles(writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int);",
+		"0002_bar.up.sql": "create table bar(id int);",
+	}))
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+	if got, _ := migrationsHash(moved); got != hash {
+		t.Fatalf("expected moved migrations to keep hash %s, got %s", hash, got)
+	}
+
+	if got, _ := migrationsHash([]string{files[1], files[0]}); got == hash {
+		t.Fatal("expected reordered migrations to change the hash")
+	}
+
+	if err := os.WriteFile(files[1], []byte("create table bar(id int, name text);"), 0o644); err != nil {
+		t.Fatalf("write file failed %s", err)
+	}
+	if got, _ := migrationsHash(files); got == hash {
+		t.Fatal("expected an edited migration to change the hash")
+	}
+
+	hash, _ = migrationsHash(files, GoMigration{ID: "0003", Hash: "v1"})
+	if got, _ := migrationsHash(files, GoMigration{ID: "0003", Hash: "v2"}); got == hash {
+		t.Fatal("expected a new go migration checksum to change the hash")
+	}
+}
+
+func TestTemplateRebuiltOnMigrationChange(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001_foo.up.sql": "create table foo(id int);",
+	})
+	if _, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations}); err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+
+	// same file name, so the same template name, but a different schema
+	file := filepath.Join(migrations, "0001_foo.up.sql")
+	if err := os.WriteFile(file, []byte("create table foo(id int, name text default 'new');"), 0o644); err != nil {
+		t.Fatalf("write file failed %s", err)
+	}
+
+	res, err := db.CreateDB(ctx, &database.CreateDBRequest{Migrations: migrations})
+	if err != nil {
+		t.Fatalf("CreateDB failed %s", err)
+	}
+	if err := applySQLStatement(ctx, res.URI, "insert into foo (id) values (1)"); err != nil {
+		t.Fatalf("insert failed %s", err)
+	}
+	if n := countRows(t, res.URI, "foo where name = 'new'"); n != 1 {
+		t.Fatalf("expected the database to get the changed schema, got %d rows", n)
+	}
+
+	// a template of a previous Start on a long lived server is rebuilt as well
+	start := func(sql string) {
+		t.Helper()
+		dir := writeSQLFiles(t, map[string]string{"0001_init.up.sql": sql})
+		external, err := New(WithExternalURI(db.URI()), WithMigrations(dir))
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		if err := external.Start(ctx, true); err != nil {
+			t.Fatalf("Start failed %s", err)
+		}
+	}
+	start("create table if not exists a(id int);")
+	start("create table if not exists b(id int);")
+
+	template := db.withName(DefaultTemplate)
+	if n := countRows(t, template.URI(), "pg_tables where tablename = 'b'"); n != 1 {
+		t.Fatalf("expected %s to be rebuilt with the new migrations", DefaultTemplate)
+	}
+}
+
+func TestTemplateFailed(t *testing.T) {
+	var logs syncBuffer
+	db, err := New(WithLogFormat(LogJSON), WithLogger(&logs))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	if err := db.templateFailed(DefaultTemplate, errors.New("disk full")); err != nil {
+		t.Fatalf("expected template failure to be logged only, got %s", err)
+	}
+	if !strings.Contains(logs.String(), `"event":"template_failed"`) || !strings.Contains(logs.String(), "disk full") {
+		t.Fatalf("expected template_failed event, got %s", logs.String())
+	}
+
+	db, err = New(WithStrictTemplate(true))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	if err := db.templateFailed(DefaultTemplate, errors.New("disk full")); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected template failure to be returned, got %v", err)
+	}
+}
+
+func TestWithStrictTemplate(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{"0001_foo.up.sql": "create table foo(id int);"})
+	// a database taking the name of the template makes saving the template fail
+	occupy := WithInitSQL("create database " + DefaultTemplate)
+
+	var logs syncBuffer
+	startTestPostgres(t, WithMigrations(migrations), occupy, WithLogFormat(LogJSON), WithLogger(&logs))
+	if !strings.Contains(logs.String(), `"event":"template_failed"`) {
+		t.Fatalf("expected template_failed event, got %s", logs.String())
+	}
+
+	db, err := New(WithHost(DefaultUser, DefaultPass, DefaultName, uint32(utils.GetAvailablePort())), WithLogger(io.Discard),
+		WithMigrations(migrations), occupy, WithStrictTemplate(true))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	err = db.Start(context.Background(), true)
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) || !strings.Contains(err.Error(), "create template "+DefaultTemplate) {
+		t.Fatalf("expected template creation to fail Start, got %v", err)
+	}
+}
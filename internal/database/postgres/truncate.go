@@ -0,0 +1,84 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type truncateConfig struct {
+	exclude map[string]bool
+}
+
+// TruncateOption configures Truncate
+type TruncateOption func(*truncateConfig)
+
+// WithExcludeTables keeps the given tables untouched by Truncate,
+// tables can be given as name (public schema) or schema.name
+func WithExcludeTables(tables ...string) TruncateOption {
+	return func(c *truncateConfig) {
+		for _, t := range tables {
+			if !strings.Contains(t, ".") {
+				t = "public." + t
+			}
+			c.exclude[t] = true
+		}
+	}
+}
+
+// Truncate removes all rows from all user tables of the database and resets their sequences,
+// it is a faster alternative to recreating the database between tests. The table tracking applied migrations is kept,
+// in whichever schema of the search path it was created.
+func Truncate(ctx context.Context, uri string, opts ...TruncateOption) error {
+	cfg := &truncateConfig{exclude: map[string]bool{}}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	conn, err := dbConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	rows, err := conn.QueryContext(ctx, `select table_schema, table_name from information_schema.tables
+		where table_type = 'BASE TABLE' and table_schema not in ('pg_catalog', 'information_schema')`)
+	if err != nil {
+		return fmt.Errorf("list tables failed: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var tables []string
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return fmt.Errorf("list tables failed: %w", err)
+		}
+
+		if name != migrationsTable && !cfg.exclude[schema+"."+name] {
+			tables = append(tables, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(name))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list tables failed: %w", err)
+	}
+
+	if len(tables) == 0 {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, truncateStatement(tables)); err != nil {
+		return fmt.Errorf("truncate tables failed: %w", err)
+	}
+	return nil
+}
+
+func truncateStatement(tables []string) string {
+	return fmt.Sprintf("TRUNCATE %s RESTART IDENTITY CASCADE", strings.Join(tables, ", "))
+}
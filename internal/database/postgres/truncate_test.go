@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTruncateStatement(t *testing.T) {
+	stmt := truncateStatement([]string{`"public"."users"`, `"billing"."invoices"`})
+	expected := `TRUNCATE "public"."users", "billing"."invoices" RESTART IDENTITY CASCADE`
+	if stmt != expected {
+		t.Fatalf("expected %q, got %q", expected, stmt)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	db := startTestPostgres(t)
+	ctx := context.Background()
+
+	stmt := `create table users(id serial primary key, name text);
+		create table orders(id serial primary key, user_id int references users(id));
+		create table schema_migrations(version int);
+		insert into users(name) values ('foo'), ('bar');
+		insert into orders(user_id) values (1), (2);
+		insert into schema_migrations values (1);
+		create schema app;
+		create table app.dbctl_schema_migrations(version text);
+		insert into app.dbctl_schema_migrations values ('0001');`
+	if err := applySQLStatement(ctx, db.URI(), stmt); err != nil {
+		t.Fatalf("seed database failed %s", err)
+	}
+
+	if err := Truncate(ctx, db.URI(), WithExcludeTables("schema_migrations")); err != nil {
+		t.Fatalf("Truncate failed %s", err)
+	}
+
+	for _, table := range []string{"users", "orders"} {
+		if n := countRows(t, db.URI(), table); n != 0 {
+			t.Fatalf("expected no rows in %s, got %d", table, n)
+		}
+	}
+
+	if n := countRows(t, db.URI(), "schema_migrations"); n != 1 {
+		t.Fatalf("expected excluded table to keep its rows, got %d", n)
+	}
+
+	// the migrations table is created in the first schema of the search path, not only public
+	if n := countRows(t, db.URI(), "app.dbctl_schema_migrations"); n != 1 {
+		t.Fatalf("expected the migrations table to keep its rows, got %d", n)
+	}
+
+	if err := applySQLStatement(ctx, db.URI(), "insert into users(name) values ('baz')"); err != nil {
+		t.Fatalf("insert failed %s", err)
+	}
+
+	if n := countRows(t, db.URI(), "users where id = 1"); n != 1 {
+		t.Fatal("expected sequence to be reset")
+	}
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+)
+
+// UIBackend is the web ui started next to the database
+type UIBackend string
+
+const (
+	// UINone disables the ui
+	UINone UIBackend = ""
+	// UIPgweb runs pgweb (https://github.com/sosedoff/pgweb)
+	UIPgweb UIBackend = "pgweb"
+	// UIAdminer runs adminer (https://www.adminer.org)
+	UIAdminer UIBackend = "adminer"
+)
+
+// dockerHost is the host name containers use to reach the docker host
+const dockerHost = "host.docker.internal"
+
+// uiBackend describes how to run a ui container
+type uiBackend struct {
+	name  string
+	image string
+	label string
+	// port is the port the ui listens on inside the container, it's published on the same host port
+	port string
+	// env returns the container environment, uri is the database uri reachable from inside the container
+	env func(uri string) (map[string]string, error)
+}
+
+var uiBackends = map[UIBackend]uiBackend{
+	UIPgweb: {
+		name:  "pgweb (https://github.com/sosedoff/pgweb)",
+		image: "sosedoff/pgweb:latest",
+		label: database.LabelPGWeb,
+		port:  "8081",
+		env: func(uri string) (map[string]string, error) {
+			return map[string]string{"PGWEB_DATABASE_URL": uri}, nil
+		},
+	},
+	UIAdminer: {
+		name:  "adminer (https://www.adminer.org)",
+		image: "adminer:latest",
+		label: database.LabelAdminer,
+		port:  "8080",
+		env: func(uri string) (map[string]string, error) {
+			u, err := url.Parse(uri)
+			if err != nil {
+				return nil, fmt.Errorf("parse database uri failed: %w", err)
+			}
+			return map[string]string{"ADMINER_DEFAULT_SERVER": u.Host}, nil
+		},
+	},
+}
+
+// dockerHostMapping returns the extra hosts a container needs to resolve the docker host on goos.
+// Docker Desktop resolves host.docker.internal by itself, docker on linux needs it mapped to the host gateway.
+func dockerHostMapping(goos string) []string {
+	if goos != "linux" {
+		return nil
+	}
+	return []string{dockerHost + ":host-gateway"}
+}
+
+// dockerInternalURI returns uri reachable from inside a container, localhost is replaced by the docker host
+func dockerInternalURI(uri string) string {
+	return strings.ReplaceAll(uri, "localhost", dockerHost)
+}
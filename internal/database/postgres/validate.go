@@ -0,0 +1,298 @@
+package pg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ValidateMigrations checks migration files before they are applied, so broken files fail fast instead of after
+// the container boots. Files must exist, be readable and not empty, and their sql must have balanced quotes,
+// comments and parentheses. It does not validate the sql grammar, which is left to postgres.
+func ValidateMigrations(files []string) error {
+	var errs []error
+	for _, f := range files {
+		if err := validateSQLFile(f); err != nil {
+			errs = append(errs, fmt.Errorf("invalid migration file (%s): %w", f, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FixturesChecksum returns the hex sha256 of the contents of fixture files concatenated in the given order,
+// the value to pass to WithFixturesChecksum
+func FixturesChecksum(files []string) (string, error) {
+	h := sha256.New()
+	for _, file := range files {
+		if err := hashFile(h, file); err != nil {
+			return "", fmt.Errorf("read fixture file (%s) failed: %w", file, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// verifyFixturesChecksum checks the checksum of fixture files matches expected, see WithFixturesChecksum
+func verifyFixturesChecksum(files []string, expected string) error {
+	got, err := FixturesChecksum(files)
+	if err != nil {
+		return err
+	}
+	if got != expected {
+		return fmt.Errorf("fixtures checksum does not match, expected sha256 %s, got %s", expected, got)
+	}
+	return nil
+}
+
+func validateSQLFile(file string) error {
+	sources, err := readSQLSources(file)
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 {
+		return errors.New("archive has no sql files")
+	}
+	for _, src := range sources {
+		if err := validateSQL(src.sql); err != nil {
+			if src.name != file {
+				// point at the entry of the archive
+				return fmt.Errorf("%s: %w", src.name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSQL(sql string) error {
+	if strings.TrimSpace(sql) == "" {
+		return errors.New("file is empty")
+	}
+	return checkSQLTokens(sql)
+}
+
+// checkSQLTokens scans sql and reports unterminated strings, quoted identifiers, comments, dollar quoted
+// strings and unbalanced parentheses, along with the line they start at
+func checkSQLTokens(sql string) error {
+	var parens []int
+	line := 1
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return nil
+			}
+			i += end - 1
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end, lines, ok := skipBlockComment(sql, i)
+			if !ok {
+				return fmt.Errorf("unterminated comment starting at line %d", line)
+			}
+			i, line = end, line+lines
+		case c == '\'' || c == '"':
+			// backslash escapes quotes only in escape strings, like E'it\'s'
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i == 1 || !isIdentChar(sql[i-2]))
+			end, lines, ok := skipQuoted(sql, i, c, escapes)
+			if !ok {
+				kind := "string"
+				if c == '"' {
+					kind = "quoted identifier"
+				}
+				return fmt.Errorf("unterminated %s starting at line %d", kind, line)
+			}
+			i, line = end, line+lines
+		case c == '$':
+			tag, ok := dollarTag(sql, i)
+			if !ok {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				return fmt.Errorf("unterminated dollar quoted string starting at line %d", line)
+			}
+			body := sql[i : i+len(tag)+end+len(tag)]
+			line += strings.Count(body, "\n")
+			i += len(body) - 1
+		case c == '(':
+			parens = append(parens, line)
+		case c == ')':
+			if len(parens) == 0 {
+				return fmt.Errorf("unexpected closing parenthesis at line %d", line)
+			}
+			parens = parens[:len(parens)-1]
+		}
+	}
+
+	if len(parens) > 0 {
+		return fmt.Errorf("unclosed parenthesis at line %d", parens[len(parens)-1])
+	}
+	return nil
+}
+
+// SplitStatements splits sql into statements on semicolons outside strings, quoted identifiers, comments
+// and dollar quoted strings, for drivers that run a single statement per exec. Empty statements are dropped
+func SplitStatements(sql string) []string {
+	ranges := statementRanges(sql)
+	stmts := make([]string, len(ranges))
+	for i, r := range ranges {
+		stmts[i] = sql[r[0]:r[1]]
+	}
+	return stmts
+}
+
+// statementRanges returns the start and end offsets of the statements of sql, without surrounding
+// whitespace and the terminating semicolon, see SplitStatements
+func statementRanges(sql string) [][2]int {
+	var ranges [][2]int
+	start := 0
+	add := func(end int) {
+		from, to := start, end
+		for from < to && isSpace(sql[from]) {
+			from++
+		}
+		for to > from && isSpace(sql[to-1]) {
+			to--
+		}
+		if from < to {
+			ranges = append(ranges, [2]int{from, to})
+		}
+		start = end + 1
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == ';':
+			add(i)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+				break
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end, _, ok := skipBlockComment(sql, i)
+			if !ok {
+				i = len(sql)
+				break
+			}
+			i = end
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i == 1 || !isIdentChar(sql[i-2]))
+			end, _, ok := skipQuoted(sql, i, c, escapes)
+			if !ok {
+				i = len(sql)
+				break
+			}
+			i = end
+		case c == '$':
+			tag, ok := dollarTag(sql, i)
+			if !ok {
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				i = len(sql)
+				break
+			}
+			i += len(tag) + end + len(tag) - 1
+		}
+	}
+	add(len(sql))
+	return ranges
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// skipBlockComment returns the index of the end of a possibly nested block comment starting at i
+func skipBlockComment(sql string, i int) (end, lines int, ok bool) {
+	depth := 0
+	for ; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\n':
+			lines++
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i++
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i++
+			if depth == 0 {
+				return i, lines, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// skipQuoted returns the index of the closing quote of a string or identifier starting at i,
+// doubled quotes are part of the value
+func skipQuoted(sql string, i int, quote byte, escapes bool) (end, lines int, ok bool) {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\n':
+			lines++
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i, lines, true
+		}
+	}
+	return 0, 0, false
+}
+
+// dollarTag returns the dollar quote tag like $$ or $body$ starting at i, positional parameters like $1
+// and dollars inside identifiers are not tags
+func dollarTag(sql string, i int) (string, bool) {
+	if i > 0 && isIdentChar(sql[i-1]) {
+		return "", false
+	}
+
+	for j := i + 1; j < len(sql); j++ {
+		switch c := sql[j]; {
+		case c == '$':
+			return sql[i : j+1], true
+		case c >= '0' && c <= '9':
+			if j == i+1 {
+				return "", false
+			}
+		case !isIdentChar(c):
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
@@ -0,0 +1,160 @@
+package pg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSQLTokens(t *testing.T) {
+	valid := []string{
+		"create table foo(id int, name text default 'it''s');",
+		"insert into foo values (1, E'it\\'s (');",
+		`create table "weird ""name"" (" (id int);`,
+		"-- comment with ' and (\nselect 1;",
+		"/* outer /* nested ' */ ( */ select 1;",
+		"create function f() returns int as $$ select ')' $$ language sql;",
+		"create function g() returns int as $body$ select $$ $body$ language sql;",
+		"select $1, a$b from foo;",
+	}
+	for _, sql := range valid {
+		if err := checkSQLTokens(sql); err != nil {
+			t.Fatalf("expected %q to be valid, got %s", sql, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"select 'foo;":                              "unterminated string starting at line 1",
+		"select 1;\nselect \"foo;":                  "unterminated quoted identifier starting at line 2",
+		"/* select 1;":                              "unterminated comment",
+		"create function f() as $$ select 1;":       "unterminated dollar quoted string",
+		"create table foo(id int;":                  "unclosed parenthesis at line 1",
+		"select 1);":                                "unexpected closing parenthesis",
+		"select 'a\nb';\ncreate table foo(\nid int": "unclosed parenthesis at line 3",
+	}
+	for sql, msg := range invalid {
+		err := checkSQLTokens(sql)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected %q to fail with %q, got %v", sql, msg, err)
+		}
+	}
+}
+
+func TestValidateMigrationsBeforeStart(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{
+		"0001.up.sql": "create table foo(id int);",
+		"0002.up.sql": "create table bar(id int);",
+	})
+
+	db, err := New(WithLogger(io.Discard), WithMigrations(migrations))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+	fake := &fakeRunner{}
+	db.runner = fake
+
+	if err := os.Remove(filepath.Join(migrations, "0002.up.sql")); err != nil {
+		t.Fatalf("remove migration failed %s", err)
+	}
+
+	err = db.Start(context.Background(), true)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "0002.up.sql") {
+		t.Fatalf("expected missing migration error, got %v", err)
+	}
+
+	if len(fake.runs) != 0 {
+		t.Fatalf("expected no container to be created, got %d", len(fake.runs))
+	}
+}
+
+func TestValidateMigrationsEmptyFile(t *testing.T) {
+	migrations := writeSQLFiles(t, map[string]string{"0001.up.sql": "  \n"})
+	files, err := GetFiles(migrations)
+	if err != nil {
+		t.Fatalf("GetFiles failed %s", err)
+	}
+
+	if err := ValidateMigrations(files); err == nil || !strings.Contains(err.Error(), "file is empty") {
+		t.Fatalf("expected empty file error, got %v", err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	sql := "create table foo(name text default 'a;b');\n" +
+		"-- comment; here\ninsert into \"x;y\" values (E'it\\'s;');\n" +
+		"/* block; /* nested; */ */ select 1;;\n" +
+		"create function f() returns int as $body$ select 1; $body$ language sql;\n" +
+		"select 2"
+	want := []string{
+		"create table foo(name text default 'a;b')",
+		"-- comment; here\ninsert into \"x;y\" values (E'it\\'s;')",
+		"/* block; /* nested; */ */ select 1",
+		"create function f() returns int as $body$ select 1; $body$ language sql",
+		"select 2",
+	}
+
+	got := SplitStatements(sql)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statements, got %q", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("statement %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	if got := SplitStatements(" ;\n-- only a comment"); len(got) != 1 || got[0] != "-- only a comment" {
+		t.Fatalf("unexpected statements %q", got)
+	}
+}
+
+func TestWithFixturesChecksum(t *testing.T) {
+	fixtures := writeSQLFiles(t, map[string]string{
+		"01_users.sql":  "insert into users values (1);",
+		"02_orders.sql": "insert into orders values (1);",
+	})
+	// the sha256 of the files concatenated in order
+	sum := sha256.Sum256([]byte("insert into users values (1);insert into orders values (1);"))
+	checksum := hex.EncodeToString(sum[:])
+
+	files, err := GetFiles(fixtures)
+	if err != nil {
+		t.Fatalf("getFiles failed %s", err)
+	}
+	if got, err := FixturesChecksum(files); err != nil || got != checksum {
+		t.Fatalf("expected checksum %s, got %s (%v)", checksum, got, err)
+	}
+
+	start := func(checksum string) error {
+		t.Helper()
+		db, err := New(WithFixtures(fixtures), WithFixturesChecksum(checksum), WithLogger(io.Discard))
+		if err != nil {
+			t.Fatalf("New failed %s", err)
+		}
+		// a verified checksum goes on to ping docker
+		db.runner = &fakeRunner{pingErr: errors.New("docker is not available")}
+		return db.Start(context.Background(), true)
+	}
+
+	var startErr *StartError
+	if err := start(strings.ToUpper(checksum)); !errors.As(err, &startErr) || startErr.Phase != PhaseContainer {
+		t.Fatalf("expected matching checksum to pass, got %v", err)
+	}
+
+	other := sha256.Sum256([]byte("edited"))
+	err = start(hex.EncodeToString(other[:]))
+	if !errors.As(err, &startErr) || startErr.Phase != PhaseFixtures || !strings.Contains(err.Error(), checksum) {
+		t.Fatalf("expected checksum mismatch naming the actual checksum, got %v", err)
+	}
+
+	if _, err := New(WithFixturesChecksum("abc")); err == nil {
+		t.Fatal("expected error for invalid checksum")
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/mirzakhany/dbctl/internal/container"
 	"github.com/mirzakhany/dbctl/internal/database"
 	"github.com/mirzakhany/dbctl/internal/logger"
+	"github.com/mirzakhany/dbctl/internal/utils"
 )
 
 var (
@@ -67,13 +68,21 @@ func (p *Redis) CreateDB(ctx context.Context, req *database.CreateDBRequest) (*d
 	}
 
 	p.cfg.dbIndex = dbIndex
-	uri := p.URI()
+	uri, host := p.URI(), p.host()
 	// make sure we retrun localhost instead of host.docker.internal
 	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
 		uri = strings.ReplaceAll(uri, "host.docker.internal", "localhost")
+		host = "localhost"
 	}
 
-	return &database.CreateDBResponse{URI: uri}, nil
+	return &database.CreateDBResponse{
+		URI:      uri,
+		Host:     host,
+		Port:     p.cfg.port,
+		User:     p.cfg.user,
+		Password: p.cfg.pass,
+		Database: strconv.Itoa(p.cfg.dbIndex),
+	}, nil
 }
 
 func (p *Redis) getAvailableDBIndex(ctx context.Context) (int, error) {
@@ -142,6 +151,11 @@ func (p *Redis) RemoveDB(ctx context.Context, uri string) error {
 func (p *Redis) Start(ctx context.Context, detach bool) error {
 	log.Printf("Starting redis version %s on port %d ...\n", p.cfg.version, p.cfg.port)
 
+	// fail early with a clear error instead of a low level one from container creation
+	if err := container.Ping(ctx); err != nil {
+		return err
+	}
+
 	closeFunc, err := p.startUsingDocker(ctx, 20*time.Second)
 	if err != nil {
 		_ = closeFunc(ctx)
@@ -176,24 +190,13 @@ func (p *Redis) Stop(ctx context.Context) error {
 // WaitForStart waits for database to boot up
 func (p *Redis) WaitForStart(ctx context.Context, timeout time.Duration) error {
 	logger.Info("Wait for database to boot up")
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	for range ticker.C {
+	return utils.WaitFor(ctx, timeout, 100*time.Millisecond, 2*time.Second, func(ctx context.Context) error {
 		conn, err := redis.DialURLContext(ctx, p.noAuthURI())
 		if err != nil {
-			if err == context.DeadlineExceeded {
-				return err
-			}
-		} else {
-			_ = conn.Close()
-			return nil
+			return err
 		}
-	}
-	return nil
+		return conn.Close()
+	})
 }
 
 // Instances returns a list of running redis instances
@@ -208,7 +211,7 @@ func Instances(ctx context.Context) ([]database.Info, error) {
 		out = append(out, database.Info{
 			ID:     c.ID,
 			Type:   c.Name,
-			Status: database.Running,
+			Status: database.StatusFromState(c.State),
 		})
 	}
 	return out, nil
@@ -254,27 +257,24 @@ func (p *Redis) startUsingDocker(ctx context.Context, timeout time.Duration) (fu
 	return closeFunc, p.setAuth(ctx, p.noAuthURI())
 }
 
-func (p *Redis) noAuthURI() string {
-	addr := "localhost"
+func (p *Redis) host() string {
 	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
-		addr = "host.docker.internal"
+		return "host.docker.internal"
 	}
+	return "localhost"
+}
 
+func (p *Redis) noAuthURI() string {
 	return (&url.URL{
 		Scheme: "redis",
-		Host:   net.JoinHostPort(addr, strconv.Itoa(int(p.cfg.port))),
+		Host:   net.JoinHostPort(p.host(), strconv.Itoa(int(p.cfg.port))),
 		Path:   strconv.Itoa(p.cfg.dbIndex),
 	}).String()
 }
 
 // URI returns the connection string for the database
 func (p *Redis) URI() string {
-	addr := "localhost"
-	if os.Getenv("DBCTL_INSIDE_DOCKER") == "true" {
-		addr = "host.docker.internal"
-	}
-
-	host := net.JoinHostPort(addr, strconv.Itoa(int(p.cfg.port)))
+	host := net.JoinHostPort(p.host(), strconv.Itoa(int(p.cfg.port)))
 
 	var userInfo *url.Userinfo
 	if p.cfg.user != "" && p.cfg.pass != "" {
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+)
+
+type config struct {
+	driver string
+	dsn    string
+	// file is the database file managed by Start and Stop, empty for in memory databases
+	file string
+
+	logger io.Writer
+
+	migrationsFiles []string
+	fixtureFiles    []string
+}
+
+const (
+	// DefaultDriver is the database/sql driver name registered by modernc.org/sqlite
+	DefaultDriver = "sqlite"
+	// DefaultDSN is a shared in memory database, it lives as long as the instance is started
+	DefaultDSN = "file:dbctl?mode=memory&cache=shared"
+)
+
+// Option is the type of the functional options for the sqlite
+type Option func(*config) error
+
+// WithDSN applied selected data source name to config, like file:test.db or :memory:
+func WithDSN(dsn string) Option {
+	return func(c *config) error {
+		if strings.TrimSpace(dsn) == "" {
+			return fmt.Errorf("sqlite dsn can not be empty")
+		}
+		c.dsn = dsn
+		c.file = ""
+		return nil
+	}
+}
+
+// WithFile applied a database file to config, the file is created on start and removed on stop
+func WithFile(path string) Option {
+	return func(c *config) error {
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("sqlite file can not be empty")
+		}
+		c.dsn = "file:" + path
+		c.file = path
+		return nil
+	}
+}
+
+// WithDriver applied selected database/sql driver name to config, default is sqlite
+func WithDriver(name string) Option {
+	return func(c *config) error {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("sqlite driver can not be empty")
+		}
+		c.driver = name
+		return nil
+	}
+}
+
+// WithLogger applied selected logger to config, the start up messages are written to it instead of the default logger
+func WithLogger(logger io.Writer) Option {
+	return func(c *config) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithMigrations applied selected migrations to config, down migrations are ignored
+func WithMigrations(paths ...string) Option {
+	return func(c *config) error {
+		files, err := pg.CollectMigrations(paths)
+		if err != nil {
+			return err
+		}
+		c.migrationsFiles = files
+		return nil
+	}
+}
+
+// WithFixtures applied selected fixtures to config
+func WithFixtures(path string) Option {
+	return func(c *config) error {
+		files, err := pg.GetFiles(path)
+		if err != nil {
+			return err
+		}
+		c.fixtureFiles = files
+		return nil
+	}
+}
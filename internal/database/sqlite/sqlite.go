@@ -0,0 +1,161 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mirzakhany/dbctl/internal/database"
+	pg "github.com/mirzakhany/dbctl/internal/database/postgres"
+	"github.com/mirzakhany/dbctl/internal/logger"
+	"github.com/mirzakhany/dbctl/internal/utils"
+	// registers the sqlite database/sql driver
+	_ "modernc.org/sqlite"
+)
+
+var _ database.Database = (*SQLite)(nil)
+
+// SQLite is an in process sqlite database for tests that don't need a container. Migrations and fixtures
+// are read like the postgres ones and run statement by statement. The database is served by the pure go
+// modernc.org/sqlite driver, unless another one is selected with WithDriver.
+type SQLite struct {
+	cfg config
+	db  *sql.DB
+}
+
+// New creates a new sqlite database instance controller
+func New(options ...Option) (*SQLite, error) {
+	// create sqlite with default values
+	s := &SQLite{cfg: config{
+		driver: DefaultDriver,
+		dsn:    DefaultDSN,
+	}}
+
+	for _, o := range options {
+		if err := o(&s.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Start opens the database and applies migrations and fixtures. The connection pool is kept open until
+// Stop, so an in memory database lives as long as the instance
+func (s *SQLite) Start(ctx context.Context, detach bool) error {
+	if s.db != nil {
+		return errors.New("sqlite is already started")
+	}
+
+	if !isRegistered(s.cfg.driver) {
+		return fmt.Errorf("sql driver %q is not registered, import a sqlite driver like modernc.org/sqlite", s.cfg.driver)
+	}
+
+	db, err := sql.Open(s.cfg.driver, s.cfg.dsn)
+	if err != nil {
+		return fmt.Errorf("open sqlite failed: %w", err)
+	}
+
+	// every connection to :memory: opens a new database, and a shared one is dropped with its last connection,
+	// so a single connection is kept open for the lifetime of the instance
+	if isMemory(s.cfg.dsn) {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("open sqlite failed: %w", err)
+	}
+	s.db = db
+
+	if err := s.applyFiles(ctx, "migrations", s.cfg.migrationsFiles); err != nil {
+		_ = s.Stop(context.Background())
+		return err
+	}
+
+	if err := s.applyFiles(ctx, "fixtures", s.cfg.fixtureFiles); err != nil {
+		_ = s.Stop(context.Background())
+		return err
+	}
+
+	logger.InfoTo(s.cfg.logger, fmt.Sprintf("Database uri is: %q", s.URI()))
+
+	// detach and stop cli if asked
+	if detach {
+		return nil
+	}
+
+	<-ctx.Done()
+	logger.InfoTo(s.cfg.logger, "Shutdown signal received, stopping database")
+	return s.Stop(context.Background())
+}
+
+// applyFiles runs the statements of files in order, kind names the files in errors
+func (s *SQLite) applyFiles(ctx context.Context, kind string, files []string) error {
+	for _, f := range files {
+		stmts, err := pg.ReadStatements(f)
+		if err != nil {
+			return fmt.Errorf("read %s file (%s) failed: %w", kind, f, err)
+		}
+
+		for _, stmt := range stmts {
+			if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("applying file (%s) failed: %w", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Stop closes the database and removes the database file if it is managed by the instance
+func (s *SQLite) Stop(ctx context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+
+	err := s.db.Close()
+	s.db = nil
+	if s.cfg.file != "" {
+		if rmErr := os.Remove(s.cfg.file); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			err = errors.Join(err, fmt.Errorf("remove database file failed: %w", rmErr))
+		}
+	}
+	return err
+}
+
+// WaitForStart waits for the database to accept queries
+func (s *SQLite) WaitForStart(ctx context.Context, timeout time.Duration) error {
+	return utils.WaitFor(ctx, timeout, 10*time.Millisecond, 100*time.Millisecond, func(ctx context.Context) error {
+		if s.db == nil {
+			return errors.New("sqlite is not started")
+		}
+		return s.db.PingContext(ctx)
+	})
+}
+
+// URI returns the data source name of the database
+func (s *SQLite) URI() string {
+	return s.cfg.dsn
+}
+
+// DB returns the connection pool of a started database, nil before Start
+func (s *SQLite) DB() *sql.DB {
+	return s.db
+}
+
+// isRegistered reports whether a database/sql driver is registered with name
+func isRegistered(name string) bool {
+	drivers := sql.Drivers()
+	i := sort.SearchStrings(drivers, name)
+	return i < len(drivers) && drivers[i] == name
+}
+
+// isMemory reports whether dsn is an in memory database
+func isMemory(dsn string) bool {
+	return strings.Contains(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+}
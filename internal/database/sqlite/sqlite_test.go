@@ -0,0 +1,220 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingDriver is a database/sql driver recording executed statements
+type recordingDriver struct {
+	mu    sync.Mutex
+	execs []string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d: d}, nil }
+
+func (d *recordingDriver) statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.execs...)
+}
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *recordingConn) Close() error                        { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.execs = append(c.d.execs, query)
+	return driver.RowsAffected(0), nil
+}
+
+var drivers int32
+
+// newRecordingDriver registers a recording driver under a unique name, drivers can't be unregistered
+func newRecordingDriver(t *testing.T) (string, *recordingDriver) {
+	d := &recordingDriver{}
+	name := fmt.Sprintf("dbctl_recording_%d", atomic.AddInt32(&drivers, 1))
+	sql.Register(name, d)
+	return name, d
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write file failed %s", err)
+	}
+}
+
+// writeMigrations writes up and down migrations and fixtures to dir, returning their directories
+func writeMigrations(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	migrations := filepath.Join(dir, "migrations")
+	fixtures := filepath.Join(dir, "fixtures")
+	for _, d := range []string{migrations, fixtures} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatalf("mkdir failed %s", err)
+		}
+	}
+
+	writeFile(t, filepath.Join(migrations, "001_users.up.sql"), "create table users(id int, name text default 'a;b');\n-- drop; later\ncreate index users_name on users(name);")
+	writeFile(t, filepath.Join(migrations, "001_users.down.sql"), "drop table users;")
+	writeFile(t, filepath.Join(migrations, "002_email.up.sql"), "/* adds; email */ alter table users add column email text;")
+	writeFile(t, filepath.Join(fixtures, "users.sql"), "insert into users values (1, 'x', 'x@dbctl');;\ninsert into users(id) values (2);")
+	return migrations, fixtures
+}
+
+func TestStartAppliesMigrationsAndFixtures(t *testing.T) {
+	migrations, fixtures := writeMigrations(t, t.TempDir())
+
+	db, err := New(WithDSN(":memory:"), WithMigrations(migrations), WithFixtures(fixtures), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	ctx := context.Background()
+	if err := db.Start(ctx, true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+
+	if err := db.WaitForStart(ctx, time.Second); err != nil {
+		t.Fatalf("WaitForStart failed %s", err)
+	}
+
+	var index string
+	if err := db.DB().QueryRowContext(ctx, "select name from sqlite_master where type = 'index' and tbl_name = 'users'").Scan(&index); err != nil || index != "users_name" {
+		t.Fatalf("expected users_name index, got %q: %v", index, err)
+	}
+
+	var name, email string
+	if err := db.DB().QueryRowContext(ctx, "select name, coalesce(email, '') from users where id = 2").Scan(&name, &email); err != nil {
+		t.Fatalf("query users failed %s", err)
+	}
+	if name != "a;b" || email != "" {
+		t.Fatalf("expected default name and no email, got %q %q", name, email)
+	}
+
+	var n int
+	if err := db.DB().QueryRowContext(ctx, "select count(*) from users").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("expected 2 users, got %d: %v", n, err)
+	}
+}
+
+func TestWithDriver(t *testing.T) {
+	migrations, fixtures := writeMigrations(t, t.TempDir())
+
+	name, d := newRecordingDriver(t)
+	db, err := New(WithDriver(name), WithMigrations(migrations), WithFixtures(fixtures), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if err := db.Start(context.Background(), true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Stop(context.Background())
+	})
+
+	want := []string{
+		"create table users(id int, name text default 'a;b')",
+		"-- drop; later\ncreate index users_name on users(name)",
+		"/* adds; email */ alter table users add column email text",
+		"insert into users values (1, 'x', 'x@dbctl')",
+		"insert into users(id) values (2)",
+	}
+	if got := d.statements(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected statements %q", got)
+	}
+}
+
+func TestStartMigrationError(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "001_fail.sql")
+	writeFile(t, file, "create table users(id int);\nselect fail from users;")
+
+	db, err := New(WithDSN(":memory:"), WithMigrations(file), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	err = db.Start(context.Background(), true)
+	if err == nil || !strings.Contains(err.Error(), "001_fail.sql") || !strings.Contains(err.Error(), "no such column") {
+		t.Fatalf("expected migration error naming the file, got %v", err)
+	}
+	if db.DB() != nil {
+		t.Fatalf("expected database to be closed after failed start")
+	}
+}
+
+func TestStartUnregisteredDriver(t *testing.T) {
+	db, err := New(WithDriver("dbctl_missing"))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	err = db.Start(context.Background(), true)
+	if err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("expected not registered error, got %v", err)
+	}
+}
+
+func TestFileLifecycle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	migrations, _ := writeMigrations(t, t.TempDir())
+	db, err := New(WithFile(file), WithMigrations(migrations), WithLogger(io.Discard))
+	if err != nil {
+		t.Fatalf("New failed %s", err)
+	}
+
+	if db.URI() != "file:"+file {
+		t.Fatalf("unexpected uri %q", db.URI())
+	}
+
+	if err := db.Start(context.Background(), true); err != nil {
+		t.Fatalf("Start failed %s", err)
+	}
+
+	// the database is reachable by its uri while started
+	conn, err := sql.Open(DefaultDriver, db.URI())
+	if err != nil {
+		t.Fatalf("open failed %s", err)
+	}
+	var n int
+	err = conn.QueryRow("select count(*) from users").Scan(&n)
+	_ = conn.Close()
+	if err != nil {
+		t.Fatalf("expected migrated database file, got %s", err)
+	}
+
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed %s", err)
+	}
+
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected database file to be removed, got %v", err)
+	}
+
+	// stop is idempotent
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatalf("second Stop failed %s", err)
+	}
+}
@@ -1,6 +1,9 @@
 package logger
 
-import "log"
+import (
+	"io"
+	"log"
+)
 
 // Provider is the interface that wraps the Println method.
 type Provider interface {
@@ -61,6 +64,11 @@ func SetLevel(loglvl LogLevel) {
 	logger.logLvl = loglvl
 }
 
+// Enabled reports whether messages of the given level are logged.
+func Enabled(lvl LogLevel) bool {
+	return lvl >= logger.logLvl
+}
+
 // logger is the default logger.
 var logger = New(log.Default(), LevelDebug)
 
@@ -101,6 +109,24 @@ func Error(v ...any) {
 	printWithLevel(LevelError, v...)
 }
 
+// InfoTo writes v with INFO prefix to w in the format of the default logger, or calls Info when w is nil.
+func InfoTo(w io.Writer, v ...any) {
+	PrintTo(w, LevelInfo, v...)
+}
+
+// PrintTo writes v with the prefix of lvl to w in the format of the default logger, or logs it with the
+// default logger when w is nil.
+func PrintTo(w io.Writer, lvl LogLevel, v ...any) {
+	if logger.logLvl > lvl {
+		return
+	}
+	if w == nil {
+		printWithLevel(lvl, v...)
+		return
+	}
+	log.New(w, "", log.LstdFlags).Println(append([]any{lvl.String() + ":"}, v...)...)
+}
+
 func printWithLevel(lvl LogLevel, v ...any) {
 	logger.provider.Println(append([]any{lvl.String() + ":"}, v...)...)
 }
@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -98,3 +99,35 @@ func GetAvailablePort() int {
 	}
 	return port
 }
+
+// WaitFor calls probe with an exponential backoff, starting at interval and capped at maxInterval,
+// until it succeeds, the timeout is reached or ctx is cancelled. On timeout or cancellation
+// the returned error wraps both the context error and the last error returned by probe.
+func WaitFor(ctx context.Context, timeout, interval, maxInterval time.Duration, probe func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		lastErr := probe(ctx)
+		if lastErr == nil {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// probe might have returned the context error itself
+			if errors.Is(lastErr, ctx.Err()) {
+				return lastErr
+			}
+			return fmt.Errorf("%w: %w", ctx.Err(), lastErr)
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
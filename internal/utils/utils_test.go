@@ -1,6 +1,11 @@
 package utils
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
 
 func TestGetListHash(t *testing.T) {
 	list := []string{"a", "b", "c"}
@@ -13,3 +18,55 @@ func TestGetListHash(t *testing.T) {
 		t.Fatalf("expected dcd229f9224c1d8a1b514239d207f5be800d6a78001e5f550263db0fd05ff979, got %s", hash)
 	}
 }
+
+func TestWaitForRetriesUntilReady(t *testing.T) {
+	// simulates a server accepting connections but rejecting queries for the first attempts
+	var attempts int
+	probe := func(ctx context.Context) error {
+		attempts++
+		if attempts <= 3 {
+			return errors.New("pq: the database system is starting up")
+		}
+		return nil
+	}
+
+	if err := WaitFor(context.Background(), 5*time.Second, 10*time.Millisecond, time.Second, probe); err != nil {
+		t.Fatalf("WaitFor failed %s", err)
+	}
+
+	if attempts != 4 {
+		t.Fatalf("expected 4 attempts, got %d", attempts)
+	}
+}
+
+func TestWaitForStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := WaitFor(ctx, time.Minute, 10*time.Millisecond, time.Second, func(ctx context.Context) error {
+		return errors.New("not ready")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected WaitFor to return promptly after cancel, took %s", elapsed)
+	}
+}
+
+func TestWaitForTimeoutReturnsLastError(t *testing.T) {
+	probeErr := errors.New("connection refused")
+	err := WaitFor(context.Background(), 50*time.Millisecond, 10*time.Millisecond, time.Second, func(ctx context.Context) error {
+		return probeErr
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	if !errors.Is(err, probeErr) {
+		t.Fatalf("expected the last probe error to be wrapped, got %v", err)
+	}
+}
@@ -11,18 +11,22 @@ import (
 	"github.com/mirzakhany/dbctl/cmd/describe"
 	"github.com/mirzakhany/dbctl/cmd/start"
 	"github.com/mirzakhany/dbctl/cmd/testing"
+	"github.com/mirzakhany/dbctl/internal/container"
 )
 
 // version will be populated by the build script with the sha of the last git commit.
 var version = "snapshot"
 
 func main() {
+	container.DBctlVersion = version
+
 	root := cmd.GetRootCmd(version)
 	root.SetVersionTemplate(fmt.Sprintf("dbctl version %s\n", version))
 
 	root.AddCommand(start.GetStartCmd())
 	root.AddCommand(cmd.GetStopCmd())
 	root.AddCommand(cmd.GetListCmd())
+	root.AddCommand(cmd.GetDoctorCmd())
 	root.AddCommand(cmd.GetSelfUpdateCmd(version))
 	root.AddCommand(cmd.GetTestingAPIServerCmd())
 	root.AddCommand(describe.GetDescribeCmd())